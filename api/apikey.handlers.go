@@ -0,0 +1,250 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+)
+
+// apiKeyPrefix marks photostock API keys so they are easy to recognise (e.g. by secret scanners)
+const apiKeyPrefix = "psk_"
+
+// validateScopes normalizes and de-duplicates requested scopes, rejecting unknown ones
+func validateScopes(scopes []string) ([]string, error) {
+	var out []string
+	for _, s := range scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(models.APIKeyScopes, s) {
+			return nil, fmt.Errorf("Unknown scope: %s", s)
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("At least one scope is required")
+	}
+	return out, nil
+}
+
+// ListAPIKeys returns the API keys of the logged-in user
+func (app *application) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool             `json:"error"`
+		Message string           `json:"message"`
+		Scopes  []string         `json:"available_scopes"`
+		APIKeys []*models.APIKey `json:"api_keys"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	keys, err := app.DB.APIKeyRepo.GetAllByUserID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListAPIKeys =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "API keys fetched successfully"
+	Resp.Scopes = models.APIKeyScopes
+	Resp.APIKeys = keys
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// CreateAPIKey issues a new API key. The plain key is only returned in this response.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Label         string   `json:"label"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 = never expires
+	}
+	var Resp struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Key     string         `json:"key,omitempty"`
+		APIKey  *models.APIKey `json:"api_key,omitempty"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	label := strings.TrimSpace(payload.Label)
+	if label == "" || len(label) > 100 {
+		Resp.Error = true
+		Resp.Message = "Label is required (max 100 characters)"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	scopes, err := validateScopes(payload.Scopes)
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	secret, err := generateOpaqueToken(32)
+	if err != nil {
+		app.errorLog.Println("ERROR: CreateAPIKey => unable to generate key:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	plainKey := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:  token.ID,
+		Label:   label,
+		Prefix:  plainKey[:len(apiKeyPrefix)+8],
+		KeyHash: hashToken(plainKey),
+		Scopes:  scopes,
+	}
+	if payload.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, payload.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := app.DB.APIKeyRepo.Create(r.Context(), key); err != nil {
+		app.errorLog.Println("ERROR: CreateAPIKey => unable to store key:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "API key created. Copy it now, it will not be shown again"
+	Resp.Key = plainKey
+	Resp.APIKey = key
+	app.writeJSON(w, http.StatusCreated, Resp)
+}
+
+// UpdateAPIKey changes the label and scopes of an API key
+func (app *application) UpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Label  string   `json:"label"`
+		Scopes []string `json:"scopes"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	label := strings.TrimSpace(payload.Label)
+	if label == "" || len(label) > 100 {
+		Resp.Error = true
+		Resp.Message = "Label is required (max 100 characters)"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	scopes, err := validateScopes(payload.Scopes)
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	err = app.DB.APIKeyRepo.Update(r.Context(), &models.APIKey{ID: id, UserID: token.ID, Label: label, Scopes: scopes})
+	if err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			Resp.Error = true
+			Resp.Message = "API key not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: UpdateAPIKey =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "API key updated successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RevokeAPIKey permanently disables an API key
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	if err := app.DB.APIKeyRepo.Revoke(r.Context(), id, token.ID); err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			Resp.Error = true
+			Resp.Message = "API key not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: RevokeAPIKey =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "API key revoked"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/fingerprint"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// fingerprintJPEGQuality keeps re-encoded premium downloads close to their original
+const fingerprintJPEGQuality = 95
+
+// acquireImageSlot waits for one of the slots bounding how many images are decoded at once.
+// The caller releases it with <-app.transformSlots.
+func (app *application) acquireImageSlot(ctx context.Context) error {
+	select {
+	case app.transformSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fingerprintDownload returns the image file at path, in its own format, with the download
+// id embedded invisibly. JPEG files keep their EXIF, XMP, ICC profile and IPTC metadata.
+func (app *application) fingerprintDownload(ctx context.Context, path string, id int) ([]byte, error) {
+	format, err := imaging.FormatFromFilename(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.acquireImageSlot(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { <-app.transformSlots }()
+
+	// Keep the stored orientation, the EXIF orientation tag is copied as is
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	marked, err := fingerprint.Embed(img, []byte(app.config.fingerprint.secret), uint32(id))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, marked, format, imaging.JPEGQuality(fingerprintJPEGQuality)); err != nil {
+		return nil, err
+	}
+	if format == imaging.JPEG {
+		return utils.WithJPEGMetadata(buf.Bytes(), data), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// traceCandidates returns the suspect image as is and, when it was resized, scaled back to
+// each size the media was delivered in, upright or turned a quarter
+func traceCandidates(img image.Image, media *models.Media) []image.Image {
+	candidates := []image.Image{img}
+	if media == nil {
+		return candidates
+	}
+	b := img.Bounds()
+	for _, s := range mediaSizes(media) {
+		for _, size := range [][2]int{{s.Width, s.Height}, {s.Height, s.Width}} {
+			if size[0] == b.Dx() && size[1] == b.Dy() {
+				continue
+			}
+			// Only sizes the suspect could have been scaled from without distortion
+			ratio := float64(size[0]) / float64(size[1])
+			if r := float64(b.Dx()) / float64(b.Dy()); r < ratio*0.98 || r > ratio*1.02 {
+				continue
+			}
+			candidates = append(candidates, imaging.Resize(img, size[0], size[1], imaging.Lanczos))
+		}
+	}
+	return candidates
+}
+
+// TraceDownload reads the download ID fingerprinted in a leaked premium image and returns
+// the download record with its user. The user is left out when the account has since been deleted.
+// Form fields: "image", the suspect file, and optionally "media_id", which lets resized
+// copies be scaled back to the sizes that media was sold in.
+func (app *application) TraceDownload(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error    bool                    `json:"error"`
+		Message  string                  `json:"message"`
+		Download *models.DownloadHistory `json:"download,omitempty"`
+		User     *models.User            `json:"user,omitempty"`
+		Media    *models.Media           `json:"media,omitempty"`
+	}
+
+	if err := r.ParseMultipartForm(20 << 20); err != nil { // 20MB max
+		app.badRequest(w, errors.New("the image must be at most 20 MB"))
+		return
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		app.badRequest(w, errors.New("image file required"))
+		return
+	}
+	defer file.Close()
+	img, err := imaging.Decode(file)
+	if err != nil {
+		app.badRequest(w, errors.New("unable to decode the image"))
+		return
+	}
+
+	var media *models.Media
+	if v := strings.TrimSpace(r.FormValue("media_id")); v != "" {
+		mediaID, err := strconv.Atoi(v)
+		if err != nil || mediaID <= 0 {
+			app.badRequest(w, errors.New("invalid media ID"))
+			return
+		}
+		media, err = app.DB.MediaRepo.GetByID(r.Context(), mediaID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			app.badRequest(w, errors.New("media not found"))
+			return
+		}
+		if err != nil {
+			app.errorLog.Println("ERROR: TraceDownload =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal Server Error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+	}
+
+	if err := app.acquireImageSlot(r.Context()); err != nil {
+		return
+	}
+	id, err := uint32(0), fingerprint.ErrNotFound
+	for _, candidate := range traceCandidates(img, media) {
+		if id, err = fingerprint.Extract(candidate, []byte(app.config.fingerprint.secret)); err == nil {
+			break
+		}
+	}
+	<-app.transformSlots
+	if errors.Is(err, fingerprint.ErrTooSmall) {
+		app.badRequest(w, errors.New("the image is too small to carry a fingerprint"))
+		return
+	}
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = "No download fingerprint found in this image"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	download, err := app.DB.DownloadHistoryRepo.GetByID(r.Context(), int(id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		Resp.Error = true
+		Resp.Message = "Fingerprint found for download " + strconv.Itoa(int(id)) + ", but the download record no longer exists"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+	if err != nil {
+		app.errorLog.Println("ERROR: TraceDownload =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	var user *models.User
+	if download.UserID != nil {
+		user, err = app.DB.UserRepo.GetByID(r.Context(), *download.UserID)
+		if err != nil {
+			app.errorLog.Println("ERROR: TraceDownload =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal Server Error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+		user.Password = ""
+	}
+	if media == nil || media.MediaUUID != download.MediaUUID {
+		// Media deleted since the download are left out
+		media, err = app.DB.MediaRepo.GetByMediaUUID(r.Context(), download.MediaUUID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			app.errorLog.Println("ERROR: TraceDownload =>", err)
+		}
+		if err != nil {
+			media = nil
+		}
+	}
+	app.audit(r, "download.trace", "download_history", strconv.Itoa(download.ID), map[string]any{"user_id": download.UserID})
+
+	Resp.Error = false
+	Resp.Message = "Download traced successfully"
+	if user == nil {
+		Resp.Message = "Download traced, the account that made it has been deleted"
+	}
+	Resp.Download = download
+	Resp.User = user
+	Resp.Media = media
+	app.writeJSON(w, http.StatusOK, Resp)
+}
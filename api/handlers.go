@@ -1,7 +1,9 @@
 package api
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	_ "image/gif"
@@ -13,12 +15,18 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/samiulice/photostock/internal/fingerprint"
+	"github.com/samiulice/photostock/internal/imagemeta"
 	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
 	"github.com/samiulice/photostock/internal/utils"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -39,7 +47,7 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 	user.Username = strings.Split(user.Email, "@")[0] + "_" + app.GenerateRandomAlphanumericCode(4)
 	user.Name = strings.TrimSpace(user.Name)
 	user.Status = true
-	user.Role = "user"
+	user.Role = models.RoleUser
 	user.Password = strings.TrimSpace(user.Password)
 	user.AvatarID = strings.Split(user.Email, "@")[0] + "_" + uuid.NewString() + ".jpg"
 	//hash password
@@ -66,11 +74,15 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 
 	//sanitize input
 	user.Password = ""
+	//confirm the address belongs to the user
+	if err := app.sendVerificationEmail(&user); err != nil {
+		app.errorLog.Println("ERROR: SignUp => Unable to send verification email: ", err)
+	}
 	//after adding user successfully, go to the login process
 	//Generate signed token
-	token, err := generateSignedToken(&user)
+	token, refreshToken, err := app.issueTokenPair(r, &user, false)
 	if err != nil {
-		app.errorLog.Println("ERROR: SignUp => Unable to generate token for user: ", user.Username)
+		app.errorLog.Println("ERROR: SignUp => Unable to generate token for user: ", user.Username, err)
 		app.badRequest(w, errors.New("Internal server error"))
 		return
 	}
@@ -79,38 +91,94 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare and send response
 	response := struct {
-		Error   bool         `json:"error"`
-		Message string       `json:"message"`
-		Token   string       `json:"token"`
-		User    *models.User `json:"user"`
+		Error        bool         `json:"error"`
+		Message      string       `json:"message"`
+		Token        string       `json:"token"`
+		RefreshToken string       `json:"refresh_token"`
+		User         *models.User `json:"user"`
 	}{
-		Error:   false,
-		Message: "Registration successful. Auto sign-in complete.",
-		Token:   token,
-		User:    &user,
+		Error:        false,
+		Message:      "Registration successful. Auto sign-in complete. Please check your inbox to verify your email.",
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         &user,
 	}
 
 	app.writeJSON(w, http.StatusOK, response)
 }
 
-// generateSignedToken generate a token string for implementing JWT
-func generateSignedToken(user *models.User) (string, error) {
+// generateSignedToken generate a short-lived access token string for implementing JWT.
+// The token is tied to the login session (refresh token family) it was issued for so it can be revoked.
+// It carries the permissions of the user's role so authorization checks need no database lookup.
+func (app *application) generateSignedToken(ctx context.Context, user *models.User, session *models.RefreshToken) (string, error) {
+	claims, err := app.accessTokenClaims(ctx, user, session.FamilyID, session.MFA)
+	if err != nil {
+		return "", err
+	}
+
+	// Sign the token with the current key of the configured algorithm
+	return app.Keys.Sign(claims)
+}
+
+// accessTokenClaims returns the claims of an access token for user issued in the session sid
+func (app *application) accessTokenClaims(ctx context.Context, user *models.User, sid string, mfa bool) (jwt.MapClaims, error) {
+	perms, err := app.DB.RoleRepo.GetPermissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create JWT claims
-	claims := jwt.MapClaims{
+	return jwt.MapClaims{
 		"id":       user.ID,
 		"name":     user.Name,
 		"username": user.Username,
 		"email":    user.Email,
 		"role":     user.Role,
+		"perms":    perms,
+		"typ":      "access",
+		"sid":      sid,
+		"mfa":      mfa,
 		"iss":      app.config.jwt.issuer,
 		"aud":      app.config.jwt.audience,
 		"exp":      time.Now().Add(app.config.jwt.expiry).Unix(),
 		"iat":      time.Now().Unix(),
+	}, nil
+}
+
+// issueTokenPair starts a new login session for the user and returns
+// a short-lived access token together with the first refresh token of the session.
+// mfa records whether the login completed a second factor.
+func (app *application) issueTokenPair(r *http.Request, user *models.User, mfa bool) (string, string, error) {
+	refreshToken, err := generateOpaqueToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	session := &models.Session{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+		ExpiresAt: time.Now().Add(app.config.jwt.refresh),
+	}
+	if err := app.DB.SessionRepo.Create(r.Context(), session); err != nil {
+		return "", "", err
+	}
+	rt := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  session.ID,
+		TokenHash: hashToken(refreshToken),
+		MFA:       mfa,
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := app.DB.RefreshTokenRepo.Create(r.Context(), rt); err != nil {
+		return "", "", err
 	}
 
-	// Sign the token with the secret key
-	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.config.jwt.secretKey))
-	return tokenString, err
+	token, err := app.generateSignedToken(r.Context(), user, rt)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
 }
 
 // Login authenticates the user and generates a JWT token for them.
@@ -125,11 +193,17 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Throttle repeated failures per account and per client IP before doing any bcrypt work
+	if !app.loginAllowed(w, r, user.Email) {
+		return
+	}
+
 	// Lookup user by username
 	validUser, err := app.DB.UserRepo.GetByEmail(r.Context(), user.Email)
 	if err != nil {
 		app.errorLog.Println("ERROR: User lookup failed -", err)
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
+			app.loginFailed(r, user.Email)
 			app.badRequest(w, errors.New("Wrong username or password"))
 		} else {
 			app.badRequest(w, errors.New("Failed to retrieve user"+err.Error()))
@@ -147,14 +221,52 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	// Compare password hash
 	if err := bcrypt.CompareHashAndPassword([]byte(validUser.Password), []byte(user.Password)); err != nil {
 		app.errorLog.Printf("ERROR: Password mismatch for user: %s", user.Username)
+		app.loginFailed(r, user.Email)
 		app.badRequest(w, errors.New("Wrong username or password"))
 		return
 	}
 
+	app.finishSignIn(w, r, validUser)
+}
+
+// finishSignIn completes a sign-in after the user proved their identity (password or external provider).
+// Accounts with two-factor authentication get a pending token instead of a session.
+func (app *application) finishSignIn(w http.ResponseWriter, r *http.Request, validUser *models.User) {
+	// Accounts with two-factor authentication get a short-lived pending token
+	// that must be exchanged together with a TOTP code at /auth/mfa/verify
+	mfaEnabled, err := app.DB.MFARepo.IsEnabled(r.Context(), validUser.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: Unable to check two-factor status for user: ", validUser.Username, err)
+		app.badRequest(w, errors.New("Internal server error"))
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := app.generateMFAPendingToken(validUser)
+		if err != nil {
+			app.errorLog.Println("ERROR: Unable to generate mfa token for user: ", validUser.Username, err)
+			app.badRequest(w, errors.New("Internal server error"))
+			return
+		}
+		app.writeJSON(w, http.StatusOK, struct {
+			Error       bool   `json:"error"`
+			Message     string `json:"message"`
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}{
+			Error:       false,
+			Message:     "Two-factor authentication required",
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	app.loginSucceeded(r, validUser.Email)
+
 	//Generate signed token
-	token, err := generateSignedToken(validUser)
+	token, refreshToken, err := app.issueTokenPair(r, validUser, false)
 	if err != nil {
-		app.errorLog.Println("ERROR: Unable to generate token for user: ", user.Username)
+		app.errorLog.Println("ERROR: Unable to generate token for user: ", validUser.Username, err)
 		app.badRequest(w, errors.New("Internal server error"))
 		return
 	}
@@ -163,21 +275,149 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare and send response
 	response := struct {
-		Error   bool         `json:"error"`
-		Message string       `json:"message"`
-		Token   string       `json:"token"`
-		User    *models.User `json:"user"`
+		Error            bool         `json:"error"`
+		Message          string       `json:"message"`
+		Token            string       `json:"token"`
+		RefreshToken     string       `json:"refresh_token"`
+		MFASetupRequired bool         `json:"mfa_setup_required,omitempty"`
+		User             *models.User `json:"user"`
 	}{
-		Error:   false,
-		Message: "Sign in successful",
-		Token:   token,
-		User:    validUser,
+		Error:            false,
+		Message:          "Sign in successful",
+		Token:            token,
+		RefreshToken:     refreshToken,
+		MFASetupRequired: app.config.auth.enforceAdminMFA && validUser.Role == models.RoleAdmin,
+		User:             validUser,
 	}
 
-	app.infoLog.Printf("User %s signed in successfully", user.Username)
+	app.infoLog.Printf("User %s signed in successfully", validUser.Username)
 	app.writeJSON(w, http.StatusOK, response)
 }
 
+// RefreshToken exchanges a valid refresh token for a new access token and a rotated refresh token.
+// Presenting a refresh token that was already rotated revokes the whole login session.
+func (app *application) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var Resp struct {
+		Error        bool   `json:"error"`
+		Message      string `json:"message"`
+		Token        string `json:"token,omitempty"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil || strings.TrimSpace(payload.RefreshToken) == "" {
+		app.errorLog.Println("ERROR: RefreshToken => missing refresh token")
+		Resp.Error = true
+		Resp.Message = "Refresh token required"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	newRefreshToken, err := generateOpaqueToken(32)
+	if err != nil {
+		app.errorLog.Println("ERROR: RefreshToken => unable to generate token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	next := &models.RefreshToken{
+		TokenHash: hashToken(newRefreshToken),
+		ExpiresAt: time.Now().Add(app.config.jwt.refresh),
+	}
+	err = app.DB.RefreshTokenRepo.Rotate(r.Context(), hashToken(strings.TrimSpace(payload.RefreshToken)), next)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrRefreshTokenReused):
+			app.errorLog.Println("ERROR: RefreshToken => reuse detected, session revoked")
+			Resp.Message = "Session revoked. Please sign in again"
+		case errors.Is(err, repositories.ErrRefreshTokenExpired):
+			Resp.Message = "Refresh token expired. Please sign in again"
+		case errors.Is(err, repositories.ErrRefreshTokenNotFound):
+			Resp.Message = "Invalid refresh token"
+		default:
+			app.errorLog.Println("ERROR: RefreshToken => unable to rotate token:", err)
+			Resp.Error = true
+			Resp.Message = "Internal server error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+		Resp.Error = true
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	// Reload the user so role or status changes take effect on refresh
+	user, err := app.DB.UserRepo.GetByID(r.Context(), next.UserID)
+	if err != nil || !user.Status {
+		app.errorLog.Println("ERROR: RefreshToken => user unavailable:", next.UserID)
+		_, _ = app.DB.SessionRepo.Revoke(r.Context(), next.FamilyID, next.UserID)
+		Resp.Error = true
+		Resp.Message = "Account unavailable"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	token, err := app.generateSignedToken(r.Context(), user, next)
+	if err != nil {
+		app.errorLog.Println("ERROR: RefreshToken => unable to sign token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Token refreshed"
+	Resp.Token = token
+	Resp.RefreshToken = newRefreshToken
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// Logout revokes the login session the given refresh token belongs to.
+// Access tokens issued for the session stop being accepted immediately.
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil || strings.TrimSpace(payload.RefreshToken) == "" {
+		app.errorLog.Println("ERROR: Logout => missing refresh token")
+		Resp.Error = true
+		Resp.Message = "Refresh token required"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	rt, err := app.DB.RefreshTokenRepo.GetByHash(r.Context(), hashToken(strings.TrimSpace(payload.RefreshToken)))
+	if err != nil && !errors.Is(err, repositories.ErrRefreshTokenNotFound) {
+		app.errorLog.Println("ERROR: Logout => token lookup failed:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if rt != nil {
+		if _, err := app.DB.SessionRepo.Revoke(r.Context(), rt.FamilyID, rt.UserID); err != nil {
+			app.errorLog.Println("ERROR: Logout => unable to revoke session:", err)
+			Resp.Error = true
+			Resp.Message = "Internal server error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+	}
+
+	Resp.Error = false
+	Resp.Message = "Signed out successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
 // Profile return the profile info of a user by username from request context
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
 	token, ok := app.GetUserTokenFromContext(r.Context())
@@ -201,7 +441,8 @@ func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
 	var cat []*models.MediaCategory
 	var sbp []*models.SubscriptionPlan
 
-	if user.Role == "user" {
+	// Staff with report access get the site-wide overview instead of their own history
+	if !hasPermission(token, models.PermReportsRead) {
 		//Subscriptions History
 		sbh, err = app.DB.SubscriptionRepo.GetByUserID(r.Context(), user.ID)
 		if err != nil {
@@ -422,6 +663,7 @@ func (app *application) DeactivateProfile(w http.ResponseWriter, r *http.Request
 		Resp.Error = true
 		Resp.Message = "Invalid token: Access denied"
 		app.writeJSON(w, http.StatusOK, Resp)
+		return
 	}
 
 	status, err := strconv.ParseBool(r.URL.Query().Get("status"))
@@ -430,6 +672,7 @@ func (app *application) DeactivateProfile(w http.ResponseWriter, r *http.Request
 		Resp.Error = true
 		Resp.Message = "Invalid account status"
 		app.writeJSON(w, http.StatusOK, Resp)
+		return
 	}
 	err = app.DB.UserRepo.Deactivate(r.Context(), token.ID, status)
 	if err != nil {
@@ -437,6 +680,13 @@ func (app *application) DeactivateProfile(w http.ResponseWriter, r *http.Request
 		Resp.Error = true
 		Resp.Message = "Invalid token: Access denied"
 		app.writeJSON(w, http.StatusOK, Resp)
+		return
+	}
+	// A deactivated account is signed out everywhere
+	if !status {
+		if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), token.ID); err != nil {
+			app.errorLog.Println("ERROR: DeactivateProfile => unable to revoke sessions:", err)
+		}
 	}
 	Resp.Error = false
 	Resp.Message = "Profile details updated successfully"
@@ -669,54 +919,102 @@ func (app *application) DeleteMediaCategory(w http.ResponseWriter, r *http.Reque
 }
 
 // Media content management
+// ListMedia returns a page of approved media. See parseMediaFilter for the filters;
+// ?sort= is newest (default), oldest, downloads or earnings, ?limit= the page size
+// and ?cursor= a next_cursor or prev_cursor of a previous response.
 func (app *application) ListMedia(w http.ResponseWriter, r *http.Request) {
-	category := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("category")))
+	app.listMedia(w, r, models.MediaSortNewest, func(f *models.MediaFilter) error {
+		f.Status = models.MediaStatusApproved
+		return nil
+	})
+}
+
+// listMedia writes a page of media matching the query filters. scope restricts the
+// filter to what the caller may see; an error from it is reported as a bad request.
+func (app *application) listMedia(w http.ResponseWriter, r *http.Request, defaultSort string, scope func(*models.MediaFilter) error) {
 	var Resp struct {
-		Error   bool            `json:"error"`
-		Message string          `json:"message"`
-		Medias  []*models.Media `json:"medias"`
+		Error      bool                `json:"error"`
+		Message    string              `json:"message"`
+		Medias     []*models.Media     `json:"medias"`
+		Total      int                 `json:"total"`
+		NextCursor string              `json:"next_cursor,omitempty"`
+		PrevCursor string              `json:"prev_cursor,omitempty"`
+		Facets     *models.MediaFacets `json:"facets,omitempty"`
+	}
+
+	query := r.URL.Query()
+	filter, err := parseMediaFilter(query)
+	if err == nil {
+		err = scope(&filter)
+	}
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	sort := strings.ToLower(strings.TrimSpace(query.Get("sort")))
+	if sort == "" {
+		sort = defaultSort
 	}
-	categoryID, err := strconv.Atoi(category)
-	if err != nil || category == "" {
-		app.errorLog.Println("Please specify image category id")
+	if !slices.Contains([]string{models.MediaSortNewest, models.MediaSortOldest, models.MediaSortDownloads, models.MediaSortEarnings}, sort) {
 		Resp.Error = true
-		Resp.Message = "Please specify image category id"
+		Resp.Message = "sort must be newest, oldest, downloads or earnings"
 		app.writeJSON(w, http.StatusBadRequest, Resp)
 		return
 	}
-
-	var list []*models.Media
+	limit := 24
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+	var cursor *models.MediaCursor
+	if v := query.Get("cursor"); v != "" {
+		if cursor, err = decodeMediaCursor(v, sort); err != nil {
+			Resp.Error = true
+			Resp.Message = "Invalid cursor"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+	}
 
 	//get the images metadata from database
-	if category == "0" {
-		list, err = app.DB.MediaRepo.GetAll(r.Context())
-	} else {
-		list, err = app.DB.MediaRepo.GetAllByCategoryID(r.Context(), categoryID)
+	list, more, err := app.DB.MediaRepo.List(r.Context(), filter, sort, cursor, limit)
+	if err == nil {
+		Resp.Total, err = app.DB.MediaRepo.Count(r.Context(), filter)
 	}
-
-	if err != nil && err != sql.ErrNoRows {
+	if err == nil && wantFacets(query) {
+		Resp.Facets, err = app.DB.MediaRepo.Facets(r.Context(), "", filter)
+	}
+	if err != nil {
 		app.errorLog.Println("Could not get image metadata: ", err)
 		Resp.Error = true
 		Resp.Message = "Image metadata can't be loaded"
-		app.writeJSON(w, http.StatusBadRequest, Resp)
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
 		return
 	}
 
-	fileDir := filepath.Join(".", "assets", "images", "public", "thumbnails")
-	for _, v := range list {
-		_, err := os.Stat(filepath.Join(fileDir, "thumb_"+v.MediaUUID))
-		if err == nil {
-			//TODO:
-			baseURL, _ := url.Parse(models.APIEndPoint)
-			baseURL.Path = path.Join(baseURL.Path, "public", "thumbnails", "thumb_"+v.MediaUUID)
-			v.MediaURL = baseURL.String()
-			v.MediaUUID = ""
-			Resp.Medias = append(Resp.Medias, v)
-		} else {
-			app.errorLog.Println(*v)
+	if len(list) > 0 {
+		first, last := list[0], list[len(list)-1]
+		// A page reached by paging back always has a next page, one reached by paging forward a previous one
+		backward := cursor != nil && cursor.Before
+		if more || backward {
+			Resp.NextCursor = encodeMediaCursor(last, sort, false)
+		}
+		if (backward && more) || (!backward && cursor != nil) {
+			Resp.PrevCursor = encodeMediaCursor(first, sort, true)
 		}
 	}
 
+	Resp.Medias = []*models.Media{}
+	for _, v := range list {
+		baseURL, _ := url.Parse(models.APIEndPoint)
+		baseURL.Path = path.Join(baseURL.Path, "public", "thumbnails", "thumb_"+v.MediaUUID)
+		v.MediaURL = baseURL.String()
+		v.Previews = app.mediaPreviews(v)
+		v.MediaUUID = ""
+		Resp.Medias = append(Resp.Medias, v)
+	}
+
 	Resp.Error = false
 	Resp.Message = "Images retrieved successfully"
 	app.writeJSON(w, http.StatusOK, Resp)
@@ -743,13 +1041,28 @@ func (app *application) FetchMediaDetails(w http.ResponseWriter, r *http.Request
 	//get the images metadata from database
 	media, err = app.DB.MediaRepo.GetByID(r.Context(), mediaID)
 
-	if err != nil && err != sql.ErrNoRows {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		app.errorLog.Println("Could not get image metadata: ", err)
 		Resp.Error = true
 		Resp.Message = "Image metadata can't be loaded"
 		app.writeJSON(w, http.StatusBadRequest, Resp)
 		return
 	}
+	// Only approved media are public, uploaders see theirs through /mine and reviewers through the review queue
+	if media == nil || media.Status != models.MediaStatusApproved {
+		Resp.Error = true
+		Resp.Message = "Images data not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	// Camera and rights details, the location stays private to the uploader
+	meta, err := app.DB.MediaRepo.GetMetadata(r.Context(), media.ID)
+	if err != nil {
+		app.errorLog.Println("Could not get embedded image metadata: ", err)
+	} else if meta != nil {
+		media.Metadata = meta.Public()
+	}
 
 	fileDir := filepath.Join(".", "assets", "images", "public", "thumbnails")
 	_, err = os.Stat(filepath.Join(fileDir, "thumb_"+media.MediaUUID))
@@ -757,6 +1070,8 @@ func (app *application) FetchMediaDetails(w http.ResponseWriter, r *http.Request
 		baseURL, _ := url.Parse(models.APIEndPoint)
 		baseURL.Path = path.Join(baseURL.Path, "public", "thumbnails", "thumb_"+media.MediaUUID)
 		media.MediaURL = baseURL.String()
+		media.Sizes = mediaSizes(media)
+		media.Previews = app.mediaPreviews(media)
 		media.MediaUUID = ""
 		Resp.Media = media
 	} else {
@@ -775,6 +1090,11 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	var Resp struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
+		MediaID int    `json:"media_id,omitempty"`
+		Status  string `json:"status,omitempty"`
+		// Previews and renditions are made in the background, see MediaProcessing
+		ProcessingStatus string `json:"processing_status,omitempty"`
+		JobID            int64  `json:"job_id,omitempty"`
 	}
 	err := r.ParseMultipartForm(20 << 20) // 20MB max
 	if err != nil {
@@ -795,8 +1115,31 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// Read the embedded captions and keywords to fill in the form before the file is copied.
+	// The metadata itself is stored by the background processing.
+	meta, err := imagemeta.Read(file)
+	if err != nil && !errors.Is(err, imagemeta.ErrUnsupported) {
+		app.errorLog.Println("Could not read image metadata", err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		app.errorLog.Println("Could not read image file", err.Error())
+		Resp.Error = true
+		Resp.Message = "Could not read image file"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
 	title := r.FormValue("media_title")
 	description := r.FormValue("description")
+	if meta != nil {
+		// Fields left empty are taken from the IPTC/XMP captions
+		if title == "" {
+			title = meta.Title
+		}
+		if description == "" {
+			description = meta.Description
+		}
+	}
 	catId := r.FormValue("category_id")
 	license_type := r.FormValue("license_type") // "free = 0" or "premium = 1"
 	//validate categoryId
@@ -830,6 +1173,32 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		app.writeJSON(w, http.StatusBadRequest, Resp)
 		return
 	}
+	// Only the header is decoded here, the image itself is processed in the background
+	width, height := utils.GetImageDimensions(handler)
+	if width == 0 {
+		Resp.Error = true
+		Resp.Message = "Unsupported or corrupt image file"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	// Uploads go to the review queue unless saved as a draft
+	status := models.MediaStatusPendingReview
+	if draft, _ := strconv.ParseBool(r.FormValue("draft")); draft {
+		status = models.MediaStatusDraft
+	}
+	tags := formTags(r.MultipartForm.Value["tags"])
+	if len(tags) > maxMediaTags {
+		Resp.Error = true
+		Resp.Message = fmt.Sprintf("At most %d tags are allowed", maxMediaTags)
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	if len(tags) == 0 && meta != nil {
+		tags = formTags(meta.Keywords)
+		if len(tags) > maxMediaTags {
+			tags = tags[:maxMediaTags]
+		}
+	}
 
 	// Generate safe filename
 	filename := app.GenerateSafeFilename("", handler)
@@ -860,7 +1229,8 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hasher), file)
 	if err != nil {
 		app.errorLog.Println("Error Saving file")
 		Resp.Error = true
@@ -868,16 +1238,23 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		app.writeJSON(w, http.StatusInternalServerError, Resp)
 		return
 	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
 
-	//save watermarked image
-	outputBaseDir := filepath.Join(".", "assets", "images", "public")
-	//save thumbnail
-	err = utils.GenerateImageVariants(dstPath, outputBaseDir, filename)
-	if err != nil {
-		app.errorLog.Println("Unable to save image variations: ", err.Error())
+	// Reject exact copies of media already in the catalog
+	duplicateID, err := app.DB.MediaRepo.GetIDBySHA256(r.Context(), checksum)
+	if err != nil || duplicateID != 0 {
+		dst.Close()
+		os.Remove(dstPath)
+		if err != nil {
+			app.errorLog.Println("Could not check for duplicates", err.Error())
+			Resp.Error = true
+			Resp.Message = "Could not save image metadata"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
 		Resp.Error = true
-		Resp.Message = "Unable to save image variations"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		Resp.Message = "This image has already been uploaded"
+		app.writeJSON(w, http.StatusConflict, Resp)
 		return
 	}
 
@@ -890,6 +1267,7 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		app.writeJSON(w, http.StatusUnauthorized, Resp)
 		return
 	}
+
 	// Save metadata to DB
 	imageMetadata := &models.Media{
 		MediaTitle:   title,
@@ -904,25 +1282,19 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		FileName:     title,
 		FileSize:     utils.GetFormattedFileSize(handler),
 		Resolution:   utils.GetImageResolutionString(handler),
+		Width:        width,
+		Height:       height,
+		Status:       status,
+		SHA256:       checksum,
+		Tags:         tags,
+		// The watermarked preview, thumbnail, renditions and perceptual hash are made by a job
+		ProcessingStatus: models.MediaProcessingQueued,
+	}
+	if meta != nil && meta.Orientation >= 5 {
+		// Orientations 5-8 are rotated a quarter turn, the image is displayed upright
+		imageMetadata.Width, imageMetadata.Height = imageMetadata.Height, imageMetadata.Width
+		imageMetadata.Resolution = fmt.Sprintf("%dx%dpx", imageMetadata.Width, imageMetadata.Height)
 	}
-	err = app.DB.MediaRepo.Create(r.Context(), imageMetadata)
-	if err != nil {
-		app.errorLog.Println("Could not save image metadata", err.Error())
-		Resp.Error = true
-		Resp.Message = "Could not save image metadata"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
-		return
-	}
-
-	err = app.DB.MediaCategoryRepo.IncrementDownloads(r.Context(), int64(categoryId))
-	if err != nil {
-		app.errorLog.Println("Could not save image metadata", err.Error())
-		Resp.Error = true
-		Resp.Message = "Could not save image metadata"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
-		return
-	}
-
 	h := &models.UploadHistory{
 		MediaUUID:  filename,
 		UserID:     token.ID,
@@ -930,21 +1302,41 @@ func (app *application) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		FileExt:    filepath.Ext(filename),
 		FileName:   title,
 		FileSize:   utils.GetFormattedFileSize(handler),
-		Resolution: utils.GetImageResolutionString(handler),
+		Resolution: imageMetadata.Resolution,
 		UploadedAt: time.Now(),
 	}
-	err = app.DB.UploadHistoryRepo.Create(r.Context(), h)
+	// The media is saved with its history, category count and processing job, or not at all
+	job, err := app.DB.MediaRepo.CreateUpload(r.Context(), imageMetadata, h, models.JobProcessMedia, app.config.jobs.maxAttempts)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		// The same image was uploaded concurrently
+		os.Remove(dstPath)
+		Resp.Error = true
+		Resp.Message = "This image has already been uploaded"
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	}
 	if err != nil {
-		app.errorLog.Println("Could not save upload history", err.Error())
+		app.errorLog.Println("Could not save image metadata", err.Error())
+		os.Remove(dstPath)
 		Resp.Error = true
 		Resp.Message = "Could not save image metadata"
 		app.writeJSON(w, http.StatusInternalServerError, Resp)
 		return
 	}
+	app.Jobs.Notify()
 
 	Resp.Error = false
-	Resp.Message = "Image uploaded successfully"
-	app.writeJSON(w, http.StatusCreated, Resp)
+	Resp.MediaID = imageMetadata.ID
+	Resp.Status = imageMetadata.Status
+	Resp.ProcessingStatus = imageMetadata.ProcessingStatus
+	Resp.JobID = job.ID
+	Resp.Message = "Image uploaded and submitted for review"
+	if status == models.MediaStatusDraft {
+		Resp.Message = "Image saved as a draft"
+	}
+	Resp.Message += "; its previews are being processed"
+	app.writeJSON(w, http.StatusAccepted, Resp)
 }
 
 func (app *application) ServeMedia(w http.ResponseWriter, r *http.Request) {
@@ -966,7 +1358,7 @@ func (app *application) ServeMedia(w http.ResponseWriter, r *http.Request) {
 	// 2. Get media from DB
 	media, err := app.DB.MediaRepo.GetByID(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			app.errorLog.Printf("Media not found for ID: %d", id)
 			Resp.Error = true
 			Resp.Message = "Media not found"
@@ -989,6 +1381,30 @@ func (app *application) ServeMedia(w http.ResponseWriter, r *http.Request) {
 		app.writeJSON(w, http.StatusUnauthorized, Resp)
 		return
 	}
+	if media.Status != models.MediaStatusApproved && !canManageMedia(token, media) {
+		Resp.Error = true
+		Resp.Message = "Media not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	// Requested size tier, the original by default
+	sizeName := r.URL.Query().Get("size")
+	if sizeName == "" {
+		sizeName = models.DownloadSizeOriginal
+	}
+	var size *models.MediaRendition
+	for _, s := range mediaSizes(media) {
+		if s.Size == sizeName {
+			size = s
+		}
+	}
+	if size == nil {
+		Resp.Error = true
+		Resp.Message = "This size is not available for this media"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
 
 	// 4. Fetch user from DB
 	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
@@ -1000,8 +1416,16 @@ func (app *application) ServeMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 5. If media is premium, check subscription
+	// 5. If media is premium, check email verification and subscription
 	if media.LicenseType == 1 {
+		if app.emailVerificationRequired(user) {
+			app.errorLog.Printf("User %d has not verified the email address", user.ID)
+			Resp.Error = true
+			Resp.Message = "Please verify your email address to download premium media"
+			app.writeJSON(w, http.StatusForbidden, Resp)
+			return
+		}
+
 		plan := user.CurrentSubscription
 		if plan == nil || plan.PlanDetails == nil || !plan.Status {
 			app.errorLog.Printf("User %d has no active subscription", user.ID)
@@ -1029,74 +1453,91 @@ func (app *application) ServeMedia(w http.ResponseWriter, r *http.Request) {
 			app.writeJSON(w, http.StatusForbidden, Resp)
 			return
 		}
-	}
 
-	// 8. Decrement user download limit if media is premium
-	if media.LicenseType == 1 {
-		err := app.DB.UserRepo.IncrementDownloadCounts(r.Context(), user.ID)
-		if err != nil {
-			app.errorLog.Printf("Failed to decrement download limit for user %d: %v", user.ID, err)
-		} else {
-			app.infoLog.Printf("Decremented download limit for user %d", user.ID)
+		if !planIncludesSize(plan.PlanDetails, size.Size) {
+			Resp.Error = true
+			Resp.Message = "Your plan does not include this size. Please upgrade your plan."
+			app.writeJSON(w, http.StatusForbidden, Resp)
+			return
 		}
 	}
 
-	// 9. Log download history
-	download := models.DownloadHistory{
-		MediaUUID:    media.MediaUUID,
-		UserID:       user.ID,
-		FileType:     media.FileType,
-		FileExt:      media.FileExt,
-		FileName:     media.MediaTitle,
-		FileSize:     media.FileSize,
-		Resolution:   media.Resolution,
-		DownloadedAt: time.Now(),
-	}
-	if err := app.DB.DownloadHistoryRepo.Create(r.Context(), &download); err != nil {
-		app.errorLog.Printf("Failed to log download for user %d: %v", user.ID, err)
+	// Locate the file, resized renditions are generated on first download
+	mediaPath, err := renditionFile(media, size)
+	if err != nil {
+		app.errorLog.Printf("Unable to generate %s rendition of media %d: %v", size.Size, media.ID, err)
 		Resp.Error = true
-		Resp.Message = "Failed to log download history"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		Resp.Message = "File not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
 		return
 	}
-
-	// 10. Increment media category download count
-	if err := app.DB.MediaCategoryRepo.IncrementDownloads(r.Context(), int64(media.CategoryID)); err != nil {
-		app.errorLog.Printf("Failed to increment category downloads for user %d: %v", user.ID, err)
+	info, err := os.Stat(mediaPath)
+	if err != nil {
+		app.errorLog.Printf("File not found: %s", mediaPath)
 		Resp.Error = true
-		Resp.Message = "Failed to update category download count"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		Resp.Message = "File not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
 		return
 	}
 
-	// 11. Increment media download count
-	if err := app.DB.MediaRepo.IncrementDownloadCountByID(r.Context(), media.ID); err != nil {
-		app.errorLog.Printf("Failed to increment media downloads for user %d: %v", user.ID, err)
-		Resp.Error = true
-		Resp.Message = "Failed to update media download count"
-		app.writeJSON(w, http.StatusInternalServerError, Resp)
-		return
+	// Premium files embed the id of their download record, so a leaked copy can be traced.
+	// The id is reserved here and the record saved with it before the file is sent.
+	var fingerprinted []byte
+	var downloadID int
+	if media.LicenseType == 1 && app.config.fingerprint.enabled {
+		downloadID, err = app.DB.DownloadHistoryRepo.NextID(r.Context())
+		if err == nil {
+			fingerprinted, err = app.fingerprintDownload(r.Context(), mediaPath, downloadID)
+		}
+		if errors.Is(err, fingerprint.ErrTooSmall) {
+			err = nil
+		}
+		if err != nil {
+			app.errorLog.Printf("Unable to fingerprint media %d for user %d: %v", media.ID, user.ID, err)
+			Resp.Error = true
+			Resp.Message = "Could not prepare the download"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
 	}
-
-	// 12. Locate the media file path
-	imageType := "premium"
-	if media.LicenseType == 0 {
-		imageType = "free"
+	fileSize := info.Size()
+	if fingerprinted != nil {
+		fileSize = int64(len(fingerprinted))
 	}
-	mediaPath := path.Join("assets", "images", imageType, media.MediaUUID)
 
-	if _, err := os.Stat(mediaPath); err != nil {
-		app.errorLog.Printf("File not found: %s", mediaPath)
+	// 8. Record the download. The quota, history and counters are saved together, and the
+	// file is only sent once they are, so a fingerprinted file always has its record
+	download := models.DownloadHistory{
+		ID:            downloadID,
+		MediaUUID:     media.MediaUUID,
+		UserID:        &user.ID,
+		FileType:      media.FileType,
+		FileExt:       media.FileExt,
+		FileName:      media.MediaTitle,
+		FileSize:      utils.FormatFileSize(fileSize),
+		Resolution:    fmt.Sprintf("%dx%dpx", size.Width, size.Height),
+		Size:          size.Size,
+		Fingerprinted: fingerprinted != nil,
+		DownloadedAt:  time.Now(),
+	}
+	if err := app.DB.DownloadHistoryRepo.Record(r.Context(), &download, media.ID, media.CategoryID, media.LicenseType == 1); err != nil {
+		app.errorLog.Printf("Failed to log download for user %d: %v", user.ID, err)
 		Resp.Error = true
-		Resp.Message = "File not found"
-		app.writeJSON(w, http.StatusNotFound, Resp)
+		Resp.Message = "Failed to log download history"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
 		return
 	}
 
-	// 13. Serve the media file
+	// 9. Serve the media file
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", media.FileName))
 
+	if fingerprinted != nil {
+		// Whole file only: every request carries its own fingerprint, ranges wouldn't fit together
+		w.Header().Set("Content-Length", strconv.Itoa(len(fingerprinted)))
+		w.Write(fingerprinted)
+		return
+	}
 	http.ServeFile(w, r, mediaPath)
 }
 
@@ -1115,6 +1556,10 @@ func (app *application) CreatePlan(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, err)
 		return
 	}
+	if err := validateDownloadSizes(p.DownloadSizes); err != nil {
+		app.badRequest(w, err)
+		return
+	}
 
 	err = app.DB.SubscriptionTypeRepo.Create(r.Context(), &p)
 	if err != nil {
@@ -1139,6 +1584,10 @@ func (app *application) UpdatePlan(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, err)
 		return
 	}
+	if err := validateDownloadSizes(plan.DownloadSizes); err != nil {
+		app.badRequest(w, err)
+		return
+	}
 	err = app.DB.SubscriptionTypeRepo.Update(r.Context(), &plan)
 	if err != nil {
 		app.badRequest(w, err)
@@ -1291,9 +1740,9 @@ func (app *application) GetDownloadHistory(w http.ResponseWriter, r *http.Reques
 		err     error
 	)
 
-	// Step 2: Handle based on user role
-	if token.Role == "admin" {
-		// Admins can optionally pass ?user_id to filter
+	// Step 2: Handle based on user permissions
+	if hasPermission(token, models.PermReportsRead) {
+		// Staff can optionally pass ?user_id to filter
 		userIDStr := strings.TrimSpace(r.URL.Query().Get("user_id"))
 
 		if userIDStr == "" {
@@ -1355,9 +1804,9 @@ func (app *application) GetUploadHistory(w http.ResponseWriter, r *http.Request)
 		err     error
 	)
 
-	// Step 2: Handle based on user role
-	if token.Role == "admin" {
-		// Admins can optionally pass ?user_id to filter
+	// Step 2: Handle based on user permissions
+	if hasPermission(token, models.PermReportsRead) {
+		// Staff can optionally pass ?user_id to filter
 		userIDStr := strings.TrimSpace(r.URL.Query().Get("user_id"))
 
 		if userIDStr == "" {
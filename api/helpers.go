@@ -1,18 +1,26 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	mrand "math/rand"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/samiulice/photostock/internal/mailer"
+	"github.com/samiulice/photostock/internal/models"
 )
 
 // readJSON read json from request body into data. It accepts a sinle JSON of 1MB max size value in the body
@@ -75,7 +83,7 @@ func (app *application) GenerateRandomAlphanumericCode(length int) string {
 	charsetLength := len(charset) // Length of the character set
 
 	// Create a local random number generator with a unique seed based on the current time.
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rng := mrand.New(mrand.NewSource(time.Now().UnixNano()))
 
 	// Allocate a byte slice to hold the generated random characters.
 	id := make([]byte, length)
@@ -99,3 +107,75 @@ func (app *application) GenerateSafeFilename(prefix string, handler *multipart.F
 	safeBase := uuid.NewString()
 	return fmt.Sprintf("%s_%d%s", safeBase, time.Now().UnixNano(), ext)
 }
+
+// generateOpaqueToken returns a url-safe random token carrying n bytes of entropy
+func generateOpaqueToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 digest of an opaque token.
+// Only digests are persisted so a database leak does not expose usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendMail delivers an email in the background so slow mail servers don't block the request.
+// Delivery failures are only logged.
+func (app *application) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := app.Mailer.Send(ctx, msg); err != nil {
+			app.errorLog.Printf("Unable to send email %q to %v: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// validatePassword checks the minimum password requirements
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("Password must be at least 8 characters long")
+	}
+	return nil
+}
+
+// clientIP returns the address of the connecting client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// audit writes an entry to the audit log. The signed-in user, if any, is recorded as the actor,
+// or the staff member when the user is impersonated.
+// Failures are only logged so auditing never breaks the request.
+func (app *application) audit(r *http.Request, action, targetType, targetID string, details map[string]any) {
+	entry := &models.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IPAddress:  clientIP(r),
+		Details:    details,
+	}
+	if token, ok := r.Context().Value(contextKey("user")).(*models.JWT); ok && token != nil {
+		entry.ActorID = &token.ID
+		// Under impersonation the staff member is the actor
+		if token.ImpersonatorID != 0 {
+			entry.ActorID = &token.ImpersonatorID
+			if entry.Details == nil {
+				entry.Details = map[string]any{}
+			}
+			entry.Details["impersonated_user_id"] = token.ID
+		}
+	}
+	if err := app.DB.AuditLogRepo.Create(r.Context(), entry); err != nil {
+		app.errorLog.Printf("Unable to write audit log %q: %v", action, err)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ImpersonateUser issues a short-lived access token that acts as another user so support
+// staff can see exactly what the user sees. The token has no refresh token, names the
+// staff member in its act claim, ends with the staff member's session and is refused by
+// routes guarded with DenyImpersonation. Every request made with it is audited.
+func (app *application) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	var Resp struct {
+		Error     bool         `json:"error"`
+		Message   string       `json:"message"`
+		Token     string       `json:"token,omitempty"`
+		ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+		User      *models.User `json:"user,omitempty"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if payload.Reason == "" {
+		Resp.Error = true
+		Resp.Message = "A reason is required to impersonate a user"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	staff, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+	if id == staff.ID {
+		Resp.Error = true
+		Resp.Message = "You cannot impersonate yourself"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			Resp.Error = true
+			Resp.Message = "User not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: ImpersonateUser =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	claims, err := app.accessTokenClaims(r.Context(), user, staff.SessionID, staff.MFA)
+	if err != nil {
+		app.errorLog.Println("ERROR: ImpersonateUser =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	// Impersonation must not grant the staff member anything they can't already do
+	for _, perm := range claims["perms"].([]string) {
+		if !slices.Contains(staff.Perms, perm) {
+			Resp.Error = true
+			Resp.Message = "You cannot impersonate a user with permissions you don't have"
+			app.writeJSON(w, http.StatusForbidden, Resp)
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(app.config.auth.impersonationTTL)
+	claims["exp"] = expiresAt.Unix()
+	claims["act"] = map[string]any{
+		"sub":      strconv.Itoa(staff.ID),
+		"username": staff.Username,
+	}
+	token, err := app.Keys.Sign(claims)
+	if err != nil {
+		app.errorLog.Println("ERROR: ImpersonateUser =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	app.audit(r, "impersonation.start", "user", strconv.Itoa(user.ID), map[string]any{
+		"reason":     payload.Reason,
+		"expires_at": expiresAt,
+	})
+	app.infoLog.Printf("User %d is impersonating user %d until %s", staff.ID, user.ID, expiresAt.Format(time.RFC3339))
+
+	user.Password = ""
+	Resp.Error = false
+	Resp.Message = "Impersonation token issued"
+	Resp.Token = token
+	Resp.ExpiresAt = &expiresAt
+	Resp.User = user
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ListAuditLogs returns the newest audit entries. Supports ?action= (prefix, e.g. "impersonation."),
+// ?actor_id= and ?limit= (default 100, at most 500).
+func (app *application) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool               `json:"error"`
+		Message string             `json:"message"`
+		Logs    []*models.AuditLog `json:"logs"`
+	}
+
+	query := r.URL.Query()
+	actorID := 0
+	if v := query.Get("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			app.badRequest(w, fmt.Errorf("Invalid actor_id: %w", err))
+			return
+		}
+		actorID = id
+	}
+	limit := 100
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+
+	logs, err := app.DB.AuditLogRepo.GetRecent(r.Context(), strings.TrimSpace(query.Get("action")), actorID, limit)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListAuditLogs =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Audit logs fetched successfully"
+	Resp.Logs = logs
+	app.writeJSON(w, http.StatusOK, Resp)
+}
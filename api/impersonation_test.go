@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/keyring"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+)
+
+const testJWTSecret = "impersonation-test-secret"
+
+// serveFakePostgres answers the queries of one connection: every session is signed in and
+// every other query returns no rows, so requests get past authentication without a database
+func serveFakePostgres(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		q, ok := msg.(*pgproto3.Query)
+		if !ok {
+			return
+		}
+		if strings.Contains(q.String, "FROM sessions") {
+			backend.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{
+				Name: []byte("last_seen_at"), DataTypeOID: 1114, DataTypeSize: 8, TypeModifier: -1,
+			}}})
+			backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte(time.Now().Format("2006-01-02 15:04:05.999999"))}})
+			backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+		} else {
+			backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")})
+		}
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+	poolConfig, err := pgxpool.ParseConfig("postgres://test@127.0.0.1/photostock?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	poolConfig.ConnConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serveFakePostgres(server)
+		return client, nil
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	keys, err := keyring.New(nil, keyring.Options{Algorithm: keyring.HS256, Secret: testJWTSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &application{
+		infoLog:  log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+		DB:       repositories.NewDBRepository(pool),
+		Keys:     keys,
+	}
+}
+
+// accessToken returns a signed access token of user 42, acting for staff member 7 when impersonated
+func accessToken(t *testing.T, app *application, impersonated bool) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"id":    42,
+		"name":  "Customer",
+		"role":  models.RoleUser,
+		"perms": []string{models.PermMediaUpload},
+		"typ":   "access",
+		"sid":   "session-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	if impersonated {
+		claims["act"] = map[string]any{"sub": "7", "username": "support"}
+	}
+	token, err := app.Keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Staff acting as a customer must not change how the account is reached, read its pending
+// second factor, or upload, submit and download in the customer's name
+func TestImpersonationDenied(t *testing.T) {
+	app := newTestApp(t)
+	routes := app.routes()
+	token := accessToken(t, app, true)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPut, "/api/v1/auth/profile"},
+		{http.MethodPut, "/api/v1/auth/profile/image"},
+		{http.MethodGet, "/api/v1/auth/mfa/qr"},
+		{http.MethodPost, "/api/v1/media/"},
+		{http.MethodGet, "/api/v1/media/premium?id=1"},
+		{http.MethodPost, "/api/v1/media/1/submit"},
+	} {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			r := httptest.NewRequest(route.method, route.path, nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+
+			var resp models.Response
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if w.Code != http.StatusForbidden || !strings.Contains(resp.Message, "impersonating") {
+				t.Fatalf("status = %d, message = %q, want the impersonation refusal", w.Code, resp.Message)
+			}
+		})
+	}
+}
+
+// The customer's own token gets past the guard
+func TestImpersonationAllowsOwner(t *testing.T) {
+	app := newTestApp(t)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/mfa/qr", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken(t, app, false))
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+
+	if w.Code == http.StatusUnauthorized || strings.Contains(w.Body.String(), "impersonating") {
+		t.Fatalf("status = %d, body = %s, want the request to reach the handler", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/imagemeta"
+	"github.com/samiulice/photostock/internal/jobs"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// processMedia makes the watermarked preview, thumbnail and download renditions of an upload,
+// flags it when it looks like existing media and stores its embedded metadata.
+// Every step overwrites its output, so an attempt can safely be repeated.
+func (app *application) processMedia(ctx context.Context, job *models.Job) error {
+	if job.MediaID == nil {
+		return jobs.Permanent(errors.New("missing media id"))
+	}
+	media, err := app.DB.MediaRepo.GetByID(ctx, *job.MediaID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil // deleted while queued
+	}
+	if err != nil {
+		return err
+	}
+	original := mediaOriginalPath(media.MediaUUID, media.LicenseType)
+	if _, err := os.Stat(original); err != nil {
+		return jobs.Permanent(fmt.Errorf("original file: %w", err))
+	}
+
+	outputBaseDir := filepath.Join(".", "assets", "images", "public")
+	phash, err := utils.GenerateImageVariants(original, outputBaseDir, media.MediaUUID,
+		app.resolveWatermark(ctx, media.UploaderID, media.CategoryID))
+	if err != nil {
+		return fmt.Errorf("image variants: %w", err)
+	}
+
+	// Flag likely near-duplicates for the reviewers
+	var nearDuplicateOf *int
+	if app.config.media.duplicateDistance >= 0 {
+		similar, err := app.DB.MediaRepo.FindSimilar(ctx, phash, app.config.media.duplicateDistance, 5)
+		if err != nil {
+			return fmt.Errorf("near-duplicates: %w", err)
+		}
+		// A repeated attempt finds the media itself
+		similar = slices.DeleteFunc(similar, func(s *models.SimilarMedia) bool { return s.ID == media.ID })
+		if len(similar) > 0 {
+			nearDuplicateOf = &similar[0].ID
+		}
+	}
+
+	// Render the download sizes up front so their byte sizes can be listed
+	for _, size := range mediaSizes(media) {
+		if _, err := renditionFile(media, size); err != nil {
+			return fmt.Errorf("%s rendition: %w", size.Size, err)
+		}
+	}
+
+	if err := app.saveEmbeddedMetadata(ctx, media.ID, original); err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	return app.DB.MediaRepo.SetProcessed(ctx, media.ID, phash, nearDuplicateOf)
+}
+
+// saveEmbeddedMetadata stores the EXIF, IPTC and XMP metadata of an original file.
+// Unreadable metadata is only logged, it doesn't make the image unusable.
+func (app *application) saveEmbeddedMetadata(ctx context.Context, mediaID int, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	meta, err := imagemeta.Read(file)
+	if err != nil && !errors.Is(err, imagemeta.ErrUnsupported) {
+		app.errorLog.Printf("Could not read metadata of media %d: %v", mediaID, err)
+	}
+	if meta == nil {
+		return nil
+	}
+	meta.MediaID = mediaID
+	return app.DB.MediaRepo.SaveMetadata(ctx, meta)
+}
+
+// MediaProcessing returns the processing state of a media item and its latest background job,
+// to its uploader and moderators. Once processed, it lists the media that look like it.
+func (app *application) MediaProcessing(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error            bool                   `json:"error"`
+		Message          string                 `json:"message"`
+		MediaID          int                    `json:"media_id"`
+		ProcessingStatus string                 `json:"processing_status"`
+		ProcessingError  string                 `json:"processing_error,omitempty"`
+		NearDuplicateOf  *int                   `json:"near_duplicate_of,omitempty"`
+		NearDuplicates   []*models.SimilarMedia `json:"near_duplicates,omitempty"`
+		Job              *models.Job            `json:"job"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+	job, err := app.DB.JobRepo.GetLatestForMedia(r.Context(), media.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: MediaProcessing =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if media.ProcessingStatus == models.MediaProcessingReady && app.config.media.duplicateDistance >= 0 {
+		Resp.NearDuplicates, err = app.DB.MediaRepo.FindSimilarTo(r.Context(), media.ID, app.config.media.duplicateDistance, 5)
+		if err != nil {
+			app.errorLog.Println("ERROR: MediaProcessing =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal Server Error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+	}
+
+	Resp.Error = false
+	Resp.Message = "Processing status fetched successfully"
+	Resp.MediaID = media.ID
+	Resp.ProcessingStatus = media.ProcessingStatus
+	Resp.ProcessingError = media.ProcessingError
+	Resp.NearDuplicateOf = media.NearDuplicateOf
+	Resp.Job = job
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ListJobs returns background jobs, most recently updated first, with the number of jobs
+// in each state. ?status= filters on a state, ?limit= (default 50, at most 200) and ?offset= page.
+func (app *application) ListJobs(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Counts  map[string]int `json:"counts"`
+		Jobs    []*models.Job  `json:"jobs"`
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && !slices.Contains([]string{models.JobStatusQueued, models.JobStatusRunning, models.JobStatusDone, models.JobStatusDead}, status) {
+		app.badRequest(w, errors.New("status must be queued, running, done or dead"))
+		return
+	}
+	limit, offset := 50, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			app.badRequest(w, errors.New("limit must be between 1 and 200"))
+			return
+		}
+		limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			app.badRequest(w, errors.New("invalid offset"))
+			return
+		}
+		offset = n
+	}
+
+	counts, err := app.DB.JobRepo.CountByStatus(r.Context())
+	if err == nil {
+		Resp.Jobs, err = app.DB.JobRepo.List(r.Context(), status, limit, offset)
+	}
+	if err != nil {
+		app.errorLog.Println("ERROR: ListJobs =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Jobs fetched successfully"
+	Resp.Counts = counts
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RetryJob gives a job in the dead-letter state a new round of attempts
+func (app *application) RetryJob(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Job     *models.Job `json:"job,omitempty"`
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		app.badRequest(w, errors.New("invalid job ID"))
+		return
+	}
+	job, err := app.DB.JobRepo.Requeue(r.Context(), id)
+	switch {
+	case errors.Is(err, repositories.ErrJobNotFound):
+		Resp.Error = true
+		Resp.Message = "Job not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	case errors.Is(err, repositories.ErrJobNotDead):
+		Resp.Error = true
+		Resp.Message = "Only jobs that failed their last attempt can be retried"
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	case err != nil:
+		app.errorLog.Println("ERROR: RetryJob =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.Jobs.Notify()
+	app.audit(r, "job.retry", "job", strconv.FormatInt(id, 10), map[string]any{"kind": job.Kind})
+
+	Resp.Error = false
+	Resp.Message = "Job queued again"
+	Resp.Job = job
+	app.writeJSON(w, http.StatusOK, Resp)
+}
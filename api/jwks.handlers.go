@@ -0,0 +1,11 @@
+package api
+
+import "net/http"
+
+// JWKS publishes the public keys access tokens are signed with so other services
+// can verify them without sharing a secret. Empty when tokens are signed with HS256.
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+	app.writeJSON(w, http.StatusOK, app.Keys.JWKS(), headers)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/throttle"
+)
+
+// accountThrottleKey returns the failed sign-in counter key of an account
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipThrottleKey returns the failed sign-in counter key of a client IP
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginAllowed checks whether the account and the client IP may attempt to sign in.
+// When they may not, it writes a 429 response with a Retry-After header and returns false.
+func (app *application) loginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	accountWait, err := app.AccountLimiter.Check(r.Context(), accountThrottleKey(email))
+	if err != nil {
+		app.errorLog.Println("ERROR: unable to check sign-in throttle:", err)
+	}
+	ipWait, err := app.IPLimiter.Check(r.Context(), ipThrottleKey(clientIP(r)))
+	if err != nil {
+		app.errorLog.Println("ERROR: unable to check sign-in throttle:", err)
+	}
+	wait := max(accountWait, ipWait)
+	if wait <= 0 {
+		return true
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	app.infoLog.Printf("Sign-in throttled for %s from %s (%ds)", email, clientIP(r), seconds)
+	headers := http.Header{}
+	headers.Set("Retry-After", strconv.Itoa(seconds))
+	app.writeJSON(w, http.StatusTooManyRequests, models.Response{
+		Error:   true,
+		Message: fmt.Sprintf("Too many failed sign-in attempts. Try again in %d seconds", seconds),
+	}, headers)
+	return false
+}
+
+// loginFailed records a failed sign-in for the account and the client IP
+// and writes an audit entry when either gets locked out
+func (app *application) loginFailed(r *http.Request, email string) {
+	ip := clientIP(r)
+	targets := []struct {
+		limiter    *throttle.Limiter
+		key        string
+		targetType string
+		targetID   string
+	}{
+		{app.AccountLimiter, accountThrottleKey(email), "account", strings.ToLower(strings.TrimSpace(email))},
+		{app.IPLimiter, ipThrottleKey(ip), "ip", ip},
+	}
+	for _, t := range targets {
+		res, err := t.limiter.Fail(r.Context(), t.key)
+		if err != nil {
+			app.errorLog.Println("ERROR: unable to record failed sign-in:", err)
+			continue
+		}
+		if res.Locked {
+			app.infoLog.Printf("Sign-in locked for %s %s after %d failures", t.targetType, t.targetID, res.Failures)
+			app.audit(r, "login.lockout", t.targetType, t.targetID, map[string]any{
+				"failures":     res.Failures,
+				"locked_until": time.Now().Add(res.RetryAfter),
+			})
+		}
+	}
+}
+
+// loginSucceeded clears the failed sign-in counter of the account.
+// The client IP counter is kept so one valid account can't be used to reset it.
+func (app *application) loginSucceeded(r *http.Request, email string) {
+	if err := app.AccountLimiter.Reset(r.Context(), accountThrottleKey(email)); err != nil {
+		app.errorLog.Println("ERROR: unable to reset sign-in throttle:", err)
+	}
+}
+
+// UnlockUser lifts a sign-in lockout of a user account
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			Resp.Error = true
+			Resp.Message = "User not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: UnlockUser =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if err := app.AccountLimiter.Reset(r.Context(), accountThrottleKey(user.Email)); err != nil {
+		app.errorLog.Println("ERROR: UnlockUser =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.audit(r, "login.unlock", "account", strings.ToLower(user.Email), map[string]any{"user_id": user.ID})
+
+	Resp.Error = false
+	Resp.Message = "Account unlocked"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
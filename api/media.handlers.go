@@ -0,0 +1,628 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// maxMediaTags is the number of tags a media item can have
+const maxMediaTags = 20
+
+// formTags reads tags from form values, each holding one or more comma separated tags
+func formTags(values []string) []string {
+	var tags []string
+	for _, v := range values {
+		tags = append(tags, strings.Split(v, ",")...)
+	}
+	return utils.NormalizeTags(tags)
+}
+
+// licenseDir returns the directory name holding originals of a license type
+func licenseDir(licenseType int) string {
+	if licenseType == 0 {
+		return "free"
+	}
+	return "premium"
+}
+
+// mediaOriginalPath returns the path of the uploaded original of a media item
+func mediaOriginalPath(uuid string, licenseType int) string {
+	return filepath.Join(".", "assets", "images", licenseDir(licenseType), uuid)
+}
+
+// mediaVariantPaths returns the paths of the thumbnail, watermarked and resized download copies of a media item
+func mediaVariantPaths(uuid string) []string {
+	publicDir := filepath.Join(".", "assets", "images", "public")
+	paths := []string{
+		filepath.Join(publicDir, "thumbnails", "thumb_"+uuid),
+		filepath.Join(publicDir, "watermarked", "wm_"+uuid),
+	}
+	for _, ds := range models.DownloadSizes {
+		if ds.MaxEdge > 0 {
+			paths = append(paths, mediaRenditionPath(uuid, ds.Name))
+		}
+	}
+	return paths
+}
+
+// mediaRenditionPath returns the path of the cached rendition of a media item in a download size.
+// Renditions are kept outside the public directory, premium media are only served through ServeMedia.
+func mediaRenditionPath(uuid, size string) string {
+	return filepath.Join(".", "assets", "images", "renditions", size, uuid)
+}
+
+// mediaSizes returns the sizes a media item can be downloaded in. Tiers that would not be
+// smaller than the original are left out. Byte sizes are read from the files on disk.
+func mediaSizes(media *models.Media) []*models.MediaRendition {
+	var sizes []*models.MediaRendition
+	for _, ds := range models.DownloadSizes {
+		r := &models.MediaRendition{Size: ds.Name, Width: media.Width, Height: media.Height}
+		file := mediaOriginalPath(media.MediaUUID, media.LicenseType)
+		if ds.MaxEdge > 0 {
+			if media.Width == 0 || max(media.Width, media.Height) <= ds.MaxEdge {
+				continue
+			}
+			r.Width, r.Height = utils.FitWithin(media.Width, media.Height, ds.MaxEdge)
+			file = mediaRenditionPath(media.MediaUUID, ds.Name)
+		}
+		if info, err := os.Stat(file); err == nil {
+			r.Bytes = info.Size()
+		}
+		sizes = append(sizes, r)
+	}
+	return sizes
+}
+
+// validateDownloadSizes checks the size tiers of a subscription plan
+func validateDownloadSizes(sizes []string) error {
+	for _, size := range sizes {
+		if !slices.ContainsFunc(models.DownloadSizes, func(ds models.DownloadSize) bool { return ds.Name == size }) {
+			return fmt.Errorf("unknown download size %q", size)
+		}
+	}
+	return nil
+}
+
+// planIncludesSize reports whether a subscription plan includes a download size, plans without sizes include all
+func planIncludesSize(plan *models.SubscriptionPlan, size string) bool {
+	return len(plan.DownloadSizes) == 0 || slices.Contains(plan.DownloadSizes, size)
+}
+
+// renditionFile returns the file to deliver for a download size, generating and caching the
+// rendition on first use
+func renditionFile(media *models.Media, r *models.MediaRendition) (string, error) {
+	if r.Size == models.DownloadSizeOriginal {
+		return mediaOriginalPath(media.MediaUUID, media.LicenseType), nil
+	}
+	file := mediaRenditionPath(media.MediaUUID, r.Size)
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	err := utils.GenerateRendition(mediaOriginalPath(media.MediaUUID, media.LicenseType), file, r.Width, r.Height)
+	return file, err
+}
+
+// canManageMedia reports whether the user may edit or delete the media item:
+// its uploader or a user allowed to moderate media
+func canManageMedia(token *models.JWT, media *models.Media) bool {
+	return media.UploaderID == token.ID || hasPermission(token, models.PermMediaApprove)
+}
+
+// loadManagedMedia loads the media item of the {id} URL parameter and checks the user may manage it.
+// On failure it writes the response and returns nil.
+func (app *application) loadManagedMedia(w http.ResponseWriter, r *http.Request) *models.Media {
+	resp := models.Response{Error: true}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		resp.Message = "Invalid media ID"
+		app.writeJSON(w, http.StatusBadRequest, resp)
+		return nil
+	}
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, resp)
+		return nil
+	}
+
+	media, err := app.DB.MediaRepo.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			resp.Message = "Media not found"
+			app.writeJSON(w, http.StatusNotFound, resp)
+			return nil
+		}
+		app.errorLog.Println("Database error fetching media:", err)
+		resp.Message = "Could not retrieve media"
+		app.writeJSON(w, http.StatusInternalServerError, resp)
+		return nil
+	}
+	if !canManageMedia(token, media) {
+		resp.Message = "Forbidden: You can only manage your own media"
+		app.writeJSON(w, http.StatusForbidden, resp)
+		return nil
+	}
+	return media
+}
+
+// UpdateMedia changes the title, description, category or license type of a media item.
+// Omitted fields are left unchanged. A license change moves the original between the
+// free and premium directories.
+func (app *application) UpdateMedia(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		MediaTitle  *string   `json:"media_title"`
+		Description *string   `json:"description"`
+		CategoryID  *int      `json:"category_id"`
+		LicenseType *string   `json:"license_type"` // "free" or "premium"
+		Tags        *[]string `json:"tags"`         // replaces the tags when present
+	}
+	var Resp struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Media   *models.Media `json:"media,omitempty"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	if payload.MediaTitle != nil {
+		media.MediaTitle = strings.TrimSpace(*payload.MediaTitle)
+		if media.MediaTitle == "" {
+			Resp.Error = true
+			Resp.Message = "Title cannot be empty"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+	}
+	if payload.Description != nil {
+		media.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.CategoryID != nil && *payload.CategoryID != media.CategoryID {
+		if _, err := app.DB.MediaCategoryRepo.GetByID(r.Context(), *payload.CategoryID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				Resp.Error = true
+				Resp.Message = "Category not found"
+				app.writeJSON(w, http.StatusBadRequest, Resp)
+				return
+			}
+			app.errorLog.Println("ERROR: UpdateMedia =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal server error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+		media.CategoryID = *payload.CategoryID
+	}
+	if payload.Tags != nil && len(utils.NormalizeTags(*payload.Tags)) > maxMediaTags {
+		Resp.Error = true
+		Resp.Message = fmt.Sprintf("At most %d tags are allowed", maxMediaTags)
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	previousLicense := media.LicenseType
+	if payload.LicenseType != nil {
+		switch strings.ToLower(strings.TrimSpace(*payload.LicenseType)) {
+		case "free":
+			media.LicenseType = 0
+		case "premium":
+			media.LicenseType = 1
+		default:
+			Resp.Error = true
+			Resp.Message = "License type must be free or premium"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+	}
+
+	// Move the original first so the record never points at a missing file
+	from := mediaOriginalPath(media.MediaUUID, previousLicense)
+	to := mediaOriginalPath(media.MediaUUID, media.LicenseType)
+	if from != to {
+		err := os.MkdirAll(filepath.Dir(to), os.ModePerm)
+		if err == nil {
+			err = os.Rename(from, to)
+		}
+		if err != nil {
+			app.errorLog.Println("ERROR: UpdateMedia => unable to move original:", err)
+			Resp.Error = true
+			Resp.Message = "Could not change the license type"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+	}
+
+	if payload.Tags != nil {
+		media.Tags = *payload.Tags
+	}
+	if err := app.DB.MediaRepo.UpdateDetails(r.Context(), media, payload.Tags != nil); err != nil {
+		app.errorLog.Println("ERROR: UpdateMedia =>", err)
+		if from != to {
+			if err := os.Rename(to, from); err != nil {
+				app.errorLog.Println("ERROR: UpdateMedia => unable to move original back:", err)
+			}
+		}
+		Resp.Error = true
+		Resp.Message = "Could not update media"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	// Changes by the uploader to reviewed media must be reviewed again
+	token, _ := app.GetUserTokenFromContext(r.Context())
+	if !hasPermission(token, models.PermMediaApprove) {
+		changed, err := app.DB.ModerationRepo.Transition(r.Context(), []int{media.ID},
+			[]string{models.MediaStatusApproved, models.MediaStatusRejected}, models.MediaStatusPendingReview,
+			token.ID, nil, "Edited by the uploader")
+		if err != nil {
+			app.errorLog.Println("ERROR: UpdateMedia => unable to resubmit for review:", err)
+		} else if len(changed) > 0 {
+			media.Status = models.MediaStatusPendingReview
+			media.RejectionReason, media.RejectionNote = "", ""
+		}
+	}
+
+	media.MediaUUID = ""
+	Resp.Error = false
+	Resp.Message = "Media updated successfully"
+	Resp.Media = media
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// DeleteMedia removes a media item with its original, thumbnail and watermarked copies.
+// Download history is kept so buyers still see what they licensed.
+func (app *application) DeleteMedia(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+
+	if err := app.DB.MediaRepo.Delete(r.Context(), media.ID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			Resp.Error = true
+			Resp.Message = "Media not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: DeleteMedia =>", err)
+		Resp.Error = true
+		Resp.Message = "Could not delete media"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	// The record is gone, leftover files are only logged
+	files := append(mediaVariantPaths(media.MediaUUID), mediaOriginalPath(media.MediaUUID, media.LicenseType))
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			app.errorLog.Println("ERROR: DeleteMedia => unable to remove file:", err)
+		}
+	}
+
+	token, _ := app.GetUserTokenFromContext(r.Context())
+	if media.UploaderID != token.ID {
+		app.audit(r, "media.delete", "media", strconv.Itoa(media.ID), map[string]any{
+			"title":       media.MediaTitle,
+			"uploader_id": media.UploaderID,
+		})
+	}
+
+	Resp.Error = false
+	Resp.Message = "Media deleted successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// MediaMetadata returns the full embedded metadata of a media item, GPS position included,
+// to its uploader and moderators
+func (app *application) MediaMetadata(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error    bool                  `json:"error"`
+		Message  string                `json:"message"`
+		Metadata *models.MediaMetadata `json:"metadata"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+	meta, err := app.DB.MediaRepo.GetMetadata(r.Context(), media.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: MediaMetadata =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if meta == nil {
+		Resp.Error = true
+		Resp.Message = "No embedded metadata was found in this media"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Metadata fetched successfully"
+	Resp.Metadata = meta
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// parseMediaFilter reads the media listing filters from the query string:
+// category, license (free|premium), file_type, uploader_id, min_width, max_width,
+// min_height, max_height, orientation (landscape|portrait|square), tag and from/to
+// (YYYY-MM-DD or RFC 3339, a date-only to includes the whole day).
+func parseMediaFilter(query url.Values) (models.MediaFilter, error) {
+	var f models.MediaFilter
+	ints := map[string]*int{
+		"category":    &f.CategoryID,
+		"uploader_id": &f.UploaderID,
+		"min_width":   &f.MinWidth,
+		"max_width":   &f.MaxWidth,
+		"min_height":  &f.MinHeight,
+		"max_height":  &f.MaxHeight,
+	}
+	for name, dst := range ints {
+		if v := strings.TrimSpace(query.Get(name)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid %s", name)
+			}
+			*dst = n
+		}
+	}
+
+	switch license := strings.ToLower(strings.TrimSpace(query.Get("license"))); license {
+	case "":
+	case "free", "premium":
+		lt := 0
+		if license == "premium" {
+			lt = 1
+		}
+		f.LicenseType = &lt
+	default:
+		return f, errors.New("license must be free or premium")
+	}
+
+	f.FileType = strings.TrimSpace(query.Get("file_type"))
+	if v := query.Get("tag"); v != "" {
+		_, f.Tag = utils.NormalizeTag(v)
+	}
+	f.Orientation = strings.ToLower(strings.TrimSpace(query.Get("orientation")))
+	if f.Orientation != "" && !slices.Contains([]string{"landscape", "portrait", "square"}, f.Orientation) {
+		return f, errors.New("orientation must be landscape, portrait or square")
+	}
+
+	f.Resolution = strings.ToLower(strings.TrimSpace(query.Get("resolution")))
+	if f.Resolution != "" && !slices.ContainsFunc(models.ResolutionClasses, func(c models.ResolutionClass) bool {
+		return c.Name == f.Resolution
+	}) {
+		return f, errors.New("resolution must be sd, hd, full_hd, 4k or 8k")
+	}
+
+	for _, name := range []string{"from", "to"} {
+		v := strings.TrimSpace(query.Get(name))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				return f, fmt.Errorf("invalid %s date", name)
+			}
+			if name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		if name == "from" {
+			f.From = &t
+		} else {
+			f.To = &t
+		}
+	}
+	return f, nil
+}
+
+// wantFacets reports whether facet counts should be returned with a listing. They are
+// on by default; clients that page through results can skip them with ?facets=false.
+func wantFacets(query url.Values) bool {
+	v, err := strconv.ParseBool(query.Get("facets"))
+	return err != nil || v
+}
+
+// mediaCursor is the opaque cursor handed to clients. It remembers the sort order
+// so a cursor can't be reused with another one.
+type mediaCursor struct {
+	Sort string `json:"s"`
+	models.MediaCursor
+}
+
+// encodeMediaCursor returns the cursor of a page starting after m, or ending before it
+func encodeMediaCursor(m *models.Media, sort string, before bool) string {
+	c := mediaCursor{Sort: sort, MediaCursor: models.MediaCursor{ID: m.ID, Before: before}}
+	switch sort {
+	case models.MediaSortDownloads:
+		c.Value = strconv.Itoa(m.TotalDownloads)
+	case models.MediaSortEarnings:
+		c.Value = strconv.FormatFloat(m.TotalEarnings, 'f', -1, 64)
+	default:
+		c.Value = m.CreatedAt.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeMediaCursor parses a cursor made by encodeMediaCursor for the same sort order
+func decodeMediaCursor(value, sort string) (*models.MediaCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c mediaCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Sort != sort || c.ID <= 0 || c.Value == "" {
+		return nil, errors.New("cursor does not match the listing")
+	}
+	return &c.MediaCursor, nil
+}
+
+// highlightHTML escapes a ts_headline result and marks the matched words
+func highlightHTML(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, repositories.HighlightStart, "<mark>")
+	return strings.ReplaceAll(s, repositories.HighlightStop, "</mark>")
+}
+
+// SearchMedia runs a full-text search over titles, tags, category names and descriptions.
+// ?q= supports "exact phrases", prefix* and -excluded words. Results are ranked by relevance,
+// paged with ?page= and ?limit= and accept the filters of ListMedia.
+func (app *application) SearchMedia(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool                     `json:"error"`
+		Message string                   `json:"message"`
+		Query   string                   `json:"query"`
+		Total   int                      `json:"total"`
+		Page    int                      `json:"page"`
+		Limit   int                      `json:"limit"`
+		Results []*models.MediaSearchHit `json:"results"`
+		Facets  *models.MediaFacets      `json:"facets,omitempty"`
+	}
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" || len(q) > 200 {
+		Resp.Error = true
+		Resp.Message = "Search query must be 1-200 characters"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	filter, err := parseMediaFilter(query)
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	filter.Status = models.MediaStatusApproved
+	page := 1
+	if v, err := strconv.Atoi(query.Get("page")); err == nil && v > 0 {
+		page = v
+	}
+	limit := 24
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+
+	hits, total, err := app.DB.MediaRepo.Search(r.Context(), q, filter, limit, (page-1)*limit)
+	if err == nil && wantFacets(query) {
+		Resp.Facets, err = app.DB.MediaRepo.Facets(r.Context(), q, filter)
+	}
+	if err != nil {
+		if errors.Is(err, repositories.ErrEmptySearch) {
+			Resp.Error = true
+			Resp.Message = "Search query has no searchable words"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: SearchMedia =>", err)
+		Resp.Error = true
+		Resp.Message = "Search failed"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Results = []*models.MediaSearchHit{}
+	for _, h := range hits {
+		baseURL, _ := url.Parse(models.APIEndPoint)
+		baseURL.Path = path.Join(baseURL.Path, "public", "thumbnails", "thumb_"+h.MediaUUID)
+		h.MediaURL = baseURL.String()
+		h.Previews = app.mediaPreviews(&h.Media)
+		h.MediaUUID = ""
+		h.TitleHighlight = highlightHTML(h.TitleHighlight)
+		h.Snippet = highlightHTML(h.Snippet)
+		Resp.Results = append(Resp.Results, h)
+	}
+
+	Resp.Error = false
+	Resp.Message = "Search completed"
+	Resp.Query = q
+	Resp.Total = total
+	Resp.Page = page
+	Resp.Limit = limit
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// SuggestSearch returns type-ahead suggestions for the search box across media titles,
+// tags, category names and contributor names. Supports ?q= (2-100 characters) and
+// ?limit= per kind (default 5, at most 10). Results are cached briefly per query.
+func (app *application) SuggestSearch(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error       bool                 `json:"error"`
+		Message     string               `json:"message"`
+		Query       string               `json:"query"`
+		Suggestions []*models.Suggestion `json:"suggestions"`
+	}
+
+	query := r.URL.Query()
+	q := strings.Join(strings.Fields(query.Get("q")), " ")
+	if n := utf8.RuneCountInString(q); n < 2 || n > 100 {
+		Resp.Error = true
+		Resp.Message = "Search query must be 2-100 characters"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	limit := 5
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 10)
+	}
+
+	key := strconv.Itoa(limit) + ":" + strings.ToLower(q)
+	suggestions, ok := app.Suggestions.Get(key)
+	if !ok {
+		var err error
+		suggestions, err = app.DB.SuggestRepo.Suggest(r.Context(), q, limit)
+		if err != nil {
+			app.errorLog.Println("ERROR: SuggestSearch =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal Server Error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+		app.Suggestions.Set(key, suggestions)
+	}
+
+	Resp.Error = false
+	Resp.Message = "Suggestions fetched successfully"
+	Resp.Query = q
+	Resp.Suggestions = suggestions
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,518 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/totp"
+	"github.com/samiulice/photostock/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaPendingTTL     = 5 * time.Minute // lifetime of the token returned by Login for 2FA accounts
+	recoveryCodeCount = 10
+)
+
+var errInvalidMFAToken = errors.New("invalid or expired two-factor token")
+
+// generateMFAPendingToken returns a short-lived token proving the password step of a login succeeded.
+// It is only accepted by VerifyMFA, never by AuthUser.
+func (app *application) generateMFAPendingToken(user *models.User) (string, error) {
+	claims := jwt.MapClaims{
+		"id":  user.ID,
+		"typ": "mfa_pending",
+		"iss": app.config.jwt.issuer,
+		"aud": app.config.jwt.audience,
+		"exp": time.Now().Add(mfaPendingTTL).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	return app.Keys.Sign(claims)
+}
+
+// parseMFAPendingToken validates a pending token and returns the user id it was issued for
+func (app *application) parseMFAPendingToken(tokenString string) (int, error) {
+	token, err := jwt.Parse(tokenString, app.Keys.Keyfunc)
+	if err != nil {
+		return 0, errInvalidMFAToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, errInvalidMFAToken
+	}
+	if typ, _ := claims["typ"].(string); typ != "mfa_pending" {
+		return 0, errInvalidMFAToken
+	}
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return 0, errInvalidMFAToken
+	}
+	return int(id), nil
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a one-time recovery code
+// against the user's enabled enrollment. Accepted codes cannot be used again.
+func (app *application) verifySecondFactor(ctx context.Context, userID int, code string) (bool, error) {
+	mfa, err := app.DB.MFARepo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrMFANotEnrolled) {
+			return false, nil
+		}
+		return false, err
+	}
+	if mfa.EnabledAt == nil {
+		return false, nil
+	}
+
+	secret, err := utils.DecryptString(app.config.auth.mfaEncryptionKey, mfa.Secret)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return app.DB.MFARepo.ConsumeStep(ctx, userID, step)
+	}
+
+	normalized := totp.NormalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+	return app.DB.MFARepo.UseRecoveryCode(ctx, userID, hashToken(normalized))
+}
+
+// newRecoveryCodes generates recovery codes and their hashes for storage
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashToken(totp.NormalizeRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// pendingMFASecret loads and decrypts the not yet confirmed TOTP secret of a user
+func (app *application) pendingMFASecret(ctx context.Context, userID int) (string, error) {
+	mfa, err := app.DB.MFARepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if mfa.EnabledAt != nil {
+		return "", errors.New("two-factor authentication already enabled")
+	}
+	return utils.DecryptString(app.config.auth.mfaEncryptionKey, mfa.Secret)
+}
+
+// VerifyMFA completes a two-step login: it exchanges the pending token from Login
+// and a TOTP (or recovery) code for a regular token pair
+func (app *application) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	userID, err := app.parseMFAPendingToken(strings.TrimSpace(payload.MFAToken))
+	if err != nil {
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{
+			Error:   true,
+			Message: "Two-factor session expired. Please sign in again",
+		})
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), userID)
+	if err != nil || !user.Status {
+		app.errorLog.Println("ERROR: VerifyMFA => user unavailable:", userID)
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{
+			Error:   true,
+			Message: "Account unavailable",
+		})
+		return
+	}
+
+	// Code guesses count against the same account throttle as passwords
+	if !app.loginAllowed(w, r, user.Email) {
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, payload.Code)
+	if err != nil {
+		app.errorLog.Println("ERROR: VerifyMFA => unable to verify code:", err)
+		app.writeJSON(w, http.StatusInternalServerError, models.Response{
+			Error:   true,
+			Message: "Internal server error",
+		})
+		return
+	}
+	if !ok {
+		app.errorLog.Printf("ERROR: VerifyMFA => invalid code for user: %s", user.Username)
+		app.loginFailed(r, user.Email)
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{
+			Error:   true,
+			Message: "Invalid authentication code",
+		})
+		return
+	}
+
+	app.loginSucceeded(r, user.Email)
+
+	token, refreshToken, err := app.issueTokenPair(r, user, true)
+	if err != nil {
+		app.errorLog.Println("ERROR: VerifyMFA => unable to generate token for user: ", user.Username, err)
+		app.badRequest(w, errors.New("Internal server error"))
+		return
+	}
+	user.Password = ""
+
+	response := struct {
+		Error        bool         `json:"error"`
+		Message      string       `json:"message"`
+		Token        string       `json:"token"`
+		RefreshToken string       `json:"refresh_token"`
+		User         *models.User `json:"user"`
+	}{
+		Error:        false,
+		Message:      "Sign in successful",
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         user,
+	}
+
+	app.infoLog.Printf("User %s signed in successfully with two-factor authentication", user.Username)
+	app.writeJSON(w, http.StatusOK, response)
+}
+
+// MFAStatus reports the two-factor state of the logged-in user
+func (app *application) MFAStatus(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error                  bool   `json:"error"`
+		Message                string `json:"message"`
+		Enabled                bool   `json:"enabled"`
+		RecoveryCodesRemaining int    `json:"recovery_codes_remaining"`
+		Required               bool   `json:"required"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	enabled, err := app.DB.MFARepo.IsEnabled(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: MFAStatus =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if enabled {
+		Resp.RecoveryCodesRemaining, err = app.DB.MFARepo.CountRecoveryCodes(r.Context(), token.ID)
+		if err != nil {
+			app.errorLog.Println("ERROR: MFAStatus =>", err)
+		}
+	}
+
+	Resp.Error = false
+	Resp.Message = "Two-factor status fetched successfully"
+	Resp.Enabled = enabled
+	Resp.Required = app.config.auth.enforceAdminMFA && token.Role == models.RoleAdmin
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// EnrollMFA starts a TOTP enrollment: it creates a new secret and returns it together
+// with the otpauth URI and a QR code PNG (as data URL) for authenticator apps
+func (app *application) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error      bool   `json:"error"`
+		Message    string `json:"message"`
+		Secret     string `json:"secret,omitempty"`
+		OTPAuthURI string `json:"otpauth_uri,omitempty"`
+		QRCode     string `json:"qr_code,omitempty"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: EnrollMFA => user lookup failed:", err)
+		Resp.Error = true
+		Resp.Message = "Could not load user"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorLog.Println("ERROR: EnrollMFA => unable to generate secret:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	encrypted, err := utils.EncryptString(app.config.auth.mfaEncryptionKey, secret)
+	if err != nil {
+		app.errorLog.Println("ERROR: EnrollMFA => unable to encrypt secret:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.DB.MFARepo.SavePending(r.Context(), user.ID, encrypted); err != nil {
+		app.errorLog.Println("ERROR: EnrollMFA => unable to store secret:", err)
+		Resp.Error = true
+		Resp.Message = "Two-factor authentication is already enabled"
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	}
+
+	uri := totp.URI(models.APPName, user.Email, secret)
+	png, err := totp.QRCodePNG(uri)
+	if err != nil {
+		app.errorLog.Println("ERROR: EnrollMFA => unable to render QR code:", err)
+	} else {
+		Resp.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	}
+
+	Resp.Error = false
+	Resp.Message = "Scan the QR code with your authenticator app, then confirm with a code"
+	Resp.Secret = secret
+	Resp.OTPAuthURI = uri
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// MFAQRCode serves the QR code of the pending enrollment as a PNG image
+func (app *application) MFAQRCode(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "Access Denied"})
+		return
+	}
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: MFAQRCode => user lookup failed:", err)
+		app.writeJSON(w, http.StatusInternalServerError, models.Response{Error: true, Message: "Could not load user"})
+		return
+	}
+
+	secret, err := app.pendingMFASecret(r.Context(), user.ID)
+	if err != nil {
+		app.writeJSON(w, http.StatusNotFound, models.Response{Error: true, Message: "No pending two-factor enrollment"})
+		return
+	}
+	png, err := totp.QRCodePNG(totp.URI(models.APPName, user.Email, secret))
+	if err != nil {
+		app.errorLog.Println("ERROR: MFAQRCode => unable to render QR code:", err)
+		app.writeJSON(w, http.StatusInternalServerError, models.Response{Error: true, Message: "Internal server error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// ActivateMFA confirms the pending enrollment with a code from the authenticator app
+// and returns the one-time recovery codes. The codes are only shown once.
+func (app *application) ActivateMFA(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	var Resp struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	secret, err := app.pendingMFASecret(r.Context(), token.ID)
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = "No pending two-factor enrollment"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	step, ok := totp.Validate(secret, payload.Code, time.Now())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Invalid authentication code"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.errorLog.Println("ERROR: ActivateMFA => unable to generate recovery codes:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.DB.MFARepo.Enable(r.Context(), token.ID, hashes); err != nil {
+		app.errorLog.Println("ERROR: ActivateMFA => unable to enable:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if _, err := app.DB.MFARepo.ConsumeStep(r.Context(), token.ID, step); err != nil {
+		app.errorLog.Println("ERROR: ActivateMFA => unable to record code:", err)
+	}
+
+	Resp.Error = false
+	Resp.Message = "Two-factor authentication enabled. Store your recovery codes in a safe place"
+	Resp.RecoveryCodes = codes
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a current code
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	var Resp struct {
+		Error         bool     `json:"error"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), token.ID, payload.Code)
+	if err != nil || !ok {
+		Resp.Error = true
+		Resp.Message = "Invalid authentication code"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.errorLog.Println("ERROR: RegenerateRecoveryCodes => unable to generate codes:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.DB.MFARepo.ReplaceRecoveryCodes(r.Context(), token.ID, hashes); err != nil {
+		app.errorLog.Println("ERROR: RegenerateRecoveryCodes => unable to store codes:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "New recovery codes generated"
+	Resp.RecoveryCodes = codes
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// DisableMFA turns two-factor authentication off after verifying the password and a current code.
+// Admins cannot disable it while the admin policy enforces it.
+func (app *application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: DisableMFA => user lookup failed:", err)
+		Resp.Error = true
+		Resp.Message = "Could not load user"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if app.config.auth.enforceAdminMFA && user.Role == models.RoleAdmin {
+		Resp.Error = true
+		Resp.Message = "Two-factor authentication is mandatory for admin accounts"
+		app.writeJSON(w, http.StatusForbidden, Resp)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
+		Resp.Error = true
+		Resp.Message = "Password is incorrect"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	ok, err = app.verifySecondFactor(r.Context(), user.ID, payload.Code)
+	if err != nil || !ok {
+		Resp.Error = true
+		Resp.Message = "Invalid authentication code"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	if err := app.DB.MFARepo.Disable(r.Context(), user.ID); err != nil {
+		app.errorLog.Println("ERROR: DisableMFA => unable to disable:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Two-factor authentication disabled"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -2,7 +2,10 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,26 +16,43 @@ import (
 
 // userContextKey is the key used to store user claims in the request context
 type contextKey string
-func (app *application)corsMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Access-Control-Allow-Origin", "*") // allow all origins
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-        if r.Method == "OPTIONS" {
-            w.WriteHeader(http.StatusOK)
-            return
-        }
-        next.ServeHTTP(w, r)
-    })
+
+func (app *application) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*") // allow all origins
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // AuthUser is a middleware that checks if the user is authenticated
-// It expects the Authorization header to be present in the request
+// It expects the Authorization header or an X-API-Key header to be present in the request
 // If the header is missing or invalid, it returns a 401 Unauthorized response
 // If the token is valid, it adds the user claims to the request context
 // and proceeds to the next handler
 func (app *application) AuthUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Personal API keys populate the same context value as a JWT
+		if apiKey := strings.TrimSpace(r.Header.Get("X-API-Key")); apiKey != "" && r.Header.Get("Authorization") == "" {
+			tokenUser, err := app.authenticateAPIKey(r.Context(), apiKey)
+			if err != nil {
+				app.errorLog.Printf("API key rejected: %v", err)
+				app.writeJSON(w, http.StatusUnauthorized, models.Response{
+					Error:   true,
+					Message: "Access Denied: Invalid API Key",
+				})
+				return
+			}
+			ctx := context.WithValue(r.Context(), contextKey("user"), tokenUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		// Get the Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -59,13 +79,8 @@ func (app *application) AuthUser(next http.Handler) http.Handler {
 		tokenString := parts[1]
 
 		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(app.config.jwt.secretKey), nil // Replace with your actual secret key
-		})
+		// The signing method and key are resolved from the token's kid header
+		token, err := jwt.Parse(tokenString, app.Keys.Keyfunc)
 
 		if err != nil {
 			app.errorLog.Printf("Error parsing token: %v", err)
@@ -78,6 +93,16 @@ func (app *application) AuthUser(next http.Handler) http.Handler {
 
 		// Check if the token is valid
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			// Only access tokens grant access (e.g. not pending two-factor tokens)
+			if typ, _ := claims["typ"].(string); typ != "access" {
+				app.errorLog.Println("Invalid token type")
+				app.writeJSON(w, http.StatusUnauthorized, models.Response{
+					Error:   true,
+					Message: "Access Denied: Invalid Token",
+				})
+				return
+			}
+
 			// Check if the token is expired
 			exp, ok := claims["exp"].(float64)
 			if !ok || float64(time.Now().Unix()) > exp {
@@ -103,6 +128,13 @@ func (app *application) AuthUser(next http.Handler) http.Handler {
 			if role, ok := claims["role"].(string); ok {
 				tokenUser.Role = role
 			}
+			if perms, ok := claims["perms"].([]interface{}); ok {
+				for _, p := range perms {
+					if perm, ok := p.(string); ok {
+						tokenUser.Perms = append(tokenUser.Perms, perm)
+					}
+				}
+			}
 			if iss, ok := claims["iss"].(string); ok {
 				tokenUser.Issuer = iss
 			}
@@ -115,10 +147,51 @@ func (app *application) AuthUser(next http.Handler) http.Handler {
 			if iat, ok := claims["iat"].(float64); ok {
 				tokenUser.IssuedAt = int64(iat)
 			}
+			if sid, ok := claims["sid"].(string); ok {
+				tokenUser.SessionID = sid
+			}
+			if mfa, ok := claims["mfa"].(bool); ok {
+				tokenUser.MFA = mfa
+			}
+			// RFC 8693 actor claim of impersonation tokens
+			if act, ok := claims["act"].(map[string]interface{}); ok {
+				if sub, ok := act["sub"].(string); ok {
+					tokenUser.ImpersonatorID, _ = strconv.Atoi(sub)
+				}
+			}
+
+			// Reject tokens whose session has been signed out (logout, remote sign-out, refresh token reuse)
+			active := false
+			if tokenUser.SessionID != "" {
+				active, err = app.DB.SessionRepo.Touch(r.Context(), tokenUser.SessionID)
+				if err != nil {
+					app.errorLog.Printf("Error checking session: %v", err)
+				}
+				// A session that couldn't be looked up isn't known to be revoked
+				if err != nil && !active {
+					app.writeJSON(w, http.StatusInternalServerError, models.Response{
+						Error:   true,
+						Message: "Internal Server Error",
+					})
+					return
+				}
+			}
+			if !active {
+				app.errorLog.Println("Session revoked for user:", tokenUser.ID)
+				app.writeJSON(w, http.StatusUnauthorized, models.Response{
+					Error:   true,
+					Message: "Session revoked. Please sign in again",
+				})
+				return
+			}
 			// No userStruct needed; user is already a *models.User
 			app.infoLog.Println(tokenUser.ID)
 			// Add user struct to the request context
 			ctx := context.WithValue(r.Context(), contextKey("user"), tokenUser)
+			if tokenUser.ImpersonatorID != 0 {
+				app.serveImpersonated(w, r.WithContext(ctx), next)
+				return
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		} else {
 			app.errorLog.Println("Invalid token")
@@ -134,7 +207,7 @@ func (app *application) AuthUser(next http.Handler) http.Handler {
 // GetUserTokenFromContext retrieves the user claims from the request context
 // It returns the user struct and a boolean indicating if the user was found
 // If the user is not found, it logs an error and returns nil
-// This function is used in the AuthAdmin middleware to check if the user is an admin
+// This function is used by the authorization middlewares to inspect the user's claims
 func (app *application) GetUserTokenFromContext(ctx context.Context) (*models.JWT, bool) {
 	user, ok := ctx.Value(contextKey("user")).(*models.JWT)
 	if !ok || user == nil {
@@ -144,30 +217,43 @@ func (app *application) GetUserTokenFromContext(ctx context.Context) (*models.JW
 	return user, true
 }
 
-// AuthAdmin is a middleware that checks if the user is an admin
-// It expects the user claims to be present in the request context
-// If the user is not an admin, it returns a 403 Forbidden response
-// If the user is an admin, it proceeds to the next handler
-func (app *application) AuthAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, ok := app.GetUserTokenFromContext(r.Context())
-		if !ok {
-			app.writeJSON(w, http.StatusUnauthorized, models.Response{
-				Error:   true,
-				Message: "Unauthorized: No user found in context",
-			})
-			return
-		}
-		if token.Role != "admin" {
-			app.writeJSON(w, http.StatusForbidden, models.Response{
-				Error:   true,
-				Message: "Forbidden: You do not have permission to access this resource",
-			})
-			return
-		}
-		// If the user is an admin, proceed to the next handler
-		next.ServeHTTP(w, r)
-	})
+// hasPermission reports whether the token grants the given permission
+func hasPermission(token *models.JWT, perm string) bool {
+	return slices.Contains(token.Perms, perm)
+}
+
+// RequirePermission returns a middleware that only lets users through whose role grants perm.
+// The permissions are read from the token so the check needs no database lookup.
+// When the admin two-factor policy is enabled, admins must also have signed in with a second factor.
+func (app *application) RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := app.GetUserTokenFromContext(r.Context())
+			if !ok {
+				app.writeJSON(w, http.StatusUnauthorized, models.Response{
+					Error:   true,
+					Message: "Unauthorized: No user found in context",
+				})
+				return
+			}
+			if !hasPermission(token, perm) {
+				app.writeJSON(w, http.StatusForbidden, models.Response{
+					Error:   true,
+					Message: "Forbidden: You do not have permission to access this resource",
+				})
+				return
+			}
+			// Admin access may require a session authenticated with a second factor
+			if app.config.auth.enforceAdminMFA && token.Role == models.RoleAdmin && !token.MFA {
+				app.writeJSON(w, http.StatusForbidden, models.Response{
+					Error:   true,
+					Message: "Forbidden: Two-factor authentication is required for admin access",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // Logger is a middleware that logs the details of each HTTP request.
@@ -240,3 +326,162 @@ func IsSubscriptionValid(ctx context.Context) (bool, string) {
 	// All checks passed
 	return true, "Access granted"
 }
+
+// RequireVerifiedEmail is a middleware that blocks users who have not verified
+// their email address when the verification policy is enabled
+func (app *application) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.auth.requireVerifiedEmail {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := app.GetUserTokenFromContext(r.Context())
+		if !ok {
+			app.writeJSON(w, http.StatusUnauthorized, models.Response{
+				Error:   true,
+				Message: "Unauthorized: No user found in context",
+			})
+			return
+		}
+		user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+		if err != nil {
+			app.errorLog.Printf("Failed to load user ID %d: %v", token.ID, err)
+			app.writeJSON(w, http.StatusInternalServerError, models.Response{
+				Error:   true,
+				Message: "Could not load user",
+			})
+			return
+		}
+		if app.emailVerificationRequired(user) {
+			app.writeJSON(w, http.StatusForbidden, models.Response{
+				Error:   true,
+				Message: "Please verify your email address first",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey resolves an X-API-Key header value into the token claims of its owner
+func (app *application) authenticateAPIKey(ctx context.Context, apiKey string) (*models.JWT, error) {
+	key, user, err := app.DB.APIKeyRepo.Authenticate(ctx, hashToken(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	if !user.Status {
+		return nil, errors.New("account deactivated")
+	}
+	perms, err := app.DB.RoleRepo.GetPermissions(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &models.JWT{
+		ID:       user.ID,
+		Name:     user.Name,
+		Username: user.Username,
+		Role:     user.Role,
+		Issuer:   app.config.jwt.issuer,
+		Audience: app.config.jwt.audience,
+		Perms:    perms,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}, nil
+}
+
+// RequireScope returns a middleware that only lets API key requests through when the key
+// was granted scope. Requests authenticated with a JWT session are not restricted.
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := app.GetUserTokenFromContext(r.Context())
+			if !ok {
+				app.writeJSON(w, http.StatusUnauthorized, models.Response{
+					Error:   true,
+					Message: "Unauthorized: No user found in context",
+				})
+				return
+			}
+			if token.APIKeyID != 0 && !slices.Contains(token.Scopes, scope) {
+				app.writeJSON(w, http.StatusForbidden, models.Response{
+					Error:   true,
+					Message: "Forbidden: API key is missing the " + scope + " scope",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession is a middleware that rejects requests authenticated with an API key.
+// It guards account management and other endpoints that are not exposed to automation.
+func (app *application) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := app.GetUserTokenFromContext(r.Context())
+		if !ok || token.APIKeyID != 0 {
+			app.writeJSON(w, http.StatusForbidden, models.Response{
+				Error:   true,
+				Message: "Forbidden: API keys cannot access this resource",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// serveImpersonated serves a request made with an impersonation token and records it in the audit log
+func (app *application) serveImpersonated(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+
+	token, _ := app.GetUserTokenFromContext(r.Context())
+	app.audit(r, "impersonation.request", "user", strconv.Itoa(token.ID), map[string]any{
+		"method": r.Method,
+		"path":   r.URL.Path,
+		"status": rec.status,
+	})
+}
+
+// DenyImpersonation is a middleware that blocks actions staff must not take on behalf of a user
+// such as changing credentials, deleting the account or spending money.
+func (app *application) DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := app.GetUserTokenFromContext(r.Context())
+		if !ok {
+			app.writeJSON(w, http.StatusUnauthorized, models.Response{
+				Error:   true,
+				Message: "Unauthorized: No user found in context",
+			})
+			return
+		}
+		if token.ImpersonatorID != 0 {
+			app.audit(r, "impersonation.denied", "user", strconv.Itoa(token.ID), map[string]any{
+				"method": r.Method,
+				"path":   r.URL.Path,
+			})
+			app.writeJSON(w, http.StatusForbidden, models.Response{
+				Error:   true,
+				Message: "Forbidden: This action is not available while impersonating a user",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,478 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samiulice/photostock/internal/mailer"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// maxReviewBatch is the number of media that can be approved or rejected in one request
+const maxReviewBatch = 100
+
+// mediaStatuses lists every review state
+var mediaStatuses = []string{
+	models.MediaStatusDraft,
+	models.MediaStatusPendingReview,
+	models.MediaStatusApproved,
+	models.MediaStatusRejected,
+}
+
+// statusScope returns a listMedia scope that filters by the ?status= parameter,
+// or by defaultStatus when it is missing. An empty status matches every state.
+func statusScope(r *http.Request, defaultStatus string) func(*models.MediaFilter) error {
+	return func(f *models.MediaFilter) error {
+		status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+		if status == "" {
+			status = defaultStatus
+		}
+		if status != "" && !slices.Contains(mediaStatuses, status) {
+			return errors.New("status must be draft, pending_review, approved or rejected")
+		}
+		f.Status = status
+		return nil
+	}
+}
+
+// ModerationQueue lists media by review state for reviewers, oldest first.
+// ?status= defaults to pending_review, ?flagged=true keeps only likely near-duplicates;
+// all ListMedia filters apply.
+func (app *application) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	byStatus := statusScope(r, models.MediaStatusPendingReview)
+	app.listMedia(w, r, models.MediaSortOldest, func(f *models.MediaFilter) error {
+		f.Flagged, _ = strconv.ParseBool(r.URL.Query().Get("flagged"))
+		return byStatus(f)
+	})
+}
+
+// MyMedia lists the media uploaded by the user in any review state, or the one given by ?status=
+func (app *application) MyMedia(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{Error: true, Message: "Access Denied"})
+		return
+	}
+	byStatus := statusScope(r, "")
+	app.listMedia(w, r, models.MediaSortNewest, func(f *models.MediaFilter) error {
+		f.UploaderID = token.ID
+		return byStatus(f)
+	})
+}
+
+// readReviewIDs reads and validates the media ids of a bulk review request
+func readReviewIDs(ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("ids is required")
+	}
+	if len(ids) > maxReviewBatch {
+		return nil, fmt.Errorf("At most %d media can be reviewed at once", maxReviewBatch)
+	}
+	var unique []int
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, errors.New("Invalid media id")
+		}
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// ApproveMedia approves media waiting for review, or previously rejected, and notifies their uploaders
+func (app *application) ApproveMedia(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		IDs []int `json:"ids"`
+	}
+	var Resp struct {
+		Error    bool   `json:"error"`
+		Message  string `json:"message"`
+		Approved []int  `json:"approved"`
+		Skipped  []int  `json:"skipped"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	ids, err := readReviewIDs(payload.IDs)
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+	token, _ := app.GetUserTokenFromContext(r.Context())
+
+	changed, err := app.DB.ModerationRepo.Transition(r.Context(), ids,
+		[]string{models.MediaStatusPendingReview, models.MediaStatusRejected}, models.MediaStatusApproved,
+		token.ID, nil, "")
+	if err != nil {
+		app.errorLog.Println("ERROR: ApproveMedia =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.notifyReviewed(r.Context(), changed, models.MediaStatusApproved, nil, "")
+
+	Resp.Approved, Resp.Skipped = reviewOutcome(ids, changed)
+	Resp.Error = false
+	Resp.Message = fmt.Sprintf("%d media approved", len(Resp.Approved))
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RejectMedia rejects media waiting for review, or takes down approved ones, with a reason
+// from the managed list and an optional note, and notifies their uploaders
+func (app *application) RejectMedia(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		IDs      []int  `json:"ids"`
+		ReasonID int    `json:"reason_id"`
+		Note     string `json:"note"`
+	}
+	var Resp struct {
+		Error    bool   `json:"error"`
+		Message  string `json:"message"`
+		Rejected []int  `json:"rejected"`
+		Skipped  []int  `json:"skipped"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	ids, err := readReviewIDs(payload.IDs)
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+	payload.Note = strings.TrimSpace(payload.Note)
+	if len(payload.Note) > 1000 {
+		app.badRequest(w, errors.New("Note must be at most 1000 characters"))
+		return
+	}
+	reason, err := app.DB.ModerationRepo.GetReason(r.Context(), payload.ReasonID)
+	if err != nil || !reason.Active {
+		if err != nil && !errors.Is(err, repositories.ErrRejectionReasonNotFound) {
+			app.errorLog.Println("ERROR: RejectMedia =>", err)
+		}
+		Resp.Error = true
+		Resp.Message = "A valid rejection reason is required"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+	token, _ := app.GetUserTokenFromContext(r.Context())
+
+	changed, err := app.DB.ModerationRepo.Transition(r.Context(), ids,
+		[]string{models.MediaStatusPendingReview, models.MediaStatusApproved}, models.MediaStatusRejected,
+		token.ID, &reason.ID, payload.Note)
+	if err != nil {
+		app.errorLog.Println("ERROR: RejectMedia =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.notifyReviewed(r.Context(), changed, models.MediaStatusRejected, reason, payload.Note)
+
+	Resp.Rejected, Resp.Skipped = reviewOutcome(ids, changed)
+	Resp.Error = false
+	Resp.Message = fmt.Sprintf("%d media rejected", len(Resp.Rejected))
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// reviewOutcome splits the requested ids into the ones that changed state and the skipped ones
+func reviewOutcome(ids []int, changed []*models.ReviewedMedia) (done, skipped []int) {
+	done, skipped = []int{}, []int{}
+	for _, id := range ids {
+		if slices.ContainsFunc(changed, func(m *models.ReviewedMedia) bool { return m.ID == id }) {
+			done = append(done, id)
+		} else {
+			skipped = append(skipped, id)
+		}
+	}
+	return done, skipped
+}
+
+// notifyReviewed emails each uploader one message listing their media that were approved or rejected
+func (app *application) notifyReviewed(ctx context.Context, changed []*models.ReviewedMedia, status string, reason *models.RejectionReason, note string) {
+	byUploader := map[int][]string{}
+	for _, m := range changed {
+		if m.UploaderID != 0 {
+			byUploader[m.UploaderID] = append(byUploader[m.UploaderID], m.Title)
+		}
+	}
+
+	for uploaderID, titles := range byUploader {
+		user, err := app.DB.UserRepo.GetByID(ctx, uploaderID)
+		if err != nil {
+			app.errorLog.Printf("Unable to notify uploader %d of a review: %v", uploaderID, err)
+			continue
+		}
+		list := "- " + strings.Join(titles, "\n- ")
+
+		msg := mailer.Message{To: []string{user.Email}}
+		if status == models.MediaStatusApproved {
+			msg.Subject = models.APPName + ": your media was approved"
+			msg.Body = fmt.Sprintf("Hi %s,\n\nThe following media were approved and are now live in the catalog:\n\n%s\n", user.Name, list)
+		} else {
+			msg.Subject = models.APPName + ": your media was not accepted"
+			msg.Body = fmt.Sprintf("Hi %s,\n\nThe following media were not accepted:\n\n%s\n\nReason: %s\n%s\n",
+				user.Name, list, reason.Title, reason.Description)
+			if note != "" {
+				msg.Body += "\nReviewer note: " + note + "\n"
+			}
+			msg.Body += "\nYou can edit them and submit them for review again.\n"
+		}
+		app.sendMail(msg)
+	}
+}
+
+// SubmitMedia sends a draft, or a rejected media item, to the review queue
+func (app *application) SubmitMedia(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Status  string `json:"status,omitempty"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+	token, _ := app.GetUserTokenFromContext(r.Context())
+
+	changed, err := app.DB.ModerationRepo.Transition(r.Context(), []int{media.ID},
+		[]string{models.MediaStatusDraft, models.MediaStatusRejected}, models.MediaStatusPendingReview,
+		token.ID, nil, "")
+	if err != nil {
+		app.errorLog.Println("ERROR: SubmitMedia =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if len(changed) == 0 {
+		Resp.Error = true
+		Resp.Message = "Only drafts and rejected media can be submitted for review"
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Media submitted for review"
+	Resp.Status = models.MediaStatusPendingReview
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// MediaHistory returns the review history of a media item to its uploader and reviewers
+func (app *application) MediaHistory(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool                        `json:"error"`
+		Message string                      `json:"message"`
+		History []*models.MediaStatusChange `json:"history"`
+	}
+
+	media := app.loadManagedMedia(w, r)
+	if media == nil {
+		return
+	}
+	history, err := app.DB.ModerationRepo.History(r.Context(), media.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: MediaHistory =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "History fetched successfully"
+	Resp.History = history
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ListRejectionReasons returns the active rejection reasons, or all of them with ?all=true
+func (app *application) ListRejectionReasons(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool                      `json:"error"`
+		Message string                    `json:"message"`
+		Reasons []*models.RejectionReason `json:"reasons"`
+	}
+
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	reasons, err := app.DB.ModerationRepo.GetReasons(r.Context(), !all)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListRejectionReasons =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Rejection reasons fetched successfully"
+	Resp.Reasons = reasons
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// writeRejectionReason writes the response of a rejection reason change
+func (app *application) writeRejectionReason(w http.ResponseWriter, reason *models.RejectionReason, err error, action string, status int, message string) {
+	var Resp struct {
+		Error   bool                    `json:"error"`
+		Message string                  `json:"message"`
+		Reason  *models.RejectionReason `json:"reason,omitempty"`
+	}
+	switch {
+	case err == nil:
+		Resp.Message = message
+		Resp.Reason = reason
+		app.writeJSON(w, status, Resp)
+	case errors.Is(err, repositories.ErrRejectionReasonNotFound):
+		Resp.Error = true
+		Resp.Message = "Rejection reason not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+	case errors.Is(err, repositories.ErrRejectionReasonExists):
+		Resp.Error = true
+		Resp.Message = "A rejection reason with this code already exists"
+		app.writeJSON(w, http.StatusConflict, Resp)
+	default:
+		app.errorLog.Printf("ERROR: %s => %v", action, err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+	}
+}
+
+// CreateRejectionReason adds a reason to the managed list
+func (app *application) CreateRejectionReason(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code        string `json:"code"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	_, code := utils.NormalizeTag(payload.Code)
+	reason := &models.RejectionReason{
+		Code:        strings.ReplaceAll(code, "-", "_"),
+		Title:       strings.TrimSpace(payload.Title),
+		Description: strings.TrimSpace(payload.Description),
+		Active:      true,
+	}
+	if reason.Code == "" || reason.Title == "" {
+		app.badRequest(w, errors.New("code and title are required"))
+		return
+	}
+
+	reason, err := app.DB.ModerationRepo.CreateReason(r.Context(), reason)
+	if err == nil {
+		app.audit(r, "rejection_reason.create", "rejection_reason", strconv.Itoa(reason.ID), map[string]any{"code": reason.Code})
+	}
+	app.writeRejectionReason(w, reason, err, "CreateRejectionReason", http.StatusCreated, "Rejection reason created")
+}
+
+// UpdateRejectionReason changes a reason of the managed list. Omitted fields are left unchanged.
+// Reasons can't be deleted, set active to false to retire one.
+func (app *application) UpdateRejectionReason(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Active      *bool   `json:"active"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	reason, err := app.DB.ModerationRepo.GetReason(r.Context(), id)
+	if err != nil {
+		app.writeRejectionReason(w, nil, err, "UpdateRejectionReason", http.StatusOK, "")
+		return
+	}
+	if payload.Title != nil {
+		reason.Title = strings.TrimSpace(*payload.Title)
+		if reason.Title == "" {
+			app.badRequest(w, errors.New("title cannot be empty"))
+			return
+		}
+	}
+	if payload.Description != nil {
+		reason.Description = strings.TrimSpace(*payload.Description)
+	}
+	if payload.Active != nil {
+		reason.Active = *payload.Active
+	}
+
+	reason, err = app.DB.ModerationRepo.UpdateReason(r.Context(), reason)
+	if err == nil {
+		app.audit(r, "rejection_reason.update", "rejection_reason", strconv.Itoa(id), map[string]any{"active": reason.Active})
+	}
+	app.writeRejectionReason(w, reason, err, "UpdateRejectionReason", http.StatusOK, "Rejection reason updated")
+}
+
+// ListDuplicateClusters groups catalog media whose perceptual hashes are within ?distance=
+// bits (defaults to the upload setting, at most 16) so reviewers can clean up re-uploads.
+// ?limit= caps the number of linked pairs examined (default 1000, at most 10000).
+func (app *application) ListDuplicateClusters(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error    bool                       `json:"error"`
+		Message  string                     `json:"message"`
+		Distance int                        `json:"distance"`
+		Clusters []*models.DuplicateCluster `json:"clusters"`
+	}
+
+	query := r.URL.Query()
+	distance := max(app.config.media.duplicateDistance, 0)
+	if v := query.Get("distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 16 {
+			app.badRequest(w, errors.New("distance must be between 0 and 16"))
+			return
+		}
+		distance = d
+	}
+	limit := 1000
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = min(v, 10000)
+	}
+
+	clusters, err := app.DB.MediaRepo.DuplicateClusters(r.Context(), distance, limit)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListDuplicateClusters =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	for _, c := range clusters {
+		for _, m := range c.Media {
+			baseURL, _ := url.Parse(models.APIEndPoint)
+			baseURL.Path = path.Join(baseURL.Path, "public", "thumbnails", "thumb_"+m.MediaUUID)
+			m.MediaURL = baseURL.String()
+		}
+	}
+
+	Resp.Error = false
+	Resp.Message = fmt.Sprintf("%d duplicate clusters found", len(clusters))
+	Resp.Distance = distance
+	Resp.Clusters = clusters
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/oidc"
+	"github.com/samiulice/photostock/internal/repositories"
+)
+
+const (
+	oidcFlowTTL      = 10 * time.Minute // time to finish signing in at the provider
+	oidcLoginCodeTTL = time.Minute      // time for the web client to redeem the login code
+)
+
+// startOIDCFlow stores a new flow for the provider and returns the provider's sign-in URL.
+// linkUserID is set when a signed-in user links the provider to their account.
+func (app *application) startOIDCFlow(ctx context.Context, provider *oidc.Provider, linkUserID *int) (string, error) {
+	state, err := generateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+	flow := &models.OIDCFlow{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: oidc.NewVerifier(),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcFlowTTL),
+	}
+	if err := app.DB.IdentityRepo.CreateFlow(ctx, flow); err != nil {
+		return "", err
+	}
+	return provider.AuthCodeURL(state, nonce, flow.CodeVerifier), nil
+}
+
+// redirectToFrontend sends the browser back to the web client with the given query parameters
+func (app *application) redirectToFrontend(w http.ResponseWriter, r *http.Request, path string, params url.Values) {
+	target := strings.TrimRight(app.config.frontendURL, "/") + path + "?" + params.Encode()
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ListOIDCProviders returns the names of the external sign-in providers
+func (app *application) ListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error     bool     `json:"error"`
+		Message   string   `json:"message"`
+		Providers []string `json:"providers"`
+	}
+	Resp.Error = false
+	Resp.Message = "Providers fetched successfully"
+	Resp.Providers = app.OIDC.Names()
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// StartOIDCLogin begins signing in with an external provider.
+// The web client sends the browser to the returned authorization_url.
+func (app *application) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error            bool   `json:"error"`
+		Message          string `json:"message"`
+		AuthorizationURL string `json:"authorization_url,omitempty"`
+	}
+
+	provider, err := app.OIDC.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = "Unknown identity provider"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	authURL, err := app.startOIDCFlow(r.Context(), provider, nil)
+	if err != nil {
+		app.errorLog.Println("ERROR: StartOIDCLogin =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Continue at the identity provider"
+	Resp.AuthorizationURL = authURL
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// StartOIDCLink begins linking an external provider to the logged-in user
+func (app *application) StartOIDCLink(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error            bool   `json:"error"`
+		Message          string `json:"message"`
+		AuthorizationURL string `json:"authorization_url,omitempty"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+	provider, err := app.OIDC.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = "Unknown identity provider"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	authURL, err := app.startOIDCFlow(r.Context(), provider, &token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: StartOIDCLink =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Continue at the identity provider"
+	Resp.AuthorizationURL = authURL
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// OIDCCallback is where the provider sends the browser back to. It finishes the flow and
+// redirects to the web client: sign-ins get a single use code for ExchangeOIDCCode,
+// link flows go back to the connected accounts page.
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	fail := func(path, reason string) {
+		app.redirectToFrontend(w, r, path, url.Values{"error": {reason}, "provider": {providerName}})
+	}
+
+	flow, err := app.DB.IdentityRepo.ConsumeFlow(r.Context(), hashToken(query.Get("state")), providerName)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrOIDCFlowInvalid) {
+			app.errorLog.Println("ERROR: OIDCCallback => unable to load flow:", err)
+		}
+		fail("/oauth/callback", "invalid_state")
+		return
+	}
+	path := "/oauth/callback"
+	if flow.LinkUserID != nil {
+		path = "/settings/connections"
+	}
+	if e := query.Get("error"); e != "" {
+		// the user declined or the provider refused
+		fail(path, e)
+		return
+	}
+
+	provider, err := app.OIDC.Get(providerName)
+	if err != nil {
+		fail(path, "unknown_provider")
+		return
+	}
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		app.errorLog.Println("ERROR: OIDCCallback =>", err)
+		fail(path, "exchange_failed")
+		return
+	}
+
+	if flow.LinkUserID != nil {
+		if err := app.linkIdentity(r.Context(), *flow.LinkUserID, identity); err != nil {
+			if errors.Is(err, repositories.ErrIdentityLinked) {
+				fail(path, "already_linked")
+				return
+			}
+			app.errorLog.Println("ERROR: OIDCCallback => unable to link identity:", err)
+			fail(path, "server_error")
+			return
+		}
+		app.redirectToFrontend(w, r, path, url.Values{"linked": {providerName}})
+		return
+	}
+
+	user, err := app.userForIdentity(r.Context(), identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOIDCEmailUnverified):
+			fail(path, "email_not_verified")
+		case errors.Is(err, errOIDCAccountExists):
+			fail(path, "account_exists")
+		default:
+			app.errorLog.Println("ERROR: OIDCCallback => unable to resolve user:", err)
+			fail(path, "server_error")
+		}
+		return
+	}
+
+	code, err := generateOpaqueToken(32)
+	if err == nil {
+		err = app.DB.IdentityRepo.CreateLoginCode(r.Context(), hashToken(code), user.ID, time.Now().Add(oidcLoginCodeTTL))
+	}
+	if err != nil {
+		app.errorLog.Println("ERROR: OIDCCallback => unable to create login code:", err)
+		fail(path, "server_error")
+		return
+	}
+	app.redirectToFrontend(w, r, path, url.Values{"code": {code}, "provider": {providerName}})
+}
+
+var (
+	errOIDCEmailUnverified = errors.New("provider did not verify the email address")
+	errOIDCAccountExists   = errors.New("an account with this email exists but is not verified")
+)
+
+// userForIdentity returns the user signing in with an external identity.
+// Unknown identities are linked to the user with the same verified email, or get a new user.
+func (app *application) userForIdentity(ctx context.Context, identity *oidc.Identity) (*models.User, error) {
+	linked, err := app.DB.IdentityRepo.GetByProviderSubject(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if err := app.DB.IdentityRepo.TouchLogin(ctx, linked.ID); err != nil {
+			app.errorLog.Println("ERROR: unable to record identity sign-in:", err)
+		}
+		return app.DB.UserRepo.GetByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, repositories.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errOIDCEmailUnverified
+	}
+
+	user, err := app.DB.UserRepo.GetByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// Only link to accounts that proved they own the address, otherwise whoever
+		// registered it first would get access to the provider account holder's sign-ins
+		if user.EmailVerifiedAt == nil {
+			return nil, errOIDCAccountExists
+		}
+	case errors.Is(err, pgx.ErrNoRows):
+		if user, err = app.createOIDCUser(ctx, identity); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := app.linkIdentity(ctx, user.ID, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createOIDCUser registers a user for an external identity. The user has no password
+// and can set one with the password reset flow.
+func (app *application) createOIDCUser(ctx context.Context, identity *oidc.Identity) (*models.User, error) {
+	local := strings.Split(identity.Email, "@")[0]
+	user := &models.User{
+		Email:    identity.Email,
+		Username: local + "_" + app.GenerateRandomAlphanumericCode(4),
+		Name:     strings.TrimSpace(identity.Name),
+		Status:   true,
+		Role:     models.RoleUser,
+		AvatarID: local + "_" + uuid.NewString() + ".jpg",
+	}
+	if err := app.DB.UserRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	// the provider verified the address
+	if _, err := app.DB.UserRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+		return nil, err
+	}
+	return app.DB.UserRepo.GetByID(ctx, user.ID)
+}
+
+// linkIdentity attaches an external identity to a user
+func (app *application) linkIdentity(ctx context.Context, userID int, identity *oidc.Identity) error {
+	return app.DB.IdentityRepo.Create(ctx, &models.UserIdentity{
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+}
+
+// ExchangeOIDCCode redeems the code handed to the web client by OIDCCallback.
+// The response is the same as Login's.
+func (app *application) ExchangeOIDCCode(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	userID, err := app.DB.IdentityRepo.ConsumeLoginCode(r.Context(), hashToken(strings.TrimSpace(payload.Code)))
+	if err != nil {
+		if !errors.Is(err, repositories.ErrOIDCFlowInvalid) {
+			app.errorLog.Println("ERROR: ExchangeOIDCCode =>", err)
+		}
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{
+			Error:   true,
+			Message: "Sign-in expired. Please try again",
+		})
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), userID)
+	if err != nil || !user.Status {
+		app.writeJSON(w, http.StatusUnauthorized, models.Response{
+			Error:   true,
+			Message: "Account deactivated. Please contact support",
+		})
+		return
+	}
+	app.finishSignIn(w, r, user)
+}
+
+// ListIdentities returns the external accounts linked to the logged-in user
+func (app *application) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error      bool                   `json:"error"`
+		Message    string                 `json:"message"`
+		Identities []*models.UserIdentity `json:"identities"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	identities, err := app.DB.IdentityRepo.GetAllByUserID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListIdentities =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Linked accounts fetched successfully"
+	Resp.Identities = identities
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// UnlinkIdentity removes a linked external account. The last sign-in method of
+// a user without a password can't be removed.
+func (app *application) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: UnlinkIdentity =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	identities, err := app.DB.IdentityRepo.GetAllByUserID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: UnlinkIdentity =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if user.Password == "" && len(identities) <= 1 {
+		Resp.Error = true
+		Resp.Message = "Set a password before removing your last linked account"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	if err := app.DB.IdentityRepo.Delete(r.Context(), id, token.ID); err != nil {
+		if errors.Is(err, repositories.ErrIdentityNotFound) {
+			Resp.Error = true
+			Resp.Message = "Linked account not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: UnlinkIdentity =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Account unlinked"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/samiulice/photostock/internal/mailer"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ChangePassword replaces the password of the logged-in user after verifying the current one.
+// Every other login session of the user is signed out.
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: ChangePassword => user lookup failed:", err)
+		Resp.Error = true
+		Resp.Message = "Could not load user"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.OldPassword)); err != nil {
+		app.errorLog.Printf("ERROR: ChangePassword => wrong current password for user: %s", user.Username)
+		Resp.Error = true
+		Resp.Message = "Current password is incorrect"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	newPassword := strings.TrimSpace(payload.NewPassword)
+	if err := validatePassword(newPassword); err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		app.errorLog.Println("ERROR: ChangePassword => unable to hash password:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.DB.UserRepo.UpdatePassword(r.Context(), user.ID, string(hashedPassword)); err != nil {
+		app.errorLog.Println("ERROR: ChangePassword => unable to update password:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	// keep the current session, sign out everywhere else
+	if err := app.DB.SessionRepo.RevokeOthers(r.Context(), user.ID, token.SessionID); err != nil {
+		app.errorLog.Println("ERROR: ChangePassword => unable to revoke sessions:", err)
+	}
+
+	app.sendMail(mailer.Message{
+		To:      []string{user.Email},
+		Subject: models.APPName + ": your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your %s account was changed on %s.\n"+
+			"If you did not make this change, reset your password immediately and contact support.\n",
+			user.Name, models.APPName, time.Now().Format(time.RFC1123)),
+	})
+
+	Resp.Error = false
+	Resp.Message = "Password changed successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ForgotPassword emails a single-use password reset link to the given address.
+// The response is the same whether or not the address belongs to an account.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "If an account exists for this email, a password reset link has been sent"
+
+	user, err := app.DB.UserRepo.GetByEmail(r.Context(), strings.TrimSpace(payload.Email))
+	if err != nil || !user.Status {
+		app.infoLog.Printf("Password reset requested for unknown or inactive email: %s", payload.Email)
+		app.writeJSON(w, http.StatusOK, Resp)
+		return
+	}
+
+	resetToken, err := generateOpaqueToken(32)
+	if err != nil {
+		app.errorLog.Println("ERROR: ForgotPassword => unable to generate token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	err = app.DB.PasswordResetRepo.Create(r.Context(), &models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(resetToken),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		app.errorLog.Println("ERROR: ForgotPassword => unable to store token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	link := strings.TrimRight(app.config.frontendURL, "/") + "/reset-password?token=" + url.QueryEscape(resetToken)
+	app.sendMail(mailer.Message{
+		To:      []string{user.Email},
+		Subject: models.APPName + ": reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. The link expires in %s and can only be used once.\n\n%s\n\n"+
+			"If you did not request a password reset, you can ignore this email.\n",
+			user.Name, passwordResetTTL, link),
+	})
+
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ResetPassword sets a new password using a token sent by ForgotPassword.
+// The token is consumed and every existing login session of the user is revoked.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	newPassword := strings.TrimSpace(payload.NewPassword)
+	if err := validatePassword(newPassword); err != nil {
+		Resp.Error = true
+		Resp.Message = err.Error()
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		app.errorLog.Println("ERROR: ResetPassword => unable to hash password:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	userID, err := app.DB.PasswordResetRepo.Consume(r.Context(), hashToken(strings.TrimSpace(payload.Token)))
+	if err != nil {
+		if errors.Is(err, repositories.ErrPasswordResetInvalid) {
+			Resp.Error = true
+			Resp.Message = "Invalid or expired reset link"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: ResetPassword => unable to consume token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if err := app.DB.UserRepo.UpdatePassword(r.Context(), userID, string(hashedPassword)); err != nil {
+		app.errorLog.Println("ERROR: ResetPassword => unable to update password:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), userID); err != nil {
+		app.errorLog.Println("ERROR: ResetPassword => unable to revoke sessions:", err)
+	}
+
+	Resp.Error = false
+	Resp.Message = "Password reset successfully. Please sign in with your new password"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
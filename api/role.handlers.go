@@ -0,0 +1,253 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+)
+
+// roleNamePattern restricts role names to short lowercase identifiers
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// normalizePermissions trims and de-duplicates permission names
+func normalizePermissions(perms []string) []string {
+	out := []string{}
+	for _, p := range perms {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" && !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ListRoles returns every role with its permissions
+func (app *application) ListRoles(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool           `json:"error"`
+		Message string         `json:"message"`
+		Roles   []*models.Role `json:"roles"`
+	}
+
+	roles, err := app.DB.RoleRepo.GetAll(r.Context())
+	if err != nil {
+		app.errorLog.Println("ERROR: ListRoles =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Roles fetched successfully"
+	Resp.Roles = roles
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ListPermissions returns every permission that can be granted to a role
+func (app *application) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error       bool                 `json:"error"`
+		Message     string               `json:"message"`
+		Permissions []*models.Permission `json:"permissions"`
+	}
+
+	perms, err := app.DB.RoleRepo.GetAllPermissions(r.Context())
+	if err != nil {
+		app.errorLog.Println("ERROR: ListPermissions =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Permissions fetched successfully"
+	Resp.Permissions = perms
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// CreateRole adds a new role with the given permissions
+func (app *application) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var role models.Role
+	var Resp struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		Role    *models.Role `json:"role,omitempty"`
+	}
+	if err := app.readJSON(w, r, &role); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	role.Name = strings.ToLower(strings.TrimSpace(role.Name))
+	role.Description = strings.TrimSpace(role.Description)
+	role.Permissions = normalizePermissions(role.Permissions)
+	if !roleNamePattern.MatchString(role.Name) {
+		Resp.Error = true
+		Resp.Message = "Invalid role name: use 2-50 lowercase letters, digits, '-' or '_'"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	exists, err := app.DB.RoleRepo.Exists(r.Context(), role.Name)
+	if err != nil {
+		app.errorLog.Println("ERROR: CreateRole =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if exists {
+		Resp.Error = true
+		Resp.Message = "Role already exists"
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	}
+
+	if err := app.DB.RoleRepo.Create(r.Context(), &role); err != nil {
+		if errors.Is(err, repositories.ErrUnknownPermission) {
+			Resp.Error = true
+			Resp.Message = "Unknown permission"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: CreateRole =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Role created successfully"
+	Resp.Role = &role
+	app.writeJSON(w, http.StatusCreated, Resp)
+}
+
+// UpdateRole replaces the description and permissions of a role.
+// Signed-in members pick up the change when their access token is refreshed.
+func (app *application) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	var role models.Role
+	var Resp struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		Role    *models.Role `json:"role,omitempty"`
+	}
+	if err := app.readJSON(w, r, &role); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	role.Name = strings.ToLower(strings.TrimSpace(role.Name))
+	role.Description = strings.TrimSpace(role.Description)
+	role.Permissions = normalizePermissions(role.Permissions)
+
+	// Never let the admin role lose the ability to manage roles, it would lock everyone out
+	if role.Name == models.RoleAdmin && !slices.Contains(role.Permissions, models.PermRolesManage) {
+		Resp.Error = true
+		Resp.Message = "The admin role must keep the " + models.PermRolesManage + " permission"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	if err := app.DB.RoleRepo.Update(r.Context(), &role); err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrRoleNotFound):
+			Resp.Error = true
+			Resp.Message = "Role not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+		case errors.Is(err, repositories.ErrUnknownPermission):
+			Resp.Error = true
+			Resp.Message = "Unknown permission"
+			app.writeJSON(w, http.StatusBadRequest, Resp)
+		default:
+			app.errorLog.Println("ERROR: UpdateRole =>", err)
+			Resp.Error = true
+			Resp.Message = "Internal server error"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+		}
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Role updated successfully"
+	Resp.Role = &role
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// AssignRole changes the role of a user. The user's sessions are signed out
+// so the new permissions apply immediately.
+func (app *application) AssignRole(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID int    `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+	if payload.UserID == token.ID {
+		Resp.Error = true
+		Resp.Message = "You cannot change your own role"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	payload.Role = strings.ToLower(strings.TrimSpace(payload.Role))
+	exists, err := app.DB.RoleRepo.Exists(r.Context(), payload.Role)
+	if err != nil {
+		app.errorLog.Println("ERROR: AssignRole =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if !exists {
+		Resp.Error = true
+		Resp.Message = "Role not found"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	if err := app.DB.UserRepo.UpdateRole(r.Context(), payload.UserID, payload.Role); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			Resp.Error = true
+			Resp.Message = "User not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: AssignRole =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), payload.UserID); err != nil {
+		app.errorLog.Println("ERROR: AssignRole => unable to revoke sessions:", err)
+	}
+
+	app.infoLog.Printf("Role of user %d set to %s by user %d", payload.UserID, payload.Role, token.ID)
+	Resp.Error = false
+	Resp.Message = "Role assigned successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/samiulice/photostock/internal/models"
 )
 
 func (app *application) routes() http.Handler {
@@ -13,7 +14,7 @@ func (app *application) routes() http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
@@ -25,53 +26,115 @@ func (app *application) routes() http.Handler {
 	thumbnailDir := filepath.Join(".", "assets", "images", "public")
 	fs := http.StripPrefix("/public/", http.FileServer(http.Dir(thumbnailDir)))
 	mux.Handle("/public/*", fs)
+	mux.Get("/public/img/{uuid}", app.TransformImage) // Signed, cached resizes of media images
+
+	// Public keys for verifying access tokens
+	mux.Get("/.well-known/jwks.json", app.JWKS)
 
 	// --- Authentication & User Management ---
 	mux.Route("/api/v1/auth", func(r chi.Router) {
-		r.Post("/register", app.Register) // Register a new user
-		r.Post("/login", app.Login)       // User login
-		// r.Post("/logout", app.Logout)               // User logout
+		r.Post("/register", app.Register)              // Register a new user
+		r.Post("/login", app.Login)                    // User login
+		r.Post("/refresh", app.RefreshToken)           // Exchange a refresh token for a new token pair
+		r.Post("/logout", app.Logout)                  // User logout: revokes the refresh token session
+		r.Post("/forgot-password", app.ForgotPassword) // Request password reset via email
+		r.Post("/reset-password", app.ResetPassword)   // Reset password using token
+		r.Post("/verify-email", app.VerifyEmail)       // Confirm email address using the emailed token
+		r.Post("/mfa/verify", app.VerifyMFA)           // Second login step: exchange pending token + TOTP code
+
+		// Sign-in with external OpenID Connect providers
+		r.Get("/oidc", app.ListOIDCProviders)                // Names of the configured providers
+		r.Post("/oidc/{provider}/start", app.StartOIDCLogin) // Authorization URL to send the browser to
+		r.Get("/oidc/{provider}/callback", app.OIDCCallback) // Provider redirect, forwards to the web client
+		r.Post("/oidc/exchange", app.ExchangeOIDCCode)       // Trade the callback code for a token pair
 		r.Group(func(r chi.Router) {
-			r.Use(app.AuthUser)
+			r.Use(app.AuthUser, app.RequireSession)
 			r.Get("/profile", app.Profile) // Get currently logged-in user's profile
 			//TODO: separate update profile functionality
-			r.Put("/profile", app.UpdateProfile)                // Update user profile information
-			r.Put("/profile/image", app.UpdateProfileImage)     // Update user profile information
-			r.Put("/profile/deactivate", app.DeactivateProfile) // Deactivate user profile information
-			r.Delete("/profile/delete", app.DeleteProfile)      // Delete user profile information
-			// r.Put("/password", app.ChangePassword)      // Change password for logged-in user
-			// r.Post("/forgot-password", app.ForgotPassword) // Request password reset via email
-			// r.Post("/reset-password", app.ResetPassword)   // Reset password using token
+			r.With(app.DenyImpersonation).Put("/profile", app.UpdateProfile)                // Update user profile information
+			r.With(app.DenyImpersonation).Put("/profile/image", app.UpdateProfileImage)     // Update user profile information
+			r.With(app.DenyImpersonation).Put("/profile/deactivate", app.DeactivateProfile) // Deactivate user profile information
+			r.With(app.DenyImpersonation).Delete("/profile/delete", app.DeleteProfile)      // Delete user profile information
+			r.With(app.DenyImpersonation).Put("/password", app.ChangePassword)              // Change password for logged-in user
+			r.Post("/resend-verification", app.ResendVerification)                          // Send a new email verification link
+
+			// Two-factor authentication (TOTP)
+			r.Get("/mfa", app.MFAStatus)                                                           // Two-factor status
+			r.With(app.DenyImpersonation).Post("/mfa/enroll", app.EnrollMFA)                       // Start enrollment: secret, otpauth URI, QR code
+			r.With(app.DenyImpersonation).Get("/mfa/qr", app.MFAQRCode)                            // QR code PNG of the pending enrollment
+			r.With(app.DenyImpersonation).Post("/mfa/activate", app.ActivateMFA)                   // Confirm enrollment, returns recovery codes
+			r.With(app.DenyImpersonation).Post("/mfa/recovery-codes", app.RegenerateRecoveryCodes) // Replace recovery codes
+			r.With(app.DenyImpersonation).Post("/mfa/disable", app.DisableMFA)                     // Turn two-factor authentication off
+
+			// Signed-in devices
+			r.Get("/sessions", app.ListSessions)                                      // List active sessions
+			r.With(app.DenyImpersonation).Delete("/sessions", app.RevokeAllSessions)  // Sign out everywhere
+			r.With(app.DenyImpersonation).Delete("/sessions/{id}", app.RevokeSession) // Sign out a single session
+
+			// Personal API keys for automation
+			r.Get("/api-keys", app.ListAPIKeys)                                      // List API keys of the logged-in user
+			r.With(app.DenyImpersonation).Post("/api-keys", app.CreateAPIKey)        // Create a new API key (shown once)
+			r.With(app.DenyImpersonation).Put("/api-keys/{id}", app.UpdateAPIKey)    // Update label and scopes of an API key
+			r.With(app.DenyImpersonation).Delete("/api-keys/{id}", app.RevokeAPIKey) // Revoke an API key
+
+			// Linked external accounts
+			r.Get("/identities", app.ListIdentities)                                       // Linked provider accounts
+			r.With(app.DenyImpersonation).Post("/oidc/{provider}/link", app.StartOIDCLink) // Link another provider account
+			r.With(app.DenyImpersonation).Delete("/identities/{id}", app.UnlinkIdentity)   // Unlink a provider account
 		})
 	})
 
+	// --- Search ---
+	mux.Get("/api/v1/search/suggest", app.SuggestSearch) // Type-ahead suggestions for the search box
+
 	// --- Media Management ---
 	mux.Route("/api/v1/media", func(r chi.Router) {
 		r.Get("/", app.ListMedia)                // List all media
 		r.Get("/details", app.FetchMediaDetails) // List all media
+		r.Get("/search", app.SearchMedia)        // Full-text search with ranking and highlights
+		r.Get("/tags", app.PopularTags)          // Most used tags
+		r.Get("/tags/{slug}", app.MediaByTag)    // Media with a tag
 		r.Group(func(r chi.Router) {
 			r.Use(app.AuthUser)
-			r.Post("/", app.UploadMedia) // Upload new media
+			r.With(app.DenyImpersonation, app.RequireVerifiedEmail, app.RequirePermission(models.PermMediaUpload), app.RequireScope(models.ScopeMediaWrite)).Post("/", app.UploadMedia) // Upload new media
 			// Secure premium endpoint
 			r.Group(func(r chi.Router) { // Regular auth check
 				// r.Use(app.WithSubscriptionCheck) // Premium subscription check
 
-				r.Get("/premium", app.ServeMedia)
+				r.With(app.DenyImpersonation, app.RequireScope(models.ScopeMediaRead)).Get("/premium", app.ServeMedia) // Downloads use the user's quota and carry their fingerprint
 			}) // Retrieve a single media item by ID
-		})
 
-		// 	r.Put("/{id}", app.UpdateMedia)                   // Update an existing media item
-		// 	r.Delete("/{id}", app.DeleteMedia)                // Delete a media item
+			r.With(app.RequireScope(models.ScopeMediaRead)).Get("/mine", app.MyMedia) // Own uploads in every review state
+
+			// Owners and moderators
+			r.With(app.DenyImpersonation, app.RequireScope(models.ScopeMediaWrite)).Post("/{id}/submit", app.SubmitMedia)                // Send a draft or rejected item for review
+			r.With(app.RequireScope(models.ScopeMediaRead)).Get("/{id}/history", app.MediaHistory)                                       // Review history
+			r.With(app.RequireScope(models.ScopeMediaRead)).Get("/{id}/metadata", app.MediaMetadata)                                     // Embedded EXIF/IPTC/XMP metadata
+			r.With(app.RequireScope(models.ScopeMediaRead)).Get("/{id}/processing", app.MediaProcessing)                                 // Background processing status
+			r.With(app.RequireSession, app.DenyImpersonation, app.RequireScope(models.ScopeMediaWrite)).Put("/{id}", app.UpdateMedia)    // Update an existing media item
+			r.With(app.RequireSession, app.DenyImpersonation, app.RequireScope(models.ScopeMediaWrite)).Delete("/{id}", app.DeleteMedia) // Delete a media item
+		})
 
 		// 	r.Get("/user/{userId}", app.UserMedia)            // List all media uploaded by a specific user
 		// 	r.Get("/category/{slug}", app.CategoryMedia)      // List media by category slug
 	})
 
+	// --- Moderation ---
+	mux.Route("/api/v1/moderation", func(r chi.Router) {
+		r.Use(app.AuthUser, app.RequireSession, app.DenyImpersonation, app.RequirePermission(models.PermMediaApprove))
+		r.Get("/queue", app.ModerationQueue)                        // Media waiting for review
+		r.Post("/approve", app.ApproveMedia)                        // Approve media in bulk
+		r.Post("/reject", app.RejectMedia)                          // Reject media in bulk with a reason
+		r.Get("/rejection-reasons", app.ListRejectionReasons)       // Managed list of rejection reasons
+		r.Post("/rejection-reasons", app.CreateRejectionReason)     // Add a rejection reason
+		r.Put("/rejection-reasons/{id}", app.UpdateRejectionReason) // Change or retire a rejection reason
+	})
+
 	// --- Categories Management ---
 	mux.Route("/api/v1/categories", func(r chi.Router) {
 		r.Get("/", app.GetMediaCategories) // List all categories
 		r.Group(func(r chi.Router) {
-			r.Use(app.AuthUser, app.AuthAdmin)
+			r.Use(app.AuthUser, app.RequireSession, app.RequirePermission(models.PermCategoryManage))
 			r.Post("/", app.CreateMediaCategory)   // Create a new category
 			r.Put("/", app.UpdateMediaCategory)    // Update an existing category
 			r.Delete("/", app.DeleteMediaCategory) // Delete a category
@@ -82,19 +145,82 @@ func (app *application) routes() http.Handler {
 		r.Get("/", app.GetPlans)
 
 		r.Group(func(r chi.Router) {
-			r.Use(app.AuthUser)
-			r.Post("/purchase", app.PurchasePlan)
+			r.Use(app.AuthUser, app.RequireSession)
+			r.With(app.DenyImpersonation).Post("/purchase", app.PurchasePlan)
 
 			r.Group(func(r chi.Router) {
-				r.Use(app.AuthAdmin)
+				r.Use(app.RequirePermission(models.PermPlanManage))
 				r.Post("/", app.CreatePlan)
 				r.Put("/", app.UpdatePlan)
 			})
 		})
 	})
 
+	// --- Tag Management ---
+	mux.Route("/api/v1/tags", func(r chi.Router) {
+		r.Use(app.AuthUser, app.RequireSession, app.DenyImpersonation, app.RequirePermission(models.PermTagManage))
+		r.Get("/", app.ListTags)            // List every tag including banned ones
+		r.Put("/{id}", app.RenameTag)       // Rename a tag
+		r.Post("/{id}/merge", app.MergeTag) // Merge a tag into another one
+		r.Put("/{id}/ban", app.BanTag)      // Ban or unban a tag
+	})
+
+	// --- Watermark Profiles ---
+	mux.Route("/api/v1/watermarks", func(r chi.Router) {
+		r.Use(app.AuthUser, app.RequireSession, app.DenyImpersonation, app.RequirePermission(models.PermWatermarkManage))
+		r.Get("/", app.ListWatermarkProfiles)                       // List watermark profiles
+		r.Post("/", app.CreateWatermarkProfile)                     // Create a watermark profile
+		r.Put("/{id}", app.UpdateWatermarkProfile)                  // Update the settings of a profile
+		r.Delete("/{id}", app.DeleteWatermarkProfile)               // Delete a profile
+		r.Put("/{id}/logo", app.UploadWatermarkLogo)                // Upload the PNG logo of a profile
+		r.Put("/{id}/default", app.SetDefaultWatermarkProfile)      // Make a profile the default one
+		r.Post("/{id}/regenerate", app.RegenerateWatermarks)        // Redraw the previews using a profile in the background
+		r.Get("/regeneration", app.WatermarkRegenerationStatus)     // Progress of the last regeneration
+		r.Put("/categories/{id}", app.AssignCategoryWatermark)      // Set or clear the profile of a category
+		r.Put("/contributors/{id}", app.AssignContributorWatermark) // Set or clear the profile of a contributor
+	})
+
+	// --- Roles & Permissions ---
+	mux.Route("/api/v1/roles", func(r chi.Router) {
+		r.Use(app.AuthUser, app.RequireSession, app.DenyImpersonation, app.RequirePermission(models.PermRolesManage))
+		r.Get("/", app.ListRoles)                  // List roles with their permissions
+		r.Post("/", app.CreateRole)                // Create a new role
+		r.Put("/", app.UpdateRole)                 // Update description and permissions of a role
+		r.Get("/permissions", app.ListPermissions) // List grantable permissions
+		r.Put("/assign", app.AssignRole)           // Assign a role to a user
+	})
+
+	// --- Administration ---
+	mux.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(app.AuthUser, app.RequireSession, app.DenyImpersonation)
+		r.Group(func(r chi.Router) {
+			r.Use(app.RequirePermission(models.PermUsersManage))
+			r.Post("/users/{id}/unlock", app.UnlockUser)                // Lift a sign-in lockout
+			r.Put("/users/{id}/status", app.SetUserStatus)              // Activate or deactivate an account
+			r.Delete("/users/{id}/sessions", app.TerminateUserSessions) // Sign a user out everywhere
+			r.Get("/audit-logs", app.ListAuditLogs)                     // Browse the security audit trail
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(app.RequirePermission(models.PermMediaApprove))
+			r.Get("/media/duplicates", app.ListDuplicateClusters) // Groups of duplicate and near-duplicate media
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(app.RequirePermission(models.PermUsersImpersonate))
+			r.Post("/users/{id}/impersonate", app.ImpersonateUser) // Act as a user for support (time-boxed)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(app.RequirePermission(models.PermDownloadsTrace))
+			r.Post("/downloads/trace", app.TraceDownload) // Find the download a leaked premium image came from
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(app.RequirePermission(models.PermJobsManage))
+			r.Get("/jobs", app.ListJobs)             // Background jobs and the number in each state
+			r.Post("/jobs/{id}/retry", app.RetryJob) // Retry a job that failed its last attempt
+		})
+	})
+
 	mux.Route("/api/v1/history", func(r chi.Router) {
-		r.Use(app.AuthUser)
+		r.Use(app.AuthUser, app.RequireScope(models.ScopeHistoryRead))
 		r.Get("/download", app.GetDownloadHistory)
 		r.Get("/upload", app.GetUploadHistory)
 	})
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ListSessions returns the devices the logged-in user is signed in on
+func (app *application) ListSessions(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error    bool              `json:"error"`
+		Message  string            `json:"message"`
+		Sessions []*models.Session `json:"sessions"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	sessions, err := app.DB.SessionRepo.GetActiveByUserID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: ListSessions =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	for _, s := range sessions {
+		s.Current = s.ID == token.SessionID
+	}
+
+	Resp.Error = false
+	Resp.Message = "Sessions fetched successfully"
+	Resp.Sessions = sessions
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RevokeSession signs one of the user's sessions out. Its tokens stop working immediately.
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	revoked, err := app.DB.SessionRepo.Revoke(r.Context(), chi.URLParam(r, "id"), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: RevokeSession =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if !revoked {
+		Resp.Error = true
+		Resp.Message = "Session not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Session signed out"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// RevokeAllSessions signs the user out everywhere, including the current session
+func (app *application) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), token.ID); err != nil {
+		app.errorLog.Println("ERROR: RevokeAllSessions =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Signed out everywhere"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// SetUserStatus activates or deactivates a user account.
+// Deactivation signs the user out of every session.
+func (app *application) SetUserStatus(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Status bool `json:"status"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	if _, err := app.DB.UserRepo.GetByID(r.Context(), id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			Resp.Error = true
+			Resp.Message = "User not found"
+			app.writeJSON(w, http.StatusNotFound, Resp)
+			return
+		}
+		app.errorLog.Println("ERROR: SetUserStatus =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if err := app.DB.UserRepo.Deactivate(r.Context(), id, payload.Status); err != nil {
+		app.errorLog.Println("ERROR: SetUserStatus =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if !payload.Status {
+		if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), id); err != nil {
+			app.errorLog.Println("ERROR: SetUserStatus => unable to revoke sessions:", err)
+			Resp.Error = true
+			Resp.Message = "Account deactivated but sessions could not be signed out"
+			app.writeJSON(w, http.StatusInternalServerError, Resp)
+			return
+		}
+	}
+
+	action := "user.activate"
+	Resp.Message = "Account activated"
+	if !payload.Status {
+		action = "user.deactivate"
+		Resp.Message = "Account deactivated and signed out everywhere"
+	}
+	app.audit(r, action, "user", strconv.Itoa(id), nil)
+
+	Resp.Error = false
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// TerminateUserSessions signs a user out of every session without deactivating the account
+func (app *application) TerminateUserSessions(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+
+	if err := app.DB.SessionRepo.RevokeAllByUserID(r.Context(), id); err != nil {
+		app.errorLog.Println("ERROR: TerminateUserSessions =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.audit(r, "user.sessions.terminate", "user", strconv.Itoa(id), nil)
+
+	Resp.Error = false
+	Resp.Message = "User signed out everywhere"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// PopularTags returns the most used tags, ?limit= defaults to 50 (at most 200)
+func (app *application) PopularTags(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Tags    []*models.Tag `json:"tags"`
+	}
+
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 200)
+	}
+	tags, err := app.DB.TagRepo.GetPopular(r.Context(), limit)
+	if err != nil {
+		app.errorLog.Println("ERROR: PopularTags =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Tags fetched successfully"
+	Resp.Tags = tags
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// MediaByTag lists the media of a tag. It accepts the same parameters as ListMedia.
+func (app *application) MediaByTag(w http.ResponseWriter, r *http.Request) {
+	_, slug := utils.NormalizeTag(chi.URLParam(r, "slug"))
+	tag, err := app.DB.TagRepo.GetBySlug(r.Context(), slug)
+	if err != nil || tag.Banned {
+		if err != nil && !errors.Is(err, repositories.ErrTagNotFound) {
+			app.errorLog.Println("ERROR: MediaByTag =>", err)
+		}
+		app.writeJSON(w, http.StatusNotFound, models.Response{
+			Error:   true,
+			Message: "Tag not found",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("tag", tag.Slug)
+	r.URL.RawQuery = query.Encode()
+	app.ListMedia(w, r)
+}
+
+// ListTags returns every tag including banned ones
+func (app *application) ListTags(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Tags    []*models.Tag `json:"tags"`
+	}
+
+	tags, err := app.DB.TagRepo.GetAll(r.Context())
+	if err != nil {
+		app.errorLog.Println("ERROR: ListTags =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Tags fetched successfully"
+	Resp.Tags = tags
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// writeTagResult writes the response of a tag management action
+func (app *application) writeTagResult(w http.ResponseWriter, tag *models.Tag, err error, action, message string) {
+	var Resp struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Tag     *models.Tag `json:"tag,omitempty"`
+	}
+	switch {
+	case err == nil:
+		Resp.Message = message
+		Resp.Tag = tag
+		app.writeJSON(w, http.StatusOK, Resp)
+	case errors.Is(err, repositories.ErrTagNotFound):
+		Resp.Error = true
+		Resp.Message = "Tag not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+	case errors.Is(err, repositories.ErrTagExists):
+		Resp.Error = true
+		Resp.Message = "A tag with this name already exists, merge the tags instead"
+		app.writeJSON(w, http.StatusConflict, Resp)
+	default:
+		app.errorLog.Printf("ERROR: %s => %v", action, err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+	}
+}
+
+// RenameTag changes the name of a tag
+func (app *application) RenameTag(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name string `json:"name"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	if _, slug := utils.NormalizeTag(payload.Name); slug == "" {
+		app.writeJSON(w, http.StatusBadRequest, models.Response{
+			Error:   true,
+			Message: "Tag name must contain letters or digits",
+		})
+		return
+	}
+
+	tag, err := app.DB.TagRepo.Rename(r.Context(), id, payload.Name)
+	if err == nil {
+		app.audit(r, "tag.rename", "tag", strconv.Itoa(id), map[string]any{"name": tag.Name})
+	}
+	app.writeTagResult(w, tag, err, "RenameTag", "Tag renamed")
+}
+
+// MergeTag moves the media of a tag to another tag and deletes it
+func (app *application) MergeTag(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		IntoID int `json:"into_id"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	if payload.IntoID == id {
+		app.writeJSON(w, http.StatusBadRequest, models.Response{
+			Error:   true,
+			Message: "A tag cannot be merged into itself",
+		})
+		return
+	}
+
+	tag, err := app.DB.TagRepo.Merge(r.Context(), id, payload.IntoID)
+	if err == nil {
+		app.audit(r, "tag.merge", "tag", strconv.Itoa(id), map[string]any{"into_id": payload.IntoID})
+	}
+	app.writeTagResult(w, tag, err, "MergeTag", "Tags merged")
+}
+
+// BanTag bans or unbans a tag. A banned tag is removed from all media and can't be used again.
+func (app *application) BanTag(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Banned bool `json:"banned"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	tag, err := app.DB.TagRepo.SetBanned(r.Context(), id, payload.Banned)
+	message := "Tag unbanned"
+	if payload.Banned {
+		message = "Tag banned and removed from all media"
+	}
+	if err == nil {
+		app.audit(r, "tag.ban", "tag", strconv.Itoa(id), map[string]any{"banned": payload.Banned})
+	}
+	app.writeTagResult(w, tag, err, "BanTag", message)
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// Defaults of image transformations
+const (
+	defaultTransformQuality = 80
+	transformCacheMaxAge    = 24 * time.Hour
+)
+
+// transformQualities are the JPEG qualities allowed, few so the number of variants of an image stays bounded
+var transformQualities = []int{60, defaultTransformQuality, 95}
+
+// transformFormats maps the fmt parameter to the encoder and content type of the output
+var transformFormats = map[string]struct {
+	format      imaging.Format
+	contentType string
+	ext         string
+}{
+	"jpeg": {imaging.JPEG, "image/jpeg", ".jpg"},
+	"png":  {imaging.PNG, "image/png", ".png"},
+}
+
+// imageTransform is a requested resize and re-encode of a media image
+type imageTransform struct {
+	Width   int    // 0 to follow the aspect ratio
+	Height  int    // 0 to follow the aspect ratio
+	Fit     string // utils.FitContain or utils.FitCover
+	Format  string // key of transformFormats
+	Quality int    // JPEG quality, one of transformQualities
+}
+
+// parseImageTransform reads w, h, fit, fmt and q from the query string. Widths, heights and
+// qualities must be in their allow-lists so the number of variants of an image stays bounded.
+func (app *application) parseImageTransform(query url.Values) (imageTransform, error) {
+	t := imageTransform{Fit: utils.FitContain, Format: "jpeg", Quality: defaultTransformQuality}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"w", &t.Width}, {"h", &t.Height}} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(app.config.transform.sizes, n) {
+			return t, fmt.Errorf("%s must be one of %v", p.name, app.config.transform.sizes)
+		}
+		*p.value = n
+	}
+	if t.Width == 0 && t.Height == 0 {
+		return t, errors.New("w or h is required")
+	}
+	if v := query.Get("fit"); v != "" {
+		if v != utils.FitContain && v != utils.FitCover {
+			return t, errors.New("fit must be contain or cover")
+		}
+		t.Fit = v
+	}
+	if v := query.Get("fmt"); v != "" {
+		if _, ok := transformFormats[v]; !ok {
+			return t, errors.New("fmt must be jpeg or png")
+		}
+		t.Format = v
+	}
+	if v := query.Get("q"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(transformQualities, q) {
+			return t, fmt.Errorf("q must be one of %v", transformQualities)
+		}
+		t.Quality = q
+	}
+	// Quality only applies to JPEG, keep one variant of other formats
+	if t.Format != "jpeg" {
+		t.Quality = 0
+	}
+	return t, nil
+}
+
+// canonical returns the transformation of a media image in a stable form, used for signing and caching
+func (t imageTransform) canonical(uuid string) string {
+	return fmt.Sprintf("%s?w=%d&h=%d&fit=%s&fmt=%s&q=%d", uuid, t.Width, t.Height, t.Fit, t.Format, t.Quality)
+}
+
+func (app *application) signTransform(uuid string, t imageTransform) string {
+	mac := hmac.New(sha256.New, []byte(app.config.transform.secret))
+	mac.Write([]byte("image-transform:" + t.canonical(uuid)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// transformURL returns the signed URL of a transformed media image
+func (app *application) transformURL(uuid string, t imageTransform) string {
+	query := url.Values{}
+	if t.Width > 0 {
+		query.Set("w", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		query.Set("h", strconv.Itoa(t.Height))
+	}
+	query.Set("fit", t.Fit)
+	query.Set("fmt", t.Format)
+	if t.Quality > 0 {
+		query.Set("q", strconv.Itoa(t.Quality))
+	}
+	query.Set("s", app.signTransform(uuid, t))
+	baseURL, _ := url.Parse(models.APIEndPoint)
+	baseURL = baseURL.JoinPath("public", "img", uuid)
+	baseURL.RawQuery = query.Encode()
+	return baseURL.String()
+}
+
+// transformSource returns the image transformations are made from. Premium media only
+// expose their watermarked copy, free media their original.
+func transformSource(media *models.Media) (path string, maxEdge int) {
+	if media.LicenseType == 0 {
+		return mediaOriginalPath(media.MediaUUID, media.LicenseType), max(media.Width, media.Height)
+	}
+	return filepath.Join(".", "assets", "images", "public", "watermarked", "wm_"+media.MediaUUID), utils.WatermarkMaxSize
+}
+
+// mediaPreviews returns signed preview URLs of a media item at each allowed width up to
+// the size of its transformation source
+func (app *application) mediaPreviews(media *models.Media) []*models.ImagePreview {
+	_, maxEdge := transformSource(media)
+	var previews []*models.ImagePreview
+	for i, width := range app.config.transform.sizes {
+		if i > 0 && width > maxEdge {
+			break
+		}
+		t := imageTransform{Width: width, Fit: utils.FitContain, Format: "jpeg", Quality: defaultTransformQuality}
+		previews = append(previews, &models.ImagePreview{Width: width, URL: app.transformURL(media.MediaUUID, t)})
+	}
+	return previews
+}
+
+// TransformImage serves a resized, cropped or re-encoded copy of an approved media image.
+// URLs must carry the signature issued with the media listings, so clients can't make the
+// server render arbitrary variants. Results are kept in a size-bounded LRU disk cache.
+func (app *application) TransformImage(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	uuid := chi.URLParam(r, "uuid")
+	t, err := app.parseImageTransform(r.URL.Query())
+	if err != nil {
+		app.badRequest(w, err)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("s")), []byte(app.signTransform(uuid, t))) {
+		Resp.Error = true
+		Resp.Message = "Invalid image signature"
+		app.writeJSON(w, http.StatusForbidden, Resp)
+		return
+	}
+
+	media, err := app.DB.MediaRepo.GetByMediaUUID(r.Context(), uuid)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		app.errorLog.Println("ERROR: TransformImage =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err != nil || media.Status != models.MediaStatusApproved {
+		Resp.Error = true
+		Resp.Message = "Image not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+
+	out := transformFormats[t.Format]
+	source, _ := transformSource(media)
+	info, err := os.Stat(source)
+	if err != nil {
+		app.errorLog.Println("ERROR: TransformImage => missing source:", err)
+		Resp.Error = true
+		Resp.Message = "Image not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+		return
+	}
+	// The source changes with the license and when its copy is regenerated
+	key := fmt.Sprintf("%s&src=%s&v=%d", t.canonical(uuid), licenseDir(media.LicenseType), info.ModTime().UnixNano())
+	setHeaders := func(cache string) {
+		w.Header().Set("Content-Type", out.contentType)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(transformCacheMaxAge.Seconds())))
+		w.Header().Set("X-Cache", cache)
+	}
+
+	if f, ok := app.Transforms.Open(key, out.ext); ok {
+		defer f.Close()
+		setHeaders("HIT")
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+
+	// Bound the number of images decoded at once
+	if err := app.acquireImageSlot(r.Context()); err != nil {
+		return
+	}
+	defer func() { <-app.transformSlots }()
+
+	img, err := imaging.Open(source, imaging.AutoOrientation(true))
+	if err != nil {
+		app.errorLog.Println("ERROR: TransformImage => unable to open source:", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	var buf bytes.Buffer
+	var opts []imaging.EncodeOption
+	if t.Quality > 0 {
+		opts = append(opts, imaging.JPEGQuality(t.Quality))
+	}
+	if err := imaging.Encode(&buf, utils.TransformImage(img, t.Width, t.Height, t.Fit), out.format, opts...); err != nil {
+		app.errorLog.Println("ERROR: TransformImage => unable to encode:", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if err := app.Transforms.Put(key, out.ext, buf.Bytes()); err != nil {
+		app.errorLog.Println("ERROR: TransformImage => unable to cache:", err)
+	}
+
+	setHeaders("MISS")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/samiulice/photostock/internal/mailer"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// emailVerificationTTL is how long an email verification link stays valid
+const emailVerificationTTL = 48 * time.Hour
+
+var errInvalidVerificationToken = errors.New("invalid or expired verification link")
+
+// emailVerificationClaims is the signed payload of an email verification token
+type emailVerificationClaims struct {
+	UserID    int    `json:"uid"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// signEmailVerificationToken returns a stateless token of the form payload.signature
+// binding the user to the address the link was sent to
+func (app *application) signEmailVerificationToken(user *models.User) (string, error) {
+	payload, err := json.Marshal(emailVerificationClaims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + app.signVerificationPayload(encoded), nil
+}
+
+// parseEmailVerificationToken validates the signature and expiry of a verification token
+func (app *application) parseEmailVerificationToken(token string) (*emailVerificationClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidVerificationToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(app.signVerificationPayload(encoded))) {
+		return nil, errInvalidVerificationToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidVerificationToken
+	}
+	var claims emailVerificationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errInvalidVerificationToken
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, errInvalidVerificationToken
+	}
+	return &claims, nil
+}
+
+func (app *application) signVerificationPayload(encoded string) string {
+	mac := hmac.New(sha256.New, []byte(app.config.auth.verificationSecret))
+	mac.Write([]byte("email-verification:" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sendVerificationEmail emails a verification link to the user's current address
+func (app *application) sendVerificationEmail(user *models.User) error {
+	token, err := app.signEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimRight(app.config.frontendURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+	app.sendMail(mailer.Message{
+		To:      []string{user.Email},
+		Subject: models.APPName + ": confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that %s is your email address by opening the link below. The link expires in %s.\n\n%s\n",
+			user.Name, user.Email, emailVerificationTTL, link),
+	})
+	return nil
+}
+
+// emailVerificationRequired reports whether the policy blocks the user until the email is verified
+func (app *application) emailVerificationRequired(user *models.User) bool {
+	return app.config.auth.requireVerifiedEmail && user.EmailVerifiedAt == nil
+}
+
+// VerifyEmail confirms the user's email address using the token from the verification link
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	claims, err := app.parseEmailVerificationToken(strings.TrimSpace(payload.Token))
+	if err != nil {
+		Resp.Error = true
+		Resp.Message = "Invalid or expired verification link"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	ok, err := app.DB.UserRepo.MarkEmailVerified(r.Context(), claims.UserID, claims.Email)
+	if err != nil {
+		app.errorLog.Println("ERROR: VerifyEmail => unable to update user:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "This link is no longer valid for your account email"
+		app.writeJSON(w, http.StatusBadRequest, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Email verified successfully"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// ResendVerification sends a new verification link to the logged-in user
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+
+	token, ok := app.GetUserTokenFromContext(r.Context())
+	if !ok {
+		Resp.Error = true
+		Resp.Message = "Access Denied"
+		app.writeJSON(w, http.StatusUnauthorized, Resp)
+		return
+	}
+
+	user, err := app.DB.UserRepo.GetByID(r.Context(), token.ID)
+	if err != nil {
+		app.errorLog.Println("ERROR: ResendVerification => user lookup failed:", err)
+		Resp.Error = true
+		Resp.Message = "Could not load user"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		Resp.Error = false
+		Resp.Message = "Email already verified"
+		app.writeJSON(w, http.StatusOK, Resp)
+		return
+	}
+
+	if err := app.sendVerificationEmail(user); err != nil {
+		app.errorLog.Println("ERROR: ResendVerification => unable to sign token:", err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Verification email sent"
+	app.writeJSON(w, http.StatusOK, Resp)
+}
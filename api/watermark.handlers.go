@@ -0,0 +1,487 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// Limits of an uploaded watermark logo: its file size and the width and height it declares.
+// A small compressed file can declare a canvas that takes gigabytes to decode.
+const (
+	maxWatermarkLogoSize = 2 << 20
+	maxWatermarkLogoEdge = 4096
+)
+
+var watermarkPlacements = []string{
+	utils.PlacementTile, utils.PlacementCenter, utils.PlacementTopLeft,
+	utils.PlacementTopRight, utils.PlacementBottomLeft, utils.PlacementBottomRight,
+}
+
+var errRegenerationRunning = errors.New("a watermark regeneration is already running")
+
+// watermarkRegenerator redraws watermarked previews in the background, one profile at a time.
+// Progress is kept in memory, so it is lost on restart and not shared between API instances.
+type watermarkRegenerator struct {
+	mu      sync.Mutex
+	current *models.WatermarkRegeneration
+}
+
+// status returns a copy of the progress of the last regeneration, nil if none ran
+func (g *watermarkRegenerator) status() *models.WatermarkRegeneration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.current == nil {
+		return nil
+	}
+	c := *g.current
+	return &c
+}
+
+// watermarkFor returns how a profile draws watermarks. Logo profiles without an uploaded
+// logo draw their text.
+func watermarkFor(p *models.WatermarkProfile) (*utils.Watermark, error) {
+	wm := &utils.Watermark{
+		Text:      p.Text,
+		Placement: p.Placement,
+		Scale:     p.Scale,
+		Opacity:   p.Opacity,
+		Color:     p.Color,
+	}
+	if wm.Text == "" {
+		wm.Text = utils.WatermarkText
+	}
+	if p.Kind == models.WatermarkKindLogo && p.LogoPath != "" {
+		logo, err := imaging.Open(p.LogoPath)
+		if err != nil {
+			return nil, fmt.Errorf("open watermark logo: %w", err)
+		}
+		wm.Logo = logo
+	}
+	return wm, nil
+}
+
+// resolveWatermark returns the watermark for new media of a contributor in a category.
+// It falls back to utils.DefaultWatermark when no profile applies or it can't be loaded.
+func (app *application) resolveWatermark(ctx context.Context, uploaderID, categoryID int) *utils.Watermark {
+	profile, err := app.DB.WatermarkRepo.Resolve(ctx, uploaderID, categoryID)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrWatermarkProfileNotFound) {
+			app.errorLog.Println("ERROR: resolveWatermark =>", err)
+		}
+		return nil
+	}
+	wm, err := watermarkFor(profile)
+	if err != nil {
+		app.errorLog.Println("ERROR: resolveWatermark =>", err)
+		return nil
+	}
+	return wm
+}
+
+// startWatermarkRegeneration redraws in the background the watermarked previews of every
+// media drawn with a profile
+func (app *application) startWatermarkRegeneration(ctx context.Context, profile *models.WatermarkProfile) (*models.WatermarkRegeneration, error) {
+	g := &app.watermarks
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.current != nil && g.current.Running {
+		return nil, errRegenerationRunning
+	}
+
+	wm, err := watermarkFor(profile)
+	if err != nil {
+		return nil, err
+	}
+	medias, err := app.DB.WatermarkRepo.MediaUsing(ctx, profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	run := &models.WatermarkRegeneration{
+		ProfileID: profile.ID,
+		Total:     len(medias),
+		Running:   true,
+		StartedAt: time.Now(),
+	}
+	g.current = run
+
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		for _, m := range medias {
+			if app.ctx.Err() != nil {
+				break
+			}
+			wmPath := filepath.Join(".", "assets", "images", "public", "watermarked", "wm_"+m.MediaUUID)
+			err := utils.RegenerateWatermarked(mediaOriginalPath(m.MediaUUID, m.LicenseType), wmPath, wm)
+			if err != nil {
+				app.errorLog.Printf("ERROR: watermark regeneration => media %d: %v", m.ID, err)
+			}
+			g.mu.Lock()
+			if err != nil {
+				run.Failed++
+			} else {
+				run.Done++
+			}
+			g.mu.Unlock()
+		}
+		g.mu.Lock()
+		now := time.Now()
+		run.Running = false
+		run.FinishedAt = &now
+		g.mu.Unlock()
+		app.infoLog.Printf("Watermark regeneration of profile %d finished: %d done, %d failed", run.ProfileID, run.Done, run.Failed)
+	}()
+
+	c := *run
+	return &c, nil
+}
+
+// validateWatermarkProfile checks and normalizes the settings of a watermark profile
+func validateWatermarkProfile(p *models.WatermarkProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Text = strings.TrimSpace(p.Text)
+	if p.Kind == "" {
+		p.Kind = models.WatermarkKindText
+	}
+	if p.Placement == "" {
+		p.Placement = utils.PlacementTile
+	}
+	if p.Color == "" {
+		p.Color = utils.ColorAuto
+	}
+	switch {
+	case p.Name == "":
+		return errors.New("name is required")
+	case p.Kind != models.WatermarkKindText && p.Kind != models.WatermarkKindLogo:
+		return errors.New("kind must be text or logo")
+	case p.Kind == models.WatermarkKindText && p.Text == "":
+		return errors.New("text is required for text watermarks")
+	case len(p.Text) > 100:
+		return errors.New("text must be at most 100 characters")
+	case !slices.Contains(watermarkPlacements, p.Placement):
+		return fmt.Errorf("placement must be one of %s", strings.Join(watermarkPlacements, ", "))
+	case p.Scale <= 0 || p.Scale > 1:
+		return errors.New("scale must be greater than 0 and at most 1")
+	case p.Opacity <= 0 || p.Opacity > 1:
+		return errors.New("opacity must be greater than 0 and at most 1")
+	case p.Color != utils.ColorAuto && p.Color != utils.ColorLight && p.Color != utils.ColorDark:
+		return errors.New("color must be auto, light or dark")
+	}
+	return nil
+}
+
+// writeWatermarkResult writes the response of a watermark profile management action
+func (app *application) writeWatermarkResult(w http.ResponseWriter, profile *models.WatermarkProfile, err error, action, message string) {
+	var Resp struct {
+		Error   bool                     `json:"error"`
+		Message string                   `json:"message"`
+		Profile *models.WatermarkProfile `json:"profile,omitempty"`
+	}
+	switch {
+	case err == nil:
+		Resp.Message = message
+		Resp.Profile = profile
+		app.writeJSON(w, http.StatusOK, Resp)
+	case errors.Is(err, repositories.ErrWatermarkProfileNotFound):
+		Resp.Error = true
+		Resp.Message = "Watermark profile not found"
+		app.writeJSON(w, http.StatusNotFound, Resp)
+	case errors.Is(err, repositories.ErrWatermarkProfileExists):
+		Resp.Error = true
+		Resp.Message = "A watermark profile with this name already exists"
+		app.writeJSON(w, http.StatusConflict, Resp)
+	case errors.Is(err, repositories.ErrWatermarkProfileDefault):
+		Resp.Error = true
+		Resp.Message = "The default watermark profile can't be deleted, make another profile the default first"
+		app.writeJSON(w, http.StatusConflict, Resp)
+	default:
+		app.errorLog.Printf("ERROR: %s => %v", action, err)
+		Resp.Error = true
+		Resp.Message = "Internal server error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+	}
+}
+
+// ListWatermarkProfiles returns every watermark profile
+func (app *application) ListWatermarkProfiles(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error    bool                       `json:"error"`
+		Message  string                     `json:"message"`
+		Profiles []*models.WatermarkProfile `json:"profiles"`
+	}
+
+	profiles, err := app.DB.WatermarkRepo.GetAll(r.Context())
+	if err != nil {
+		app.errorLog.Println("ERROR: ListWatermarkProfiles =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+
+	Resp.Error = false
+	Resp.Message = "Watermark profiles fetched successfully"
+	Resp.Profiles = profiles
+	app.writeJSON(w, http.StatusOK, Resp)
+}
+
+// CreateWatermarkProfile adds a watermark profile. Logos are uploaded separately.
+func (app *application) CreateWatermarkProfile(w http.ResponseWriter, r *http.Request) {
+	var p models.WatermarkProfile
+	if err := app.readJSON(w, r, &p); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	if err := validateWatermarkProfile(&p); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+
+	profile, err := app.DB.WatermarkRepo.Create(r.Context(), &p)
+	if err == nil {
+		app.audit(r, "watermark.create", "watermark_profile", strconv.Itoa(profile.ID), map[string]any{"name": profile.Name})
+	}
+	app.writeWatermarkResult(w, profile, err, "CreateWatermarkProfile", "Watermark profile created")
+}
+
+// UpdateWatermarkProfile changes the settings of a watermark profile. Existing previews keep
+// their watermark until they are regenerated.
+func (app *application) UpdateWatermarkProfile(w http.ResponseWriter, r *http.Request) {
+	var p models.WatermarkProfile
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &p); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+	if err := validateWatermarkProfile(&p); err != nil {
+		app.badRequest(w, err)
+		return
+	}
+	p.ID = id
+
+	profile, err := app.DB.WatermarkRepo.Update(r.Context(), &p)
+	if err == nil {
+		app.audit(r, "watermark.update", "watermark_profile", strconv.Itoa(id), map[string]any{"name": profile.Name})
+	}
+	app.writeWatermarkResult(w, profile, err, "UpdateWatermarkProfile", "Watermark profile updated, regenerate the previews to apply it")
+}
+
+// UploadWatermarkLogo stores the PNG logo of a watermark profile from the "logo" form file
+func (app *application) UploadWatermarkLogo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkLogoSize+1<<10)
+	if err := r.ParseMultipartForm(maxWatermarkLogoSize); err != nil {
+		app.badRequest(w, errors.New("the logo must be a PNG file of at most 2 MB"))
+		return
+	}
+	file, _, err := r.FormFile("logo")
+	if err != nil {
+		app.badRequest(w, errors.New("logo file required"))
+		return
+	}
+	defer file.Close()
+	cfg, err := png.DecodeConfig(file)
+	if err != nil {
+		app.badRequest(w, errors.New("the logo must be a PNG file of at most 2 MB"))
+		return
+	}
+	if cfg.Width > maxWatermarkLogoEdge || cfg.Height > maxWatermarkLogoEdge {
+		app.badRequest(w, fmt.Errorf("the logo must be at most %dx%d pixels", maxWatermarkLogoEdge, maxWatermarkLogoEdge))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		app.errorLog.Println("ERROR: UploadWatermarkLogo =>", err)
+		app.writeJSON(w, http.StatusInternalServerError, models.Response{Error: true, Message: "Internal Server Error"})
+		return
+	}
+	logo, err := png.Decode(file)
+	if err != nil {
+		app.badRequest(w, errors.New("the logo must be a PNG file of at most 2 MB"))
+		return
+	}
+
+	dir := filepath.Join(".", "assets", "watermarks")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		app.writeWatermarkResult(w, nil, err, "UploadWatermarkLogo", "")
+		return
+	}
+	// A new name per upload, so previews being regenerated keep reading the old logo
+	path := filepath.Join(dir, fmt.Sprintf("profile_%d_%d.png", id, time.Now().UnixNano()))
+	if err := imaging.Save(image.Image(logo), path); err != nil {
+		app.writeWatermarkResult(w, nil, err, "UploadWatermarkLogo", "")
+		return
+	}
+
+	profile, previous, err := app.DB.WatermarkRepo.SetLogo(r.Context(), id, path)
+	if err != nil {
+		os.Remove(path)
+		app.writeWatermarkResult(w, nil, err, "UploadWatermarkLogo", "")
+		return
+	}
+	if previous != "" {
+		os.Remove(previous)
+	}
+	app.audit(r, "watermark.logo", "watermark_profile", strconv.Itoa(id), nil)
+	app.writeWatermarkResult(w, profile, nil, "UploadWatermarkLogo", "Watermark logo uploaded, regenerate the previews to apply it")
+}
+
+// SetDefaultWatermarkProfile makes a profile the one used when a media's contributor and
+// category have none
+func (app *application) SetDefaultWatermarkProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+
+	profile, err := app.DB.WatermarkRepo.SetDefault(r.Context(), id)
+	if err == nil {
+		app.audit(r, "watermark.default", "watermark_profile", strconv.Itoa(id), nil)
+	}
+	app.writeWatermarkResult(w, profile, err, "SetDefaultWatermarkProfile", "Default watermark profile changed, regenerate the previews to apply it")
+}
+
+// DeleteWatermarkProfile removes a watermark profile. Its contributors and categories fall
+// back to the default profile.
+func (app *application) DeleteWatermarkProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+
+	logoPath, err := app.DB.WatermarkRepo.Delete(r.Context(), id)
+	if err == nil {
+		if logoPath != "" {
+			os.Remove(logoPath)
+		}
+		app.audit(r, "watermark.delete", "watermark_profile", strconv.Itoa(id), nil)
+	}
+	app.writeWatermarkResult(w, nil, err, "DeleteWatermarkProfile", "Watermark profile deleted")
+}
+
+// assignWatermark sets the watermark profile of a category or contributor from {"profile_id": n},
+// null clears it
+func (app *application) assignWatermark(w http.ResponseWriter, r *http.Request, target string,
+	assign func(ctx context.Context, id int, profileID *int) error) {
+	var payload struct {
+		ProfileID *int `json:"profile_id"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, fmt.Errorf("ERROR: unable to read json %w", err))
+		return
+	}
+
+	err = assign(r.Context(), id, payload.ProfileID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		app.writeJSON(w, http.StatusNotFound, models.Response{
+			Error:   true,
+			Message: strings.ToUpper(target[:1]) + target[1:] + " not found",
+		})
+		return
+	}
+	if err == nil {
+		app.audit(r, "watermark.assign", target, strconv.Itoa(id), map[string]any{"profile_id": payload.ProfileID})
+	}
+	app.writeWatermarkResult(w, nil, err, "AssignWatermark", "Watermark profile assigned, it applies to new uploads and regenerated previews")
+}
+
+// AssignCategoryWatermark sets the watermark profile of a media category
+func (app *application) AssignCategoryWatermark(w http.ResponseWriter, r *http.Request) {
+	app.assignWatermark(w, r, "category", app.DB.WatermarkRepo.AssignCategory)
+}
+
+// AssignContributorWatermark sets the watermark profile of a contributor, it wins over the category's
+func (app *application) AssignContributorWatermark(w http.ResponseWriter, r *http.Request) {
+	app.assignWatermark(w, r, "contributor", app.DB.WatermarkRepo.AssignContributor)
+}
+
+// RegenerateWatermarks starts redrawing in the background the watermarked previews of every
+// media drawn with a profile. Only one regeneration runs at a time.
+func (app *application) RegenerateWatermarks(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error        bool                          `json:"error"`
+		Message      string                        `json:"message"`
+		Regeneration *models.WatermarkRegeneration `json:"regeneration,omitempty"`
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequest(w, fmt.Errorf("Invalid id: %w", err))
+		return
+	}
+	profile, err := app.DB.WatermarkRepo.GetByID(r.Context(), id)
+	if err != nil {
+		app.writeWatermarkResult(w, nil, err, "RegenerateWatermarks", "")
+		return
+	}
+
+	run, err := app.startWatermarkRegeneration(r.Context(), profile)
+	if errors.Is(err, errRegenerationRunning) {
+		Resp.Error = true
+		Resp.Message = "A watermark regeneration is already running"
+		Resp.Regeneration = app.watermarks.status()
+		app.writeJSON(w, http.StatusConflict, Resp)
+		return
+	}
+	if err != nil {
+		app.errorLog.Println("ERROR: RegenerateWatermarks =>", err)
+		Resp.Error = true
+		Resp.Message = "Internal Server Error"
+		app.writeJSON(w, http.StatusInternalServerError, Resp)
+		return
+	}
+	app.audit(r, "watermark.regenerate", "watermark_profile", strconv.Itoa(id), map[string]any{"media": run.Total})
+
+	Resp.Error = false
+	Resp.Message = "Watermark regeneration started"
+	Resp.Regeneration = run
+	app.writeJSON(w, http.StatusAccepted, Resp)
+}
+
+// WatermarkRegenerationStatus returns the progress of the last watermark regeneration
+func (app *application) WatermarkRegenerationStatus(w http.ResponseWriter, r *http.Request) {
+	var Resp struct {
+		Error        bool                          `json:"error"`
+		Message      string                        `json:"message"`
+		Regeneration *models.WatermarkRegeneration `json:"regeneration"`
+	}
+
+	Resp.Error = false
+	Resp.Message = "No watermark regeneration has run"
+	if Resp.Regeneration = app.watermarks.status(); Resp.Regeneration != nil {
+		Resp.Message = "Watermark regeneration status fetched successfully"
+	}
+	app.writeJSON(w, http.StatusOK, Resp)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+// Disk is a least-recently-used file cache bounded by the total size of its files.
+// Files are named after a hash of their key. Entries left in the directory by an earlier
+// run are picked up on start, ordered by modification time. State is not shared between
+// API instances.
+type Disk struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+}
+
+// NewDisk opens the cache directory dir, creating it when needed, and evicts entries
+// until the cache holds at most maxBytes.
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	c := &Disk{dir: dir, maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type existing struct {
+		diskEntry
+		modTime time.Time
+	}
+	var found []existing
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// Leftovers of writes interrupted by a restart
+		if strings.HasPrefix(f.Name(), ".tmp-") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		found = append(found, existing{diskEntry{f.Name(), info.Size()}, info.ModTime()})
+	}
+	slices.SortFunc(found, func(a, b existing) int { return b.modTime.Compare(a.modTime) })
+	for _, e := range found {
+		c.entries[e.name] = c.order.PushBack(&diskEntry{e.name, e.size})
+		c.size += e.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// fileName returns the name of the file holding key
+func fileName(key, ext string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+// Open returns the cached file of key, opened for reading, and marks it recently used.
+// The file stays readable when it is evicted while open. The caller closes it.
+func (c *Disk) Open(key, ext string) (*os.File, bool) {
+	name := fileName(key, ext)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	path := filepath.Join(c.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		// Removed behind the cache's back
+		c.order.Remove(el)
+		delete(c.entries, name)
+		c.size -= el.Value.(*diskEntry).size
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	// Keep the modification time in step so the order survives a restart
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return f, true
+}
+
+// Put stores data as the file of key, evicting the least recently used files when the
+// cache grows beyond its size. Files larger than the whole cache are not stored.
+func (c *Disk) Put(key, ext string, data []byte) error {
+	name := fileName(key, ext)
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		return err
+	}
+	if el, ok := c.entries[name]; ok {
+		e := el.Value.(*diskEntry)
+		c.size -= e.size
+		e.size = size
+		c.order.MoveToFront(el)
+	} else {
+		c.entries[name] = c.order.PushFront(&diskEntry{name, size})
+	}
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits. c.mu must be held.
+func (c *Disk) evict() {
+	for c.size > c.maxBytes && c.order.Len() > 0 {
+		el := c.order.Back()
+		e := el.Value.(*diskEntry)
+		c.order.Remove(el)
+		delete(c.entries, e.name)
+		c.size -= e.size
+		os.Remove(filepath.Join(c.dir, e.name))
+	}
+}
+
+// Size returns the total size in bytes of the cached files
+func (c *Disk) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
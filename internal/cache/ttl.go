@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// TTL is an in-process cache whose entries expire after a fixed duration.
+// It holds at most maxEntries entries; when full, expired entries are dropped
+// and if it is still full the whole cache is cleared. State is not shared
+// between API instances.
+type TTL[V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]entry[V]
+}
+
+// NewTTL returns an empty cache. A ttl of 0 disables caching.
+func NewTTL[V any](ttl time.Duration, maxEntries int) *TTL[V] {
+	return &TTL[V]{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]entry[V])}
+}
+
+// Get returns the value of key if it is present and not expired
+func (c *TTL[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Set stores value under key
+func (c *TTL[V]) Set(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = entry[V]{value: value, expiresAt: now.Add(c.ttl)}
+}
@@ -0,0 +1,232 @@
+// Package fingerprint hides a download ID in the pixels of an image so a leaked copy can
+// be traced back to the download it came from.
+//
+// The ID and a keyed check tag are spread over the luminance of the image's 8x8 blocks.
+// Every block nudges a few mid-frequency DCT coefficients along a keyed pseudo-random
+// pattern, with the sign of the payload bit it carries; textured blocks are nudged harder
+// than flat ones, where a change would show. Reading correlates each block with its
+// pattern and sums the blocks of every bit, so the ID survives JPEG recompression, mild
+// color edits, rotation by quarter turns and mirroring. It does not survive cropping, and
+// resized copies must be scaled back to the delivered size before reading.
+package fingerprint
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	idBits      = 32
+	tagBits     = 24
+	payloadBits = idBits + tagBits
+	blockSize   = 8
+	// strength is the change of each coefficient in a block of average texture
+	strength = 5.0
+	// minBlocksPerBit is the fewest blocks carrying each payload bit for a reliable read
+	minBlocksPerBit = 24
+)
+
+var (
+	// ErrTooSmall is returned for images with too few blocks to carry a fingerprint
+	ErrTooSmall = errors.New("image too small to carry a fingerprint")
+	// ErrNotFound is returned when no fingerprint made with the key can be read
+	ErrNotFound = errors.New("no fingerprint found")
+)
+
+// coefficients are the DCT coefficients (u, v) of a block carrying the payload
+var coefficients = [...][2]int{{1, 2}, {2, 1}, {2, 2}, {1, 3}, {3, 1}, {0, 3}, {3, 0}}
+
+// basis holds the orthonormal DCT basis image of each coefficient, row by row
+var basis [len(coefficients)][blockSize * blockSize]float64
+
+func init() {
+	c := func(u int) float64 {
+		if u == 0 {
+			return math.Sqrt(1.0 / blockSize)
+		}
+		return math.Sqrt(2.0 / blockSize)
+	}
+	for i, uv := range coefficients {
+		for y := 0; y < blockSize; y++ {
+			for x := 0; x < blockSize; x++ {
+				basis[i][y*blockSize+x] = c(uv[0]) * c(uv[1]) *
+					math.Cos(float64(2*x+1)*float64(uv[0])*math.Pi/(2*blockSize)) *
+					math.Cos(float64(2*y+1)*float64(uv[1])*math.Pi/(2*blockSize))
+			}
+		}
+	}
+}
+
+// layout is the keyed assignment of payload bits and patterns to the blocks of an image
+type layout struct {
+	cols, rows int
+	bit        []uint8 // payload bit carried by each block
+	pattern    []uint8 // bit i set when coefficient i of the block's pattern is positive
+}
+
+func newLayout(key []byte, cols, rows int) *layout {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "fingerprint-layout:%dx%d", cols, rows)
+	var seed [32]byte
+	copy(seed[:], mac.Sum(nil))
+	rng := rand.New(rand.NewChaCha8(seed))
+
+	n := cols * rows
+	l := &layout{cols: cols, rows: rows, bit: make([]uint8, n), pattern: make([]uint8, n)}
+	// A permutation spreads every bit evenly over the whole image
+	for i, b := range rng.Perm(n) {
+		l.bit[b] = uint8(i % payloadBits)
+	}
+	for b := range l.pattern {
+		l.pattern[b] = uint8(rng.Uint32())
+	}
+	return l
+}
+
+// payload returns the bits of the ID followed by its check tag
+func payload(key []byte, id uint32) [payloadBits]bool {
+	var bits [payloadBits]bool
+	data := binary.BigEndian.AppendUint32(nil, id)
+	data = append(data, tag(key, id)...)
+	for i := range bits {
+		bits[i] = data[i/8]&(0x80>>(i%8)) != 0
+	}
+	return bits
+}
+
+// tag authenticates an ID, so random images and forged fingerprints don't decode
+func tag(key []byte, id uint32) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "fingerprint-id:%d", id)
+	return mac.Sum(nil)[:tagBits/8]
+}
+
+// luma returns the luminance of the pixels of a block, row by row
+func luma(img *image.NRGBA, bx, by int, out *[blockSize * blockSize]float64) {
+	for y := 0; y < blockSize; y++ {
+		p := img.Pix[(by*blockSize+y)*img.Stride+bx*blockSize*4:]
+		for x := 0; x < blockSize; x++ {
+			out[y*blockSize+x] = 0.299*float64(p[x*4]) + 0.587*float64(p[x*4+1]) + 0.114*float64(p[x*4+2])
+		}
+	}
+}
+
+// texture returns the standard deviation of the luminance of a block
+func texture(y *[blockSize * blockSize]float64) float64 {
+	var sum, sq float64
+	for _, v := range y {
+		sum += v
+		sq += v * v
+	}
+	mean := sum / float64(len(y))
+	return math.Sqrt(max(sq/float64(len(y))-mean*mean, 0))
+}
+
+// Embed returns a copy of img carrying id. The key must be kept to read it back.
+func Embed(img image.Image, key []byte, id uint32) (*image.NRGBA, error) {
+	dst := imaging.Clone(img)
+	cols, rows := dst.Bounds().Dx()/blockSize, dst.Bounds().Dy()/blockSize
+	if cols*rows < payloadBits*minBlocksPerBit {
+		return nil, ErrTooSmall
+	}
+	l := newLayout(key, cols, rows)
+	bits := payload(key, id)
+
+	var y, delta [blockSize * blockSize]float64
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			b := by*cols + bx
+			luma(dst, bx, by, &y)
+			alpha := strength * min(max(texture(&y)/12, 0.4), 2.5)
+			if !bits[l.bit[b]] {
+				alpha = -alpha
+			}
+			delta = [blockSize * blockSize]float64{}
+			for i := range coefficients {
+				a := alpha
+				if l.pattern[b]&(1<<i) == 0 {
+					a = -a
+				}
+				for k, v := range basis[i] {
+					delta[k] += a * v
+				}
+			}
+			// The same change on every channel moves the luminance and keeps the hue
+			for py := 0; py < blockSize; py++ {
+				p := dst.Pix[(by*blockSize+py)*dst.Stride+bx*blockSize*4:]
+				for px := 0; px < blockSize; px++ {
+					d := delta[py*blockSize+px]
+					for c := 0; c < 3; c++ {
+						p[px*4+c] = uint8(min(max(math.Round(float64(p[px*4+c])+d), 0), 255))
+					}
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Extract reads the ID embedded in img with key, trying every quarter turn and mirror
+// image of it. It returns ErrNotFound when none carries a valid fingerprint.
+func Extract(img image.Image, key []byte) (uint32, error) {
+	src := imaging.Clone(img)
+	if (src.Bounds().Dx()/blockSize)*(src.Bounds().Dy()/blockSize) < payloadBits*minBlocksPerBit {
+		return 0, ErrTooSmall
+	}
+	// Undo the transformation a copy may have gone through
+	orientations := []func(image.Image) *image.NRGBA{
+		imaging.Clone, imaging.Rotate90, imaging.Rotate180, imaging.Rotate270,
+		imaging.FlipH, imaging.FlipV, imaging.Transpose, imaging.Transverse,
+	}
+	for _, orient := range orientations {
+		if id, ok := read(orient(src), key); ok {
+			return id, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// read decodes the payload of an image in its delivered orientation and checks its tag
+func read(img *image.NRGBA, key []byte) (uint32, bool) {
+	cols, rows := img.Bounds().Dx()/blockSize, img.Bounds().Dy()/blockSize
+	l := newLayout(key, cols, rows)
+
+	var sums [payloadBits]float64
+	var y [blockSize * blockSize]float64
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			b := by*cols + bx
+			luma(img, bx, by, &y)
+			var corr float64
+			for i := range coefficients {
+				var coef float64
+				for k, v := range basis[i] {
+					coef += v * y[k]
+				}
+				if l.pattern[b]&(1<<i) == 0 {
+					coef = -coef
+				}
+				corr += coef
+			}
+			// Weigh down busy blocks, their own detail drowns the pattern
+			sums[l.bit[b]] += corr / max(texture(&y), 4)
+		}
+	}
+
+	var data [payloadBits / 8]byte
+	for i, s := range sums {
+		if s > 0 {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	id := binary.BigEndian.Uint32(data[:4])
+	return id, hmac.Equal(data[4:], tag(key, id))
+}
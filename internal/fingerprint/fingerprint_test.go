@@ -0,0 +1,102 @@
+package fingerprint
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+var testKey = []byte("fingerprint-test-key")
+
+// photo returns a deterministic image with the smooth areas, edges and noise of a photo
+func photo(width, height int) *image.NRGBA {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := 110 + 60*math.Sin(fx*9)*math.Cos(fy*7) + rng.NormFloat64()*6
+			if (x/40+y/56)%3 == 0 {
+				v += 35
+			}
+			c := func(shift float64) uint8 { return uint8(min(max(v+shift, 0), 255)) }
+			img.SetNRGBA(x, y, color.NRGBA{c(20 * fx), c(0), c(-25 * fy), 255})
+		}
+	}
+	return img
+}
+
+func reencodeJPEG(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	const id = 0x2a5f01c3
+	marked, err := Embed(photo(512, 384), testKey, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		apply func(img image.Image) image.Image
+	}{
+		{"unchanged", func(img image.Image) image.Image { return img }},
+		{"JPEG quality 75", func(img image.Image) image.Image { return reencodeJPEG(t, img, 75) }},
+		{"rotated a quarter turn", func(img image.Image) image.Image { return imaging.Rotate90(img) }},
+		{"mirrored", func(img image.Image) image.Image { return imaging.FlipH(img) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Extract(tt.apply(marked), testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != id {
+				t.Errorf("Extract = %#x, want %#x", got, id)
+			}
+		})
+	}
+}
+
+func TestExtractWrongKey(t *testing.T) {
+	marked, err := Embed(photo(512, 384), testKey, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := Extract(marked, []byte("another-key")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Extract = %d, %v, want %v", id, err, ErrNotFound)
+	}
+}
+
+func TestExtractUnmarked(t *testing.T) {
+	if id, err := Extract(photo(512, 384), testKey); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Extract = %d, %v, want %v", id, err, ErrNotFound)
+	}
+}
+
+func TestTooSmall(t *testing.T) {
+	img := photo(160, 120)
+	if _, err := Embed(img, testKey, 42); !errors.Is(err, ErrTooSmall) {
+		t.Errorf("Embed error = %v, want %v", err, ErrTooSmall)
+	}
+	if _, err := Extract(img, testKey); !errors.Is(err, ErrTooSmall) {
+		t.Errorf("Extract error = %v, want %v", err, ErrTooSmall)
+	}
+}
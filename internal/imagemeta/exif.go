@@ -0,0 +1,243 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]int{
+	typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8,
+	typeUndefined: 1, typeSLong: 4, typeSRational: 8,
+}
+
+// EXIF tags that are read. Serial numbers and owner names are never read.
+const (
+	tagDescription      = 0x010E
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagArtist           = 0x013B
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensModel        = 0xA434
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+	tagGPSAltitudeRef   = 0x0005
+	tagGPSAltitude      = 0x0006
+)
+
+// maxIFDEntries bounds the entries read from one directory
+const maxIFDEntries = 1000
+
+// field is a decoded TIFF directory entry
+type field struct {
+	typ   uint16
+	count int
+	data  []byte
+}
+
+// tiff reads values out of an EXIF TIFF structure
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// readIFD returns the entries of the directory at offset by tag
+func (t *tiff) readIFD(offset int) map[uint16]field {
+	fields := map[uint16]field{}
+	if offset < 8 || offset+2 > len(t.data) {
+		return fields
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < min(n, maxIFDEntries); i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+		tag := t.order.Uint16(t.data[pos:])
+		typ := t.order.Uint16(t.data[pos+2:])
+		count := int(t.order.Uint32(t.data[pos+4:]))
+		size, ok := typeSizes[typ]
+		if !ok || count <= 0 || count > len(t.data) {
+			continue
+		}
+		length := size * count
+		valuePos := pos + 8
+		if length > 4 {
+			valuePos = int(t.order.Uint32(t.data[pos+8:]))
+		}
+		if valuePos < 0 || valuePos+length > len(t.data) {
+			continue
+		}
+		fields[tag] = field{typ: typ, count: count, data: t.data[valuePos : valuePos+length]}
+	}
+	return fields
+}
+
+func (t *tiff) str(f field) string {
+	if f.typ != typeASCII && f.typ != typeUndefined {
+		return ""
+	}
+	return clean(string(f.data))
+}
+
+// integer returns the first integer value of a field
+func (t *tiff) integer(f field) (int, bool) {
+	switch f.typ {
+	case typeByte:
+		return int(f.data[0]), true
+	case typeShort:
+		return int(t.order.Uint16(f.data)), true
+	case typeLong:
+		return int(t.order.Uint32(f.data)), true
+	}
+	return 0, false
+}
+
+// rational returns the i-th numerator and denominator of a rational field
+func (t *tiff) rational(f field, i int) (num, den int64, ok bool) {
+	if (f.typ != typeRational && f.typ != typeSRational) || i >= f.count {
+		return 0, 0, false
+	}
+	b := f.data[i*8:]
+	if f.typ == typeSRational {
+		num, den = int64(int32(t.order.Uint32(b))), int64(int32(t.order.Uint32(b[4:])))
+	} else {
+		num, den = int64(t.order.Uint32(b)), int64(t.order.Uint32(b[4:]))
+	}
+	return num, den, den != 0
+}
+
+func (t *tiff) float(f field, i int) (float64, bool) {
+	num, den, ok := t.rational(f, i)
+	if !ok {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// coordinate converts a degrees, minutes, seconds GPS field and its N/S or E/W reference to decimal degrees
+func (t *tiff) coordinate(f field, ref string, negative string) (*float64, bool) {
+	var dms [3]float64
+	for i := range dms {
+		v, ok := t.float(f, i)
+		if !ok {
+			return nil, false
+		}
+		dms[i] = v
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == negative {
+		deg = -deg
+	}
+	deg = math.Round(deg*1e6) / 1e6
+	return &deg, true
+}
+
+// parseEXIF fills m from a TIFF structure and reports whether anything was found
+func parseEXIF(data []byte, m *models.MediaMetadata) bool {
+	if len(data) < 8 {
+		return false
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return false
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return false
+	}
+
+	ifd0 := t.readIFD(int(t.order.Uint32(data[4:])))
+	found := len(ifd0) > 0
+	m.CameraMake = t.str(ifd0[tagMake])
+	m.CameraModel = t.str(ifd0[tagModel])
+	m.Description = t.str(ifd0[tagDescription])
+	m.Creator = t.str(ifd0[tagArtist])
+	m.Copyright = t.str(ifd0[tagCopyright])
+	if f, ok := ifd0[tagOrientation]; ok {
+		if v, ok := t.integer(f); ok && v >= 1 && v <= 8 {
+			m.Orientation = v
+		}
+	}
+
+	if f, ok := ifd0[tagExifIFD]; ok {
+		if offset, ok := t.integer(f); ok {
+			exif := t.readIFD(offset)
+			m.LensModel = t.str(exif[tagLensModel])
+			if num, den, ok := t.rational(exif[tagExposureTime], 0); ok && num > 0 {
+				m.ExposureTime = exposure(num, den)
+			}
+			if v, ok := t.float(exif[tagFNumber], 0); ok {
+				m.FNumber = math.Round(v*10) / 10
+			}
+			if v, ok := t.float(exif[tagFocalLength], 0); ok {
+				m.FocalLength = math.Round(v*10) / 10
+			}
+			if f, ok := exif[tagISO]; ok {
+				m.ISO, _ = t.integer(f)
+			}
+			if v := t.str(exif[tagDateTimeOriginal]); v != "" {
+				if captured, err := time.Parse("2006:01:02 15:04:05", v); err == nil {
+					m.CapturedAt = &captured
+				}
+			}
+		}
+	}
+
+	if f, ok := ifd0[tagGPSIFD]; ok {
+		if offset, ok := t.integer(f); ok {
+			gps := t.readIFD(offset)
+			lat, latOK := t.coordinate(gps[tagGPSLatitude], t.str(gps[tagGPSLatitudeRef]), "S")
+			lon, lonOK := t.coordinate(gps[tagGPSLongitude], t.str(gps[tagGPSLongitudeRef]), "W")
+			if latOK && lonOK {
+				m.GPSLatitude, m.GPSLongitude = lat, lon
+				if alt, ok := t.float(gps[tagGPSAltitude], 0); ok {
+					if ref, ok := gps[tagGPSAltitudeRef]; ok && ref.typ == typeByte && ref.data[0] == 1 {
+						alt = -alt
+					}
+					alt = math.Round(alt*10) / 10
+					m.GPSAltitude = &alt
+				}
+			}
+		}
+	}
+	return found
+}
+
+// exposure formats an exposure time the way cameras display it, e.g. "1/250" or "2.5"
+func exposure(num, den int64) string {
+	if num < den {
+		return fmt.Sprintf("1/%d", int64(math.Round(float64(den)/float64(num))))
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
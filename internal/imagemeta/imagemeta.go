@@ -0,0 +1,222 @@
+// Package imagemeta reads the EXIF, IPTC and XMP metadata embedded in JPEG and PNG images.
+// It only reads; re-encoding an image with the standard library encoders drops all of it.
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// maxSegment bounds the size of a metadata block that is read into memory
+const maxSegment = 4 << 20
+
+var (
+	jpegSOI      = []byte{0xFF, 0xD8}
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	psHeader     = []byte("Photoshop 3.0\x00")
+)
+
+// ErrUnsupported is returned for formats other than JPEG and PNG
+var ErrUnsupported = errors.New("imagemeta: unsupported image format")
+
+// blocks holds the raw metadata blocks found in an image
+type blocks struct {
+	exif []byte // TIFF structure
+	iptc []byte // IPTC-IIM records
+	xmp  []byte // XMP packet
+}
+
+// Read extracts the metadata of a JPEG or PNG image. Malformed blocks are skipped,
+// so an image with broken metadata yields whatever could be read. It returns nil
+// when the image carries no metadata.
+func Read(r io.Reader) (*models.MediaMetadata, error) {
+	br := bufio.NewReader(r)
+	sig, err := br.Peek(8)
+	if err != nil && len(sig) < 2 {
+		return nil, ErrUnsupported
+	}
+
+	var b blocks
+	switch {
+	case bytes.HasPrefix(sig, jpegSOI):
+		err = readJPEG(br, &b)
+	case bytes.HasPrefix(sig, pngSignature):
+		err = readPNG(br, &b)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	m := &models.MediaMetadata{}
+	found := false
+	if b.exif != nil {
+		found = parseEXIF(b.exif, m) || found
+	}
+	// XMP is the newer standard, its values win over the legacy IPTC records
+	if b.iptc != nil {
+		found = parseIPTC(b.iptc, m) || found
+	}
+	if b.xmp != nil {
+		found = parseXMP(b.xmp, m) || found
+	}
+	if !found {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// readJPEG collects the APP1 (EXIF, XMP) and APP13 (IPTC) segments up to the image data
+func readJPEG(r *bufio.Reader, b *blocks) error {
+	if _, err := r.Discard(2); err != nil {
+		return err
+	}
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if marker != 0xFF {
+			return errors.New("imagemeta: invalid JPEG marker")
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case kind == 0xFF: // fill byte
+			r.UnreadByte()
+			continue
+		case kind == 0xDA || kind == 0xD9: // start of scan or end of image, no metadata follows
+			return nil
+		case kind == 0x01 || (kind >= 0xD0 && kind <= 0xD7): // markers without a payload
+			continue
+		}
+
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		if size < 2 {
+			return errors.New("imagemeta: invalid JPEG segment")
+		}
+		n := int(size) - 2
+		if kind != 0xE1 && kind != 0xED {
+			if _, err := r.Discard(n); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		switch {
+		case kind == 0xE1 && bytes.HasPrefix(data, exifHeader) && b.exif == nil:
+			b.exif = data[len(exifHeader):]
+		case kind == 0xE1 && bytes.HasPrefix(data, xmpHeader) && b.xmp == nil:
+			b.xmp = data[len(xmpHeader):]
+		case kind == 0xED && bytes.HasPrefix(data, psHeader) && b.iptc == nil:
+			b.iptc = photoshopIPTC(data[len(psHeader):])
+		}
+	}
+}
+
+// photoshopIPTC returns the IPTC-NAA resource (0x0404) of a Photoshop image resource block
+func photoshopIPTC(data []byte) []byte {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:6])
+		// Pascal string name padded to an even length
+		nameLen := int(data[6]) + 1
+		if nameLen%2 != 0 {
+			nameLen++
+		}
+		pos := 6 + nameLen
+		if pos+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			return nil
+		}
+		if id == 0x0404 {
+			return data[pos : pos+size]
+		}
+		if size%2 != 0 {
+			size++
+		}
+		if pos+size > len(data) {
+			return nil
+		}
+		data = data[pos+size:]
+	}
+	return nil
+}
+
+// readPNG collects the eXIf chunk and the XMP iTXt chunk up to the image data
+func readPNG(r *bufio.Reader, b *blocks) error {
+	if _, err := r.Discard(len(pngSignature)); err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		kind := string(header[4:8])
+		if kind == "IDAT" || kind == "IEND" {
+			return nil
+		}
+		if size > maxSegment || (kind != "eXIf" && kind != "iTXt") {
+			if _, err := r.Discard(int(size) + 4); err != nil { // data and CRC
+				return err
+			}
+			continue
+		}
+		data := make([]byte, size+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		data = data[:size]
+		switch kind {
+		case "eXIf":
+			b.exif = data
+		case "iTXt":
+			if xmp := pngXMP(data); xmp != nil {
+				b.xmp = xmp
+			}
+		}
+	}
+}
+
+// pngXMP returns the XMP packet of an uncompressed iTXt chunk with the XMP keyword
+func pngXMP(data []byte) []byte {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || string(keyword) != "XML:com.adobe.xmp" || len(rest) < 2 || rest[0] != 0 {
+		return nil
+	}
+	// Skip the compression flag and method, the language tag and the translated keyword
+	rest = rest[2:]
+	for range 2 {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return nil
+		}
+	}
+	return rest
+}
+
+// clean trims a metadata string and drops the NUL padding some cameras write
+func clean(s string) string {
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
@@ -0,0 +1,413 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// byteOrder reads and appends in the byte order of a test TIFF structure
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffEntry is a directory entry of a test TIFF structure
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte // stored out of line when longer than 4 bytes
+	ifd      int    // when set, the value is the offset of that directory
+}
+
+// buildTIFF lays out the directories one after the other from offset 8, ifds[0] being IFD0,
+// and the out of line values after them
+func buildTIFF(order byteOrder, ifds ...[]tiffEntry) []byte {
+	offsets := make([]int, len(ifds))
+	pos := 8
+	for i, ifd := range ifds {
+		offsets[i] = pos
+		pos += 2 + 12*len(ifd) + 4
+	}
+	out := make([]byte, pos)
+	copy(out, "MM")
+	if order == binary.LittleEndian {
+		copy(out, "II")
+	}
+	order.PutUint16(out[2:], 42)
+	order.PutUint32(out[4:], 8)
+	for i, ifd := range ifds {
+		p := offsets[i]
+		order.PutUint16(out[p:], uint16(len(ifd)))
+		for j, e := range ifd {
+			ep := p + 2 + 12*j
+			order.PutUint16(out[ep:], e.tag)
+			order.PutUint16(out[ep+2:], e.typ)
+			order.PutUint32(out[ep+4:], e.count)
+			value := e.value
+			if e.ifd > 0 {
+				value = order.AppendUint32(nil, uint32(offsets[e.ifd]))
+			}
+			if len(value) <= 4 {
+				copy(out[ep+8:], value)
+			} else {
+				order.PutUint32(out[ep+8:], uint32(len(out)))
+				out = append(out, value...)
+			}
+		}
+	}
+	return out
+}
+
+func ascii(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func short(order byteOrder, tag uint16, v uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeShort, count: 1, value: order.AppendUint16(nil, v)}
+}
+
+func rationals(order byteOrder, tag uint16, pairs ...uint32) tiffEntry {
+	var value []byte
+	for _, v := range pairs {
+		value = order.AppendUint32(value, v)
+	}
+	return tiffEntry{tag: tag, typ: typeRational, count: uint32(len(pairs) / 2), value: value}
+}
+
+func pointer(tag uint16, ifd int) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeLong, count: 1, ifd: ifd}
+}
+
+// sampleTIFF returns the EXIF of a photo with camera, exposure and GPS details
+func sampleTIFF(order byteOrder) []byte {
+	return buildTIFF(order,
+		[]tiffEntry{
+			ascii(tagMake, "Canon"),
+			ascii(tagModel, "EOS R5"),
+			short(order, tagOrientation, 6),
+			pointer(tagExifIFD, 1),
+			pointer(tagGPSIFD, 2),
+		},
+		[]tiffEntry{
+			rationals(order, tagExposureTime, 1, 250),
+			rationals(order, tagFNumber, 28, 10),
+			short(order, tagISO, 400),
+			ascii(tagDateTimeOriginal, "2024:05:01 10:20:30"),
+		},
+		[]tiffEntry{
+			ascii(tagGPSLatitudeRef, "N"),
+			rationals(order, tagGPSLatitude, 40, 1, 26, 1, 46, 1),
+			ascii(tagGPSLongitudeRef, "W"),
+			rationals(order, tagGPSLongitude, 79, 1, 58, 1, 56, 1),
+		},
+	)
+}
+
+// jpegSegment returns a JPEG marker segment
+func jpegSegment(kind byte, data []byte) []byte {
+	seg := []byte{0xFF, kind, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(data)+2))
+	return append(seg, data...)
+}
+
+// jpeg returns a JPEG made of the given segments, followed by a start of scan
+func jpeg(segments ...[]byte) []byte {
+	out := slices.Clone(jpegSOI)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(0xE1, append(slices.Clone(exifHeader), tiff...))
+}
+
+// iptcRecords returns application records, datasets given as dataset number and value pairs
+func iptcRecords(datasets ...any) []byte {
+	var out []byte
+	for i := 0; i < len(datasets); i += 2 {
+		value := datasets[i+1].(string)
+		out = append(out, 0x1C, 2, byte(datasets[i].(int)))
+		out = binary.BigEndian.AppendUint16(out, uint16(len(value)))
+		out = append(out, value...)
+	}
+	return out
+}
+
+// photoshopSegment returns an APP13 segment holding an IPTC-NAA resource
+func photoshopSegment(iptc []byte) []byte {
+	data := append(slices.Clone(psHeader), "8BIM"...)
+	data = binary.BigEndian.AppendUint16(data, 0x0404)
+	data = append(data, 0, 0) // empty name, padded
+	data = binary.BigEndian.AppendUint32(data, uint32(len(iptc)))
+	return jpegSegment(0xED, append(data, iptc...))
+}
+
+const sampleXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Harbor at dawn</rdf:li></rdf:Alt></dc:title>
+<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+<dc:subject><rdf:Bag><rdf:li>harbor</rdf:li><rdf:li>boats</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>`
+
+// pngChunk returns a PNG chunk, the CRC isn't checked by the reader
+func pngChunk(kind string, data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	out = append(out, kind...)
+	out = append(out, data...)
+	return append(out, 0, 0, 0, 0)
+}
+
+func png(chunks ...[]byte) []byte {
+	out := slices.Clone(pngSignature)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, pngChunk("IEND", nil)...)
+}
+
+func pngXMPChunk(xmp string) []byte {
+	return pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))
+}
+
+func TestReadJPEG(t *testing.T) {
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			m, err := Read(bytes.NewReader(jpeg(
+				jpegSegment(0xE0, []byte("JFIF\x00\x01\x02")),
+				exifSegment(sampleTIFF(order)),
+			)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m == nil {
+				t.Fatal("no metadata read")
+			}
+			if m.CameraMake != "Canon" || m.CameraModel != "EOS R5" || m.Orientation != 6 {
+				t.Errorf("camera = %q %q orientation %d", m.CameraMake, m.CameraModel, m.Orientation)
+			}
+			if m.ExposureTime != "1/250" || m.FNumber != 2.8 || m.ISO != 400 {
+				t.Errorf("exposure = %s f/%v ISO %d", m.ExposureTime, m.FNumber, m.ISO)
+			}
+			if want := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC); m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+				t.Errorf("captured at = %v, want %v", m.CapturedAt, want)
+			}
+			if m.GPSLatitude == nil || m.GPSLongitude == nil ||
+				math.Abs(*m.GPSLatitude-40.446111) > 1e-6 || math.Abs(*m.GPSLongitude+79.982222) > 1e-6 {
+				t.Errorf("location = %v, %v", m.GPSLatitude, m.GPSLongitude)
+			}
+		})
+	}
+}
+
+func TestReadJPEGIPTCAndXMP(t *testing.T) {
+	m, err := Read(bytes.NewReader(jpeg(
+		photoshopSegment(iptcRecords(iptcObjectName, "Old title", iptcKeywords, "sea", iptcKeywords, "sea", iptcCopyright, "(c) Jane")),
+		jpegSegment(0xE1, append(slices.Clone(xmpHeader), sampleXMP...)),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		t.Fatal("no metadata read")
+	}
+	// XMP wins over IPTC, IPTC fills in what XMP lacks
+	if m.Title != "Harbor at dawn" || m.Creator != "Jane Doe" || m.Copyright != "(c) Jane" {
+		t.Errorf("title %q creator %q copyright %q", m.Title, m.Creator, m.Copyright)
+	}
+	if !slices.Equal(m.Keywords, []string{"harbor", "boats"}) {
+		t.Errorf("keywords = %q", m.Keywords)
+	}
+}
+
+func TestReadPNG(t *testing.T) {
+	m, err := Read(bytes.NewReader(png(
+		pngChunk("IHDR", make([]byte, 13)),
+		pngChunk("eXIf", sampleTIFF(binary.BigEndian)),
+		pngXMPChunk(sampleXMP),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		t.Fatal("no metadata read")
+	}
+	if m.CameraModel != "EOS R5" || m.Title != "Harbor at dawn" {
+		t.Errorf("model %q title %q", m.CameraModel, m.Title)
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	for _, data := range [][]byte{nil, {0xFF}, []byte("GIF89a......")} {
+		if _, err := Read(bytes.NewReader(data)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Read(%q) error = %v, want ErrUnsupported", data, err)
+		}
+	}
+}
+
+func TestReadNoMetadata(t *testing.T) {
+	m, err := Read(bytes.NewReader(jpeg(jpegSegment(0xE0, []byte("JFIF\x00\x01\x02")))))
+	if err != nil || m != nil {
+		t.Errorf("Read = %+v, %v, want no metadata", m, err)
+	}
+}
+
+// TestReadMalformed feeds crafted, broken metadata. Read must not panic or loop, and must
+// either fail or return only what is intact.
+func TestReadMalformed(t *testing.T) {
+	le := binary.LittleEndian
+	valid := sampleTIFF(le)
+	withOrder := func(order string) []byte {
+		data := slices.Clone(valid)
+		copy(data, order)
+		return data
+	}
+	patch := func(offset int, v uint32) []byte {
+		data := slices.Clone(valid)
+		le.PutUint32(data[offset:], v)
+		return data
+	}
+	// IFD0 starts at 8; entry i of IFD0 starts at 10+12*i, its count at +4 and value at +8
+	entry := func(i int) int { return 10 + 12*i }
+	exifIFD := 8 + 2 + 12*5 + 4
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, m *models.MediaMetadata) // what must survive, nil when nothing
+	}{
+		{name: "truncated segment", data: append(slices.Clone(jpegSOI), exifSegment(valid)[:40]...)},
+		{name: "segment size below 2", data: append(slices.Clone(jpegSOI), 0xFF, 0xE1, 0x00, 0x01)},
+		{name: "invalid marker", data: append(slices.Clone(jpegSOI), 0x12, 0x34)},
+		{name: "fill bytes only", data: append(slices.Clone(jpegSOI), bytes.Repeat([]byte{0xFF}, 100)...)},
+		{name: "bad byte order", data: jpeg(exifSegment(withOrder("XI")))},
+		{name: "bad TIFF magic", data: jpeg(exifSegment(patch(2, 43)))},
+		{name: "short TIFF header", data: jpeg(exifSegment([]byte("II*\x00")))},
+		{name: "IFD0 offset out of bounds", data: jpeg(exifSegment(patch(4, 0xFFFFFFF0)))},
+		{name: "IFD0 offset inside the header", data: jpeg(exifSegment(patch(4, 2)))},
+		{name: "truncated IFD0", data: jpeg(exifSegment(valid[:entry(1)+6]))},
+		{name: "entry count beyond the data", data: jpeg(exifSegment(func() []byte {
+			data := slices.Clone(valid)
+			le.PutUint16(data[8:], 0xFFFF)
+			return data
+		}())), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraMake != "Canon" {
+				t.Errorf("make = %q, want the intact entry", m.CameraMake)
+			}
+		}},
+		{name: "oversized count", data: jpeg(exifSegment(patch(entry(1)+4, 0xFFFFFFFF))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraModel != "" || m.CameraMake != "Canon" {
+				t.Errorf("make %q model %q, want the broken model skipped", m.CameraMake, m.CameraModel)
+			}
+		}},
+		{name: "count overflowing the value size", data: jpeg(exifSegment(patch(entry(0)+4, 0x40000001))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraMake != "" || m.CameraModel != "EOS R5" {
+				t.Errorf("make %q model %q, want the broken make skipped", m.CameraMake, m.CameraModel)
+			}
+		}},
+		{name: "value offset out of bounds", data: jpeg(exifSegment(patch(entry(1)+8, 0xFFFFFFF0))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraModel != "" {
+				t.Errorf("model = %q, want it skipped", m.CameraModel)
+			}
+		}},
+		{name: "Exif IFD out of bounds", data: jpeg(exifSegment(patch(entry(3)+8, 0x7FFFFFFF))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraMake != "Canon" || m.ExposureTime != "" {
+				t.Errorf("make %q exposure %q", m.CameraMake, m.ExposureTime)
+			}
+		}},
+		{name: "Exif IFD pointing back at IFD0", data: jpeg(exifSegment(patch(entry(3)+8, 8))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.CameraMake != "Canon" {
+				t.Errorf("make = %q", m.CameraMake)
+			}
+		}},
+		{name: "GPS IFD pointing at the Exif IFD", data: jpeg(exifSegment(patch(entry(4)+8, uint32(exifIFD)))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.GPSLatitude != nil || m.ExposureTime != "1/250" {
+				t.Errorf("latitude %v exposure %q", m.GPSLatitude, m.ExposureTime)
+			}
+		}},
+		{name: "zero denominators", data: jpeg(exifSegment(buildTIFF(le,
+			[]tiffEntry{ascii(tagMake, "Canon"), pointer(tagExifIFD, 1), pointer(tagGPSIFD, 2)},
+			[]tiffEntry{rationals(le, tagExposureTime, 1, 0), rationals(le, tagFNumber, 0, 0)},
+			[]tiffEntry{ascii(tagGPSLatitudeRef, "N"), rationals(le, tagGPSLatitude, 1, 0, 1, 0, 1, 0),
+				ascii(tagGPSLongitudeRef, "E"), rationals(le, tagGPSLongitude, 1, 1, 1, 1, 1, 1)},
+		))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.ExposureTime != "" || m.FNumber != 0 || m.GPSLatitude != nil {
+				t.Errorf("exposure %q f/%v latitude %v", m.ExposureTime, m.FNumber, m.GPSLatitude)
+			}
+		}},
+		{name: "short GPS coordinate", data: jpeg(exifSegment(buildTIFF(le,
+			[]tiffEntry{ascii(tagMake, "Canon"), pointer(tagGPSIFD, 1)},
+			[]tiffEntry{rationals(le, tagGPSLatitude, 40, 1, 26, 1), rationals(le, tagGPSLongitude, 79, 1, 58, 1, 56, 1)},
+		))), check: func(t *testing.T, m *models.MediaMetadata) {
+			if m.GPSLatitude != nil || m.GPSLongitude != nil {
+				t.Errorf("location = %v, %v", m.GPSLatitude, m.GPSLongitude)
+			}
+		}},
+		{name: "wrongly typed fields", data: jpeg(exifSegment(buildTIFF(le,
+			[]tiffEntry{
+				{tag: tagMake, typ: typeRational, count: 1, value: make([]byte, 8)},
+				{tag: tagOrientation, typ: typeASCII, count: 2, value: []byte("6\x00")},
+				{tag: tagExifIFD, typ: typeASCII, count: 4, value: []byte("abc\x00")},
+				{tag: tagModel, typ: 0x99, count: 1},
+			},
+		)))},
+		{name: "huge Photoshop resource", data: jpeg(func() []byte {
+			seg := photoshopSegment(iptcRecords(iptcObjectName, "Title"))
+			binary.BigEndian.PutUint32(seg[4+len(psHeader)+8:], 0xFFFFFFFF)
+			return seg
+		}())},
+		{name: "odd Photoshop name length", data: jpeg(jpegSegment(0xED, append(slices.Clone(psHeader), "8BIM\x04\x04\xFF"...)))},
+		{name: "IPTC dataset beyond the data", data: jpeg(photoshopSegment([]byte{0x1C, 2, iptcObjectName, 0x10, 0x00, 'a'}))},
+		{name: "IPTC extended dataset", data: jpeg(photoshopSegment([]byte{0x1C, 2, iptcObjectName, 0x80, 0x04, 0, 0, 0, 1, 'a'}))},
+		{name: "unterminated XMP", data: jpeg(jpegSegment(0xE1, append(slices.Clone(xmpHeader), sampleXMP[:120]...)))},
+		{name: "XMP that isn't XML", data: jpeg(jpegSegment(0xE1, append(slices.Clone(xmpHeader), "<<<&&&\x00\xFF"...)))},
+		{name: "PNG chunk size overflow", data: append(slices.Clone(pngSignature), pngChunk("eXIf", nil)[:4:4]...)},
+		{name: "PNG huge chunk", data: func() []byte {
+			data := append(slices.Clone(pngSignature), pngChunk("eXIf", valid)...)
+			binary.BigEndian.PutUint32(data[len(pngSignature):], 0xFFFFFFF0)
+			return data
+		}()},
+		{name: "truncated PNG chunk", data: append(slices.Clone(pngSignature), pngChunk("eXIf", valid)[:30]...)},
+		{name: "compressed PNG XMP", data: png(pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x01\x00\x00\x00"), sampleXMP...)))},
+		{name: "PNG XMP missing separators", data: png(pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Read(bytes.NewReader(tt.data))
+			if tt.check == nil {
+				if err == nil && m != nil && m.CameraModel != "" {
+					t.Fatalf("Read = %+v, want an error or no camera details", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read error = %v, want the intact metadata", err)
+			}
+			if m == nil {
+				t.Fatal("no metadata read, want the intact metadata")
+			}
+			tt.check(t, m)
+		})
+	}
+}
+
+// FuzzRead checks that no input makes Read panic
+func FuzzRead(f *testing.F) {
+	f.Add(jpeg(exifSegment(sampleTIFF(binary.LittleEndian))))
+	f.Add(jpeg(exifSegment(sampleTIFF(binary.BigEndian)), photoshopSegment(iptcRecords(iptcKeywords, "sea"))))
+	f.Add(png(pngChunk("eXIf", sampleTIFF(binary.BigEndian)), pngXMPChunk(sampleXMP)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Read(bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,59 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// IPTC-IIM application record (2) datasets that are read
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByline     = 80
+	iptcHeadline   = 105
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// parseIPTC fills m from IPTC-IIM records and reports whether anything was found.
+// Values are assumed to be UTF-8, which current software writes.
+func parseIPTC(data []byte, m *models.MediaMetadata) bool {
+	found := false
+	var headline string
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+		if size&0x8000 != 0 || 5+size > len(data) { // extended datasets are not used for text
+			return found
+		}
+		value := clean(string(data[5 : 5+size]))
+		data = data[5+size:]
+		if record != 2 || value == "" {
+			continue
+		}
+
+		found = true
+		switch dataset {
+		case iptcObjectName:
+			m.Title = value
+		case iptcHeadline:
+			headline = value
+		case iptcCaption:
+			m.Description = value
+		case iptcKeywords:
+			if !slices.Contains(m.Keywords, value) {
+				m.Keywords = append(m.Keywords, value)
+			}
+		case iptcByline:
+			m.Creator = value
+		case iptcCopyright:
+			m.Copyright = value
+		}
+	}
+	if m.Title == "" {
+		m.Title = headline
+	}
+	return found
+}
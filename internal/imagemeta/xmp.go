@@ -0,0 +1,89 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+const (
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// parseXMP fills m from the Dublin Core properties of an XMP packet and reports whether
+// anything was found. Language alternatives use the first entry, normally x-default.
+func parseXMP(data []byte, m *models.MediaMetadata) bool {
+	values := map[string][]string{}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+
+	var property string // Dublin Core property being read
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == nsDC:
+				property = t.Name.Local
+				text.Reset()
+			case t.Name.Space == nsRDF && t.Name.Local == "li":
+				text.Reset()
+			case t.Name.Space == nsRDF && t.Name.Local == "Description":
+				// Properties can also be written as attributes
+				for _, a := range t.Attr {
+					if a.Name.Space == nsDC && strings.TrimSpace(a.Value) != "" {
+						values[a.Name.Local] = append(values[a.Name.Local], strings.TrimSpace(a.Value))
+					}
+				}
+			}
+		case xml.CharData:
+			if property != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Space == nsRDF && t.Name.Local == "li" && property != "":
+				if v := strings.TrimSpace(text.String()); v != "" {
+					values[property] = append(values[property], v)
+				}
+				text.Reset()
+			case t.Name.Space == nsDC && t.Name.Local == property:
+				// Simple properties hold their value directly
+				if v := strings.TrimSpace(text.String()); v != "" && len(values[property]) == 0 {
+					values[property] = append(values[property], v)
+				}
+				property = ""
+			}
+		}
+	}
+
+	first := func(name string) string {
+		if v := values[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if v := first("title"); v != "" {
+		m.Title = v
+	}
+	if v := first("description"); v != "" {
+		m.Description = v
+	}
+	if v := values["creator"]; len(v) > 0 {
+		m.Creator = strings.Join(v, ", ")
+	}
+	if v := first("rights"); v != "" {
+		m.Copyright = v
+	}
+	if v := values["subject"]; len(v) > 0 {
+		m.Keywords = v
+	}
+	return len(values) > 0
+}
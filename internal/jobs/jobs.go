@@ -0,0 +1,187 @@
+// Package jobs runs background work from a queue shared by every API instance.
+//
+// Workers claim due jobs one at a time from a Store, which hands each job to a single worker
+// (PostgreSQL: FOR UPDATE SKIP LOCKED). A failed job is retried with exponential backoff and
+// moved to the dead-letter state after its last attempt, or at once when its handler returns
+// a Permanent error. A job whose worker died is claimed again once its lease has expired, so
+// handlers must be safe to run twice.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// Store persists the queue
+type Store interface {
+	// Claim locks the next due job for a worker and counts an attempt, nil when none is due.
+	// Running jobs whose attempt started before staleBefore are due again.
+	Claim(ctx context.Context, staleBefore time.Time) (*models.Job, error)
+	// Complete marks the job done
+	Complete(ctx context.Context, job *models.Job) error
+	// Retry queues the job again at runAt with the error of the failed attempt
+	Retry(ctx context.Context, job *models.Job, cause string, runAt time.Time) error
+	// Bury moves the job to the dead-letter state with the error of its last attempt
+	Bury(ctx context.Context, job *models.Job, cause string) error
+}
+
+// Handler does the work of a job. Jobs are retried when it returns an error.
+type Handler func(ctx context.Context, job *models.Job) error
+
+// Options configures a Pool
+type Options struct {
+	Workers      int           // jobs run at once by this instance
+	PollInterval time.Duration // how often idle workers look for due jobs
+	Lease        time.Duration // how long an attempt may run before another worker takes the job over
+	Backoff      time.Duration // delay before the first retry, doubled after every failed attempt
+	MaxBackoff   time.Duration // longest delay between attempts
+}
+
+// permanentError marks failures that retrying won't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error that retrying won't fix, the job goes to the dead-letter state at once
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Pool runs the handlers of the jobs claimed by its workers
+type Pool struct {
+	store    Store
+	opts     Options
+	handlers map[string]Handler
+	wake     chan struct{}
+}
+
+// New returns a pool working the jobs of store. Zero options take their defaults.
+func New(store Store, opts Options) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = 10 * time.Minute
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	return &Pool{store: store, opts: opts, handlers: make(map[string]Handler), wake: make(chan struct{}, 1)}
+}
+
+// Handle registers the handler of a kind of job. It must be called before Run.
+func (p *Pool) Handle(kind string, h Handler) {
+	p.handlers[kind] = h
+}
+
+// Notify wakes an idle worker after a job was queued, instead of waiting for the next poll
+func (p *Pool) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run works the queue until ctx is done. Jobs interrupted by the shutdown are queued again.
+func (p *Pool) Run(ctx context.Context, errorLog func(error)) {
+	var wg sync.WaitGroup
+	for range p.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx, errorLog)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context, errorLog func(error)) {
+	poll := time.NewTimer(0)
+	defer poll.Stop()
+	for {
+		job, err := p.store.Claim(ctx, time.Now().Add(-p.opts.Lease))
+		if err != nil && ctx.Err() == nil {
+			errorLog(fmt.Errorf("claim job: %w", err))
+		}
+		if job != nil {
+			p.run(ctx, job, errorLog)
+			continue
+		}
+
+		poll.Reset(p.opts.PollInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// run runs one attempt of a job and records its outcome
+func (p *Pool) run(ctx context.Context, job *models.Job, errorLog func(error)) {
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// Only reached by taking over jobs whose workers died, e.g. on images that crash the process
+		err = Permanent(errors.New("attempts exhausted by workers that stopped responding"))
+	} else if h, ok := p.handlers[job.Kind]; !ok {
+		err = Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	} else {
+		err = call(ctx, h, job)
+	}
+
+	// Record the outcome even when shutting down
+	bctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		err = p.store.Complete(bctx, job)
+	case ctx.Err() != nil:
+		// Interrupted, not failed: due again at once for the next worker
+		err = p.store.Retry(bctx, job, "interrupted by shutdown", time.Now())
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		errorLog(fmt.Errorf("job %d (%s) failed for good: %w", job.ID, job.Kind, err))
+		err = p.store.Bury(bctx, job, err.Error())
+	default:
+		errorLog(fmt.Errorf("job %d (%s) attempt %d failed: %w", job.ID, job.Kind, job.Attempts, err))
+		err = p.store.Retry(bctx, job, err.Error(), time.Now().Add(p.backoff(job.Attempts)))
+	}
+	if err != nil {
+		errorLog(fmt.Errorf("record outcome of job %d: %w", job.ID, err))
+	}
+}
+
+// call runs a handler, turning a panic into an error so one bad job doesn't stop the worker
+func call(ctx context.Context, h Handler, job *models.Job) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// backoff returns the delay before the attempt after the given one, with up to 20% jitter
+// so jobs that failed together don't retry together
+func (p *Pool) backoff(attempt int) time.Duration {
+	d := p.opts.Backoff
+	for i := 1; i < attempt && d < p.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.opts.MaxBackoff)
+	return d + time.Duration(rand.Int64N(int64(d)/5+1))
+}
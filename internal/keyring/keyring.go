@@ -0,0 +1,325 @@
+// Package keyring signs and verifies access tokens with rotating keys identified by a key id (kid).
+//
+// RS256 and EdDSA keys are kept in a Store shared by every API instance. The newest key signs,
+// retired keys keep verifying until the tokens they signed have expired, and the public halves
+// are published as a JSON Web Key Set. HS256 signs with a single shared secret and publishes nothing.
+package keyring
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/repositories"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+// Supported signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// reloadInterval is how often keys created by other instances are picked up
+const reloadInterval = time.Minute
+
+// ErrUnknownKey is returned when a token names a key that is not (or no longer) accepted
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Store persists signing keys
+type Store interface {
+	// GetAll returns every key that is still accepted for verification, newest first
+	GetAll(ctx context.Context) ([]*models.SigningKey, error)
+	// Rotate stores next as the signing key and retires the current one
+	Rotate(ctx context.Context, previousKID string, next *models.SigningKey, retiredExpiresAt time.Time) error
+}
+
+// Options configures a Keyring
+type Options struct {
+	Algorithm     string        // HS256, RS256 or EdDSA
+	Secret        string        // HS256 secret
+	EncryptionKey string        // passphrase protecting private keys at rest
+	RotateEvery   time.Duration // age after which the signing key is replaced, 0 disables rotation
+	VerifyFor     time.Duration // how long a retired key keeps verifying; at least the longest token lifetime
+}
+
+// key is a parsed signing key
+type key struct {
+	kid       string
+	alg       string
+	private   crypto.Signer
+	public    crypto.PublicKey
+	createdAt time.Time
+	retired   bool
+}
+
+// Keyring signs tokens with the current key and verifies tokens signed by any accepted key
+type Keyring struct {
+	store Store
+	opts  Options
+
+	mu         sync.RWMutex
+	keys       map[string]*key
+	current    *key
+	lastReload time.Time
+}
+
+// New returns a Keyring. Asymmetric keys are loaded, and created when missing, by Load.
+func New(store Store, opts Options) (*Keyring, error) {
+	switch opts.Algorithm {
+	case HS256:
+		if opts.Secret == "" {
+			return nil, errors.New("keyring: HS256 requires a secret")
+		}
+	case RS256, EdDSA:
+	default:
+		return nil, fmt.Errorf("keyring: unsupported algorithm %q", opts.Algorithm)
+	}
+	return &Keyring{store: store, opts: opts, keys: map[string]*key{}}, nil
+}
+
+// Algorithm returns the algorithm new tokens are signed with
+func (k *Keyring) Algorithm() string {
+	return k.opts.Algorithm
+}
+
+// Load reads the keys from the store and makes sure there is a current key for the configured algorithm
+func (k *Keyring) Load(ctx context.Context) error {
+	if err := k.reload(ctx); err != nil {
+		return err
+	}
+	if k.opts.Algorithm == HS256 {
+		return nil
+	}
+	k.mu.RLock()
+	current := k.current
+	k.mu.RUnlock()
+	if current == nil || current.alg != k.opts.Algorithm {
+		return k.Rotate(ctx)
+	}
+	return nil
+}
+
+// Run rotates the signing key on schedule and picks up keys rotated by other instances until ctx is done
+func (k *Keyring) Run(ctx context.Context, errorLog func(error)) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if k.opts.Algorithm == HS256 {
+			continue
+		}
+		if err := k.reload(ctx); err != nil {
+			errorLog(err)
+			continue
+		}
+		k.mu.RLock()
+		current := k.current
+		k.mu.RUnlock()
+		if current == nil || (k.opts.RotateEvery > 0 && time.Since(current.createdAt) >= k.opts.RotateEvery) {
+			if err := k.Rotate(ctx); err != nil {
+				errorLog(err)
+			}
+		}
+	}
+}
+
+// Rotate creates a new signing key. The previous key keeps verifying for VerifyFor.
+func (k *Keyring) Rotate(ctx context.Context) error {
+	if k.opts.Algorithm == HS256 {
+		return errors.New("keyring: HS256 keys are not rotated")
+	}
+
+	next, err := generate(k.opts.Algorithm, k.opts.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	previousKID := ""
+	if k.current != nil {
+		previousKID = k.current.kid
+	}
+	k.mu.RUnlock()
+
+	err = k.store.Rotate(ctx, previousKID, next, time.Now().Add(k.opts.VerifyFor))
+	if err != nil && !errors.Is(err, repositories.ErrKeyRotated) {
+		return err
+	}
+	// either way the store now holds a fresh key
+	return k.reload(ctx)
+}
+
+// reload replaces the in-memory keys with the ones in the store
+func (k *Keyring) reload(ctx context.Context) error {
+	stored, err := k.store.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*key, len(stored))
+	var current *key
+	for _, s := range stored {
+		parsed, err := k.parse(s)
+		if err != nil {
+			return fmt.Errorf("keyring: key %s: %w", s.KID, err)
+		}
+		keys[parsed.kid] = parsed
+		// stored keys are sorted newest first
+		if current == nil && !parsed.retired {
+			current = parsed
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.current = current
+	k.lastReload = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// Sign returns the signed token for claims
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.opts.Algorithm == HS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(k.opts.Secret))
+	}
+
+	k.mu.RLock()
+	current := k.current
+	k.mu.RUnlock()
+	if current == nil {
+		return "", errors.New("keyring: no signing key")
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(current.alg), claims)
+	token.Header["kid"] = current.kid
+	return token.SignedString(current.private)
+}
+
+// Keyfunc resolves the verification key of a token. It is meant to be passed to jwt.Parse.
+// Only tokens signed with the configured algorithm, or by a still accepted key, are valid.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.opts.Algorithm != HS256 {
+			return nil, ErrUnknownKey
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(k.opts.Secret), nil
+	}
+
+	verifying, ok := k.lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != verifying.alg {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return verifying.public, nil
+}
+
+// lookup finds a key by kid. Unknown kids trigger a reload, at most once per second,
+// so tokens signed by a key another instance just created are accepted.
+func (k *Keyring) lookup(kid string) (*key, bool) {
+	k.mu.RLock()
+	found, ok := k.keys[kid]
+	stale := time.Since(k.lastReload) > time.Second
+	k.mu.RUnlock()
+	if ok || !stale || k.opts.Algorithm == HS256 {
+		return found, ok
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := k.reload(ctx); err != nil {
+		return nil, false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	found, ok = k.keys[kid]
+	return found, ok
+}
+
+// parse decodes a stored key
+func (k *Keyring) parse(s *models.SigningKey) (*key, error) {
+	privatePEM, err := utils.DecryptString(k.opts.EncryptionKey, s.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key can't sign")
+	}
+	return &key{
+		kid:       s.KID,
+		alg:       s.Algorithm,
+		private:   signer,
+		public:    signer.Public(),
+		createdAt: s.CreatedAt,
+		retired:   s.RetiredAt != nil,
+	}, nil
+}
+
+// generate creates a new key pair for alg with its private key encrypted
+func generate(alg, encryptionKey string) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("keyring: unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.EncryptString(encryptionKey, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KID:        uuid.NewString(),
+		Algorithm:  alg,
+		PrivateKey: encrypted,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by driver: "smtp", "file" or "stdout".
+// The file driver writes one .eml file per message into dir.
+func New(driver string, smtpCfg SMTPConfig, dir string) (Mailer, error) {
+	switch driver {
+	case "smtp":
+		return NewSMTPMailer(smtpCfg), nil
+	case "file":
+		return NewFileMailer(dir, smtpCfg.From)
+	case "stdout", "":
+		return NewWriterMailer(os.Stdout, smtpCfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %s", driver)
+	}
+}
+
+// writeMessage renders msg in RFC 5322 format
+func writeMessage(w io.Writer, from string, msg Message) error {
+	_, err := fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=\"utf-8\"\r\n\r\n%s\r\n",
+		from, strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	return err
+}
@@ -17,6 +17,42 @@ const (
 
 var Passphrase = "jM/0qr%HKU&!G%MdivH#A-{oInY*Nv20"
 
+// API key scopes
+const (
+	ScopeMediaRead   = "media:read"   // download media
+	ScopeMediaWrite  = "media:write"  // upload media
+	ScopeHistoryRead = "history:read" // read download and upload history
+)
+
+// APIKeyScopes lists every scope an API key can be granted
+var APIKeyScopes = []string{ScopeMediaRead, ScopeMediaWrite, ScopeHistoryRead}
+
+// Built-in roles
+const (
+	RoleAdmin       = "admin"
+	RoleUser        = "user"
+	RoleContributor = "contributor"
+	RoleModerator   = "moderator"
+	RoleFinance     = "finance"
+	RoleSupport     = "support"
+)
+
+// Permissions checked by RequirePermission. Role to permission mapping lives in the database
+const (
+	PermMediaUpload      = "media:upload"      // upload new media
+	PermMediaApprove     = "media:approve"     // review and moderate uploaded media
+	PermCategoryManage   = "category:manage"   // create, update and delete media categories
+	PermPlanManage       = "plan:manage"       // create and update subscription plans
+	PermReportsRead      = "reports:read"      // read history and subscriptions of every user
+	PermUsersManage      = "users:manage"      // manage user accounts
+	PermRolesManage      = "roles:manage"      // manage roles and role assignments
+	PermUsersImpersonate = "users:impersonate" // act as another user to reproduce what they see
+	PermTagManage        = "tag:manage"        // rename, merge and ban tags
+	PermWatermarkManage  = "watermark:manage"  // manage watermark profiles and regenerate previews
+	PermDownloadsTrace   = "downloads:trace"   // trace leaked premium images back to their download
+	PermJobsManage       = "jobs:manage"       // inspect and retry background jobs
+)
+
 // Response is the type for response
 type Response struct {
 	Error   bool   `json:"error"`
@@ -27,16 +63,22 @@ type Response struct {
 
 // User holds the user info
 type JWT struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Username  string    `json:"username"`
-	Role      string    `json:"role"`
-	Issuer    string    `json:"iss"`
-	Audience  string    `json:"aud"`
-	ExpiresAt int64     `json:"exp"`
-	IssuedAt  int64     `json:"iat"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	Username       string    `json:"username"`
+	Role           string    `json:"role"`
+	Issuer         string    `json:"iss"`
+	Audience       string    `json:"aud"`
+	ExpiresAt      int64     `json:"exp"`
+	IssuedAt       int64     `json:"iat"`
+	SessionID      string    `json:"sid"`                       // refresh token family the access token was issued for
+	MFA            bool      `json:"mfa"`                       // session was authenticated with a second factor
+	APIKeyID       int       `json:"api_key_id,omitempty"`      // set when authenticated with an API key instead of a JWT
+	Scopes         []string  `json:"scopes,omitempty"`          // API key scopes; empty for JWT sessions
+	Perms          []string  `json:"perms"`                     // permissions resolved from the role when the token was issued
+	ImpersonatorID int       `json:"impersonator_id,omitempty"` // staff member acting as the user (act claim)
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type SubscriptionPlan struct {
@@ -47,7 +89,8 @@ type SubscriptionPlan struct {
 	Status        bool      `json:"status"`
 	Price         int       `json:"price"`
 	DownloadLimit int       `json:"download_limit"`
-	ExpiresAt     int       `json:"expires_at"` // stored as interval in DB
+	DownloadSizes []string  `json:"download_sizes"` // size tiers included in the plan, empty includes all
+	ExpiresAt     int       `json:"expires_at"`     // stored as interval in DB
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -78,6 +121,7 @@ type User struct {
 	TotalExpenses       float64       `json:"total_expenses"`
 	Address             string        `json:"address"`
 	SubscriptionID      *int          `json:"subscription_id"` // nullable FK
+	EmailVerifiedAt     *time.Time    `json:"email_verified_at"`
 	CreatedAt           time.Time     `json:"created_at"`
 	UpdatedAt           time.Time     `json:"updated_at"`
 	CurrentSubscription *Subscription `json:"current_subscription"`
@@ -109,13 +153,340 @@ type Media struct {
 	MediaCategory  MediaCategory `json:"media_category"`
 	UploaderID     int           `json:"uploader_id"` //foreign key of users table
 	UploaderName   string        `json:"uploader_name"`
-	FileType   string    `json:"file_type"`
-	FileExt    string    `json:"file_ext"`
-	FileName   string    `json:"file_name"`
-	FileSize   string    `json:"file_size"`
-	Resolution string    `json:"resolution"`
-	CreatedAt      time.Time     `json:"created_at"`
-	UpdatedAt      time.Time     `json:"updated_at"`
+	FileType       string        `json:"file_type"`
+	FileExt        string        `json:"file_ext"`
+	FileName       string        `json:"file_name"`
+	FileSize       string        `json:"file_size"`
+	Resolution     string        `json:"resolution"`
+	Width          int           `json:"width"`  // pixels, 0 when unknown
+	Height         int           `json:"height"` // pixels, 0 when unknown
+	Tags           []string      `json:"tags"`
+	Status         string        `json:"status"` // review state, see MediaStatus*
+	// Set while the media is rejected
+	RejectionReason string     `json:"rejection_reason,omitempty"`
+	RejectionNote   string     `json:"rejection_note,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	// Closest existing media when this one was uploaded as a likely near-duplicate
+	NearDuplicateOf *int `json:"near_duplicate_of,omitempty"`
+	// Progress of the previews and renditions made in the background, see MediaProcessing*
+	ProcessingStatus string            `json:"processing_status"`
+	ProcessingError  string            `json:"processing_error,omitempty"`
+	Metadata         *MediaMetadata    `json:"metadata,omitempty"`
+	Sizes            []*MediaRendition `json:"sizes,omitempty"`    // download sizes, see DownloadSizes
+	Previews         []*ImagePreview   `json:"previews,omitempty"` // signed preview URLs for responsive layouts
+	SHA256           string            `json:"-"`
+	PHash            uint64            `json:"-"` // perceptual hash, see utils.DHash
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// Review states of media. Only approved media are listed publicly.
+const (
+	MediaStatusDraft         = "draft"          // saved by the uploader, not submitted yet
+	MediaStatusPendingReview = "pending_review" // waiting in the moderation queue
+	MediaStatusApproved      = "approved"
+	MediaStatusRejected      = "rejected"
+)
+
+// Processing states of media, following their latest background job.
+// Media can only be approved once ready.
+const (
+	MediaProcessingQueued     = "queued"
+	MediaProcessingProcessing = "processing"
+	MediaProcessingReady      = "ready"
+	MediaProcessingFailed     = "failed" // the job failed its last attempt
+)
+
+// Kinds of background jobs
+const (
+	JobProcessMedia = "process_media" // previews, renditions, perceptual hash and metadata of an upload
+)
+
+// States of background jobs
+const (
+	JobStatusQueued  = "queued"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusDead    = "dead" // failed its last attempt, kept for inspection and manual retry
+)
+
+// Job is a unit of background work, retried with backoff until it succeeds or runs out of attempts
+type Job struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	MediaID     *int       `json:"media_id,omitempty"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	RunAt       time.Time  `json:"run_at"` // when a queued job is due
+	LockedAt    *time.Time `json:"locked_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// RejectionReason is an entry of the managed list of reasons for rejecting media
+type RejectionReason struct {
+	ID          int       `json:"id"`
+	Code        string    `json:"code"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// MediaStatusChange is an entry of the review history of a media item
+type MediaStatusChange struct {
+	ID         int       `json:"id"`
+	MediaID    int       `json:"media_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    *int      `json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	ReasonID   *int      `json:"rejection_reason_id,omitempty"`
+	Reason     string    `json:"rejection_reason,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// MediaMetadata is the camera and rights information embedded in an uploaded original
+// (EXIF, IPTC and XMP). GPS coordinates are private to the uploader and reviewers.
+type MediaMetadata struct {
+	MediaID      int        `json:"-"`
+	CameraMake   string     `json:"camera_make,omitempty"`
+	CameraModel  string     `json:"camera_model,omitempty"`
+	LensModel    string     `json:"lens_model,omitempty"`
+	ExposureTime string     `json:"exposure_time,omitempty"` // seconds, e.g. "1/250"
+	FNumber      float64    `json:"f_number,omitempty"`
+	ISO          int        `json:"iso,omitempty"`
+	FocalLength  float64    `json:"focal_length,omitempty"` // millimetres
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`  // camera local time
+	Orientation  int        `json:"orientation,omitempty"`  // EXIF orientation 1-8
+	GPSLatitude  *float64   `json:"gps_latitude,omitempty"`
+	GPSLongitude *float64   `json:"gps_longitude,omitempty"`
+	GPSAltitude  *float64   `json:"gps_altitude,omitempty"` // metres above sea level
+	Title        string     `json:"title,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	Keywords     []string   `json:"keywords,omitempty"`
+	Copyright    string     `json:"copyright,omitempty"`
+	Creator      string     `json:"creator,omitempty"`
+}
+
+// Public returns a copy without the location
+func (m *MediaMetadata) Public() *MediaMetadata {
+	c := *m
+	c.GPSLatitude, c.GPSLongitude, c.GPSAltitude = nil, nil, nil
+	return &c
+}
+
+// Download size tiers
+const (
+	DownloadSizeSmall    = "small"
+	DownloadSizeMedium   = "medium"
+	DownloadSizeLarge    = "large"
+	DownloadSizeOriginal = "original"
+)
+
+// DownloadSize is a download tier; renditions are scaled down so the longest edge is at most MaxEdge pixels
+type DownloadSize struct {
+	Name    string
+	MaxEdge int // 0 for the original
+}
+
+// DownloadSizes from the smallest up
+var DownloadSizes = []DownloadSize{
+	{DownloadSizeSmall, 640},
+	{DownloadSizeMedium, 1280},
+	{DownloadSizeLarge, 2560},
+	{DownloadSizeOriginal, 0},
+}
+
+// MediaRendition is a size a media item can be downloaded in
+type MediaRendition struct {
+	Size   string `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes,omitempty"` // unknown until the rendition is first generated
+}
+
+// Watermark profile kinds
+const (
+	WatermarkKindText = "text"
+	WatermarkKindLogo = "logo"
+)
+
+// WatermarkProfile is how watermarked previews are branded. A contributor's profile wins
+// over the category's, the default profile applies when neither has one.
+type WatermarkProfile struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"` // text or logo
+	Text      string    `json:"text"`
+	LogoPath  string    `json:"-"`
+	HasLogo   bool      `json:"has_logo"`
+	Placement string    `json:"placement"` // tile, center, top_left, top_right, bottom_left or bottom_right
+	Scale     float64   `json:"scale"`     // text height or logo width relative to the image width
+	Opacity   float64   `json:"opacity"`   // 0-1
+	Color     string    `json:"color"`     // auto, light or dark; text only
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WatermarkRegeneration is the progress of redrawing the watermarked previews of a profile
+type WatermarkRegeneration struct {
+	ProfileID  int        `json:"profile_id"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Failed     int        `json:"failed"`
+	Running    bool       `json:"running"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ImagePreview is a signed URL of a preview scaled to a width
+type ImagePreview struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// SimilarMedia is an existing media item that looks like another one
+type SimilarMedia struct {
+	ID       int    `json:"id"`
+	Title    string `json:"media_title"`
+	Distance int    `json:"distance"` // Hamming distance between the perceptual hashes
+}
+
+// DuplicateMember is a media item in a duplicate cluster
+type DuplicateMember struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"media_title"`
+	UploaderID   int       `json:"uploader_id"`
+	UploaderName string    `json:"uploader_name"`
+	Status       string    `json:"status"`
+	MediaURL     string    `json:"media_url"`
+	MediaUUID    string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DuplicateCluster is a group of media whose perceptual hashes are within the duplicate
+// distance of at least one other member
+type DuplicateCluster struct {
+	Media       []*DuplicateMember `json:"media"`
+	MaxDistance int                `json:"max_distance"` // largest distance between linked members
+}
+
+// ReviewedMedia is a media item whose review state was changed, as reported to its uploader
+type ReviewedMedia struct {
+	ID         int
+	Title      string
+	UploaderID int
+}
+
+// Sort orders of media listings
+const (
+	MediaSortNewest    = "newest"
+	MediaSortOldest    = "oldest"
+	MediaSortDownloads = "downloads" // most downloaded first
+	MediaSortEarnings  = "earnings"  // top earning first
+)
+
+// MediaFilter narrows a media listing. Zero values don't filter.
+type MediaFilter struct {
+	CategoryID  int
+	LicenseType *int // 0 = free, 1 = premium
+	FileType    string
+	UploaderID  int
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	Orientation string // landscape, portrait or square
+	Resolution  string // resolution class, see ResolutionClasses
+	Status      string // review state, empty for any
+	Flagged     bool   // only media flagged as near-duplicates
+	Tag         string // tag slug
+	From        *time.Time
+	To          *time.Time
+}
+
+// ResolutionClass groups media by the length of their longest edge in pixels
+type ResolutionClass struct {
+	Name    string
+	MinEdge int
+}
+
+// ResolutionClasses from the smallest up. A class covers [MinEdge, next class's MinEdge).
+var ResolutionClasses = []ResolutionClass{
+	{"sd", 1},
+	{"hd", 1280},
+	{"full_hd", 1920},
+	{"4k", 3840},
+	{"8k", 7680},
+}
+
+// FacetCount is the number of results that have a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// MediaFacets are the result counts per filter value of a media listing or search.
+// Each facet is counted with every active filter applied except its own, so the
+// counts show what selecting another value of that facet would return.
+type MediaFacets struct {
+	Categories   []FacetCount `json:"categories"`
+	Licenses     []FacetCount `json:"licenses"`
+	FileTypes    []FacetCount `json:"file_types"`
+	Orientations []FacetCount `json:"orientations"`
+	Resolutions  []FacetCount `json:"resolutions"`
+}
+
+// Tag is a keyword attached to media
+type Tag struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Slug       string    `json:"slug"`
+	MediaCount int       `json:"media_count"`
+	Banned     bool      `json:"banned"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// MediaSearchHit is a media item matching a full-text search.
+// The highlights are HTML escaped with the matched words wrapped in <mark> tags.
+type MediaSearchHit struct {
+	Media
+	Rank           float32 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"` // matching excerpt of the description
+}
+
+// Suggestion kinds returned by the search autocomplete
+const (
+	SuggestionMedia       = "media"
+	SuggestionTag         = "tag"
+	SuggestionCategory    = "category"
+	SuggestionContributor = "contributor"
+)
+
+// Suggestion is a type-ahead match for a search box
+type Suggestion struct {
+	Type       string `json:"type"` // media, tag, category or contributor
+	ID         int    `json:"id"`
+	Text       string `json:"text"`
+	Slug       string `json:"slug,omitempty"` // tags only
+	Popularity int    `json:"popularity"`     // downloads, or media count for tags
+}
+
+// MediaCursor is a position in a sorted media listing: the sort value and id of an item
+type MediaCursor struct {
+	Value  string `json:"v"`
+	ID     int    `json:"id"`
+	Before bool   `json:"b,omitempty"` // page ends before the item instead of starting after it
 }
 
 type UploadHistory struct {
@@ -132,15 +503,151 @@ type UploadHistory struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 type DownloadHistory struct {
-	ID           int       `json:"id"`
-	MediaUUID    string    `json:"media_id"`
-	UserID       int       `json:"user_id"` //uploader
-	FileType     string    `json:"file_type"`
-	FileExt      string    `json:"file_ext"`
-	FileName     string    `json:"file_name"`
-	FileSize     string    `json:"file_size"`
-	Resolution   string    `json:"resolution"`
-	DownloadedAt time.Time `json:"downloaded_at"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID         int    `json:"id"`
+	MediaUUID  string `json:"media_id"`
+	UserID     *int   `json:"user_id"` // nil once the account is deleted
+	FileType   string `json:"file_type"`
+	FileExt    string `json:"file_ext"`
+	FileName   string `json:"file_name"`
+	FileSize   string `json:"file_size"`
+	Resolution string `json:"resolution"`
+	Size       string `json:"size"` // download size tier, see DownloadSizes
+	// Fingerprinted is set when the delivered file carries the record's id invisibly
+	Fingerprinted bool      `json:"fingerprinted"`
+	DownloadedAt  time.Time `json:"downloaded_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RefreshToken holds a hashed refresh token. Tokens issued for the same login
+// share a FamilyID; rotating a token marks it used and issues a new one in the family.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	TokenHash string     `json:"-"`
+	MFA       bool       `json:"mfa"` // login completed a second factor
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// PasswordReset holds a hashed, single-use password reset token
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// UserMFA holds a user's TOTP enrollment. The secret is stored encrypted.
+// EnabledAt is nil while enrollment is pending confirmation.
+type UserMFA struct {
+	UserID       int        `json:"user_id"`
+	Secret       string     `json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// APIKey is a personal access key for programmatic access. Only the hash of the key is stored.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Label      string     `json:"label"`
+	Prefix     string     `json:"prefix"` // first characters of the key, shown to identify it
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Role groups a set of permissions that can be assigned to users
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Permission is a single grantable action such as "media:approve"
+type Permission struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// LoginAttempt tracks failed sign-in attempts for a throttling key (an account or a client IP)
+type LoginAttempt struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until"`
+}
+
+// AuditLog records a security relevant action
+type AuditLog struct {
+	ID         int            `json:"id"`
+	ActorID    *int           `json:"actor_id"` // user performing the action, nil for the system
+	Action     string         `json:"action"`
+	TargetType string         `json:"target_type"`
+	TargetID   string         `json:"target_id"`
+	IPAddress  string         `json:"ip_address"`
+	Details    map[string]any `json:"details"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// SigningKey is a key pair used to sign access tokens. The private key is stored encrypted.
+type SigningKey struct {
+	KID        string     `json:"kid"`
+	Algorithm  string     `json:"alg"`        // RS256 or EdDSA
+	PrivateKey string     `json:"-"`          // encrypted PKCS#8 PEM
+	PublicKey  string     `json:"public_key"` // PKIX PEM
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at"` // no longer used for signing
+	ExpiresAt  *time.Time `json:"expires_at"` // no longer accepted for verification
+}
+
+// Session is a signed-in device. Its ID is the refresh token family and the sid claim of access tokens
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	Current    bool       `json:"current"` // the session the request was made with
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"` // provider's user id
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCFlow is a pending sign-in or account link with an external provider, identified by its state
+type OIDCFlow struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string // PKCE verifier
+	LinkUserID   *int   // set when an existing user is linking the provider
+	ExpiresAt    time.Time
 }
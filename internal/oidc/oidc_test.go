@@ -0,0 +1,332 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "photostock-test"
+	testKeyID    = "test-key"
+)
+
+// grant is an authorization code handed out by the fake issuer
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+// fakeIssuer is a minimal OpenID provider: discovery, JWKS and a token endpoint enforcing PKCE
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+	// sign builds the id_token of a redeemed code, tests change it to send bad tokens
+	sign func(claims jwt.MapClaims) string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, grants: map[string]grant{}}
+	f.sign = func(claims jwt.MapClaims) string { return signToken(t, f.key, claims) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                f.URL,
+			"authorization_endpoint":                f.URL + "/authorize",
+			"token_endpoint":                        f.URL + "/token",
+			"jwks_uri":                              f.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := f.key.PublicKey
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+		f.mu.Lock()
+		g, ok := f.grants[r.PostForm.Get("code")]
+		delete(f.grants, r.PostForm.Get("code"))
+		f.mu.Unlock()
+		if !ok || pkceChallenge(r.PostForm.Get("code_verifier")) != g.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token": f.sign(jwt.MapClaims{
+				"iss":            f.URL,
+				"sub":            "user-1",
+				"aud":            testClientID,
+				"iat":            now.Unix(),
+				"exp":            now.Add(time.Hour).Unix(),
+				"nonce":          g.nonce,
+				"email":          "jane@example.com",
+				"email_verified": "true",
+				"name":           "Jane",
+			}),
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// authorize plays the user signing in at the provider: it checks the sign-in URL the
+// way a provider would and returns the code sent back with the state to the callback
+func (f *fakeIssuer) authorize(t *testing.T, authURL, state string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("state") != state {
+		t.Fatalf("state = %q, want %q", q.Get("state"), state)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("sign-in URL lacks an S256 PKCE challenge: %s", authURL)
+	}
+	if q.Get("nonce") == "" {
+		t.Fatalf("sign-in URL lacks a nonce: %s", authURL)
+	}
+	code := "code-" + strings.TrimPrefix(state, "state-")
+	f.mu.Lock()
+	f.grants[code] = grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	f.mu.Unlock()
+	return code
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, f *fakeIssuer) *Provider {
+	t.Helper()
+	p, err := NewProvider(context.Background(), ProviderConfig{
+		Name:     "fake",
+		Issuer:   f.URL,
+		ClientID: testClientID,
+		Scopes:   []string{"openid", "email"},
+	}, "http://localhost/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestProvider(t, f)
+	verifier := NewVerifier()
+
+	u, err := url.Parse(p.AuthCodeURL("state-1", "nonce-1", verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "http://localhost/callback",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge_method": "S256",
+		"code_challenge":        pkceChallenge(verifier),
+		"scope":                 "openid email",
+	}
+	for name, v := range want {
+		if got := q.Get(name); got != v {
+			t.Errorf("%s = %q, want %q", name, got, v)
+		}
+	}
+	if u.Scheme+"://"+u.Host+u.Path != f.URL+"/authorize" {
+		t.Errorf("sign-in URL = %s, want the discovered authorization endpoint", u)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestProvider(t, f)
+	verifier := NewVerifier()
+
+	code := f.authorize(t, p.AuthCodeURL("state-1", "nonce-1", verifier), "state-1")
+	identity, err := p.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "fake", Subject: "user-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		verifier func(verifier string) string                // code verifier sent to the token endpoint
+		nonce    string                                      // nonce expected by the callback
+		sign     func(f *fakeIssuer, c jwt.MapClaims) string // id_token returned by the provider
+	}{
+		{
+			name:     "wrong PKCE verifier",
+			verifier: func(string) string { return NewVerifier() },
+		},
+		{
+			name:  "mismatched nonce",
+			nonce: "nonce-of-another-flow",
+		},
+		{
+			name: "bad signature",
+			sign: func(f *fakeIssuer, c jwt.MapClaims) string { return signToken(t, otherKey, c) },
+		},
+		{
+			name: "wrong audience",
+			sign: func(f *fakeIssuer, c jwt.MapClaims) string {
+				c["aud"] = "another-client"
+				return signToken(t, f.key, c)
+			},
+		},
+		{
+			name: "wrong issuer",
+			sign: func(f *fakeIssuer, c jwt.MapClaims) string {
+				c["iss"] = "https://issuer.example.com"
+				return signToken(t, f.key, c)
+			},
+		},
+		{
+			name: "expired token",
+			sign: func(f *fakeIssuer, c jwt.MapClaims) string {
+				c["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+				return signToken(t, f.key, c)
+			},
+		},
+		{
+			name: "unsigned token",
+			sign: func(f *fakeIssuer, c jwt.MapClaims) string {
+				signed, err := jwt.NewWithClaims(jwt.SigningMethodNone, c).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIssuer(t)
+			if tt.sign != nil {
+				f.sign = func(c jwt.MapClaims) string { return tt.sign(f, c) }
+			}
+			p := newTestProvider(t, f)
+			verifier := NewVerifier()
+			code := f.authorize(t, p.AuthCodeURL("state-1", "nonce-1", verifier), "state-1")
+
+			sent := verifier
+			if tt.verifier != nil {
+				sent = tt.verifier(verifier)
+			}
+			nonce := "nonce-1"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			if identity, err := p.Exchange(context.Background(), code, sent, nonce); err == nil {
+				t.Fatalf("Exchange accepted the sign-in: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsReusedCode(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestProvider(t, f)
+	verifier := NewVerifier()
+
+	code := f.authorize(t, p.AuthCodeURL("state-1", "nonce-1", verifier), "state-1")
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce-1"); err == nil {
+		t.Fatal("Exchange accepted a code that was already redeemed")
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	f := newFakeIssuer(t)
+	tests := []struct {
+		name string
+		cfg  ProviderConfig
+	}{
+		{"invalid name", ProviderConfig{Name: "Fake Provider", Issuer: f.URL, ClientID: testClientID}},
+		{"missing client id", ProviderConfig{Name: "fake", Issuer: f.URL}},
+		{"unreachable issuer", ProviderConfig{Name: "fake", Issuer: f.URL + "/missing", ClientID: testClientID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProvider(context.Background(), tt.cfg, "http://localhost/callback"); err == nil {
+				t.Fatal("NewProvider accepted the configuration")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ErrAPIKeyNotFound is returned when no active API key matches
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// ============================== APIKey Repository ==============================
+type APIKeyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepo(db *pgxpool.Pool) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+// Create inserts a new API key
+func (r *APIKeyRepo) Create(ctx context.Context, k *models.APIKey) error {
+	query := `
+	INSERT INTO api_keys (user_id, label, prefix, key_hash, scopes, expires_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query,
+		k.UserID, k.Label, k.Prefix, k.KeyHash, k.Scopes, k.ExpiresAt, now, now,
+	).Scan(&k.ID)
+	k.CreatedAt = now
+	k.UpdatedAt = now
+	return err
+}
+
+// GetAllByUserID returns every key of a user, newest first, including revoked ones
+func (r *APIKeyRepo) GetAllByUserID(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	query := `
+	SELECT id, user_id, label, prefix, key_hash, scopes, last_used_at, expires_at, revoked_at, created_at, updated_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		var k models.APIKey
+		if err := rows.Scan(
+			&k.ID, &k.UserID, &k.Label, &k.Prefix, &k.KeyHash, &k.Scopes,
+			&k.LastUsedAt, &k.ExpiresAt, &k.RevokedAt, &k.CreatedAt, &k.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	return keys, rows.Err()
+}
+
+// Authenticate looks up an active (not revoked, not expired) key by hash together with its owner.
+// It also records the key as used; last_used_at is refreshed at most once a minute.
+func (r *APIKeyRepo) Authenticate(ctx context.Context, keyHash string) (*models.APIKey, *models.User, error) {
+	query := `
+	SELECT k.id, k.user_id, k.label, k.prefix, k.scopes, k.last_used_at, k.expires_at, k.created_at, k.updated_at,
+		u.id, u.name, u.username, u.email, u.role, u.status
+	FROM api_keys k
+	JOIN users u ON u.id = k.user_id
+	WHERE k.key_hash = $1
+		AND k.revoked_at IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > $2)`
+	var (
+		k models.APIKey
+		u models.User
+	)
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query, keyHash, now).Scan(
+		&k.ID, &k.UserID, &k.Label, &k.Prefix, &k.Scopes, &k.LastUsedAt, &k.ExpiresAt, &k.CreatedAt, &k.UpdatedAt,
+		&u.ID, &u.Name, &u.Username, &u.Email, &u.Role, &u.Status,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute {
+		if _, err := r.db.Exec(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, k.ID, now); err != nil {
+			return nil, nil, err
+		}
+		k.LastUsedAt = &now
+	}
+	return &k, &u, nil
+}
+
+// Update changes the label and scopes of a key owned by userID
+func (r *APIKeyRepo) Update(ctx context.Context, k *models.APIKey) error {
+	query := `
+	UPDATE api_keys
+	SET label = $3, scopes = $4, updated_at = $5
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	tag, err := r.db.Exec(ctx, query, k.ID, k.UserID, k.Label, k.Scopes, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Revoke disables a key owned by userID
+func (r *APIKeyRepo) Revoke(ctx context.Context, id, userID int) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = $3, updated_at = $3
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	tag, err := r.db.Exec(ctx, query, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
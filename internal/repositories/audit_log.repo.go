@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ============================== AuditLog Repository ==============================
+type AuditLogRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditLogRepo(db *pgxpool.Pool) *AuditLogRepo {
+	return &AuditLogRepo{db: db}
+}
+
+// Create inserts a new audit entry
+func (r *AuditLogRepo) Create(ctx context.Context, l *models.AuditLog) error {
+	query := `
+	INSERT INTO audit_logs (actor_id, action, target_type, target_id, ip_address, details, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+	if l.Details == nil {
+		l.Details = map[string]any{}
+	}
+	l.CreatedAt = time.Now()
+	return r.db.QueryRow(ctx, query,
+		l.ActorID, l.Action, l.TargetType, l.TargetID, l.IPAddress, l.Details, l.CreatedAt,
+	).Scan(&l.ID)
+}
+
+// GetRecent returns the newest audit entries, newest first. actionPrefix limits the
+// entries to actions starting with it (e.g. "impersonation."), actorID to one actor when not 0.
+func (r *AuditLogRepo) GetRecent(ctx context.Context, actionPrefix string, actorID, limit int) ([]*models.AuditLog, error) {
+	query := `
+	SELECT id, actor_id, action, target_type, target_id, ip_address, details, created_at
+	FROM audit_logs
+	WHERE action LIKE $1 || '%' AND ($2 = 0 OR actor_id = $2)
+	ORDER BY created_at DESC, id DESC
+	LIMIT $3`
+	rows, err := r.db.Query(ctx, query, actionPrefix, actorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*models.AuditLog
+	for rows.Next() {
+		var l models.AuditLog
+		if err := rows.Scan(&l.ID, &l.ActorID, &l.Action, &l.TargetType, &l.TargetID, &l.IPAddress, &l.Details, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+	return logs, rows.Err()
+}
@@ -16,32 +16,82 @@ func NewDownloadHistoryRepo(db *pgxpool.Pool) *DownloadHistoryRepo {
 	return &DownloadHistoryRepo{db: db}
 }
 
-// Create inserts a new download history record
-func (r *DownloadHistoryRepo) Create(ctx context.Context, h *models.DownloadHistory) error {
-	query := `
+// NextID reserves the id of a download history record, for downloads that embed it
+// before they are recorded
+func (r *DownloadHistoryRepo) NextID(ctx context.Context) (int, error) {
+	var id int
+	err := r.db.QueryRow(ctx, `SELECT nextval(pg_get_serial_sequence('download_history', 'id'))`).Scan(&id)
+	return id, err
+}
+
+// insertDownloadQuery inserts a download history record, with the id reserved by NextID when set
+const insertDownloadQuery = `
 	INSERT INTO download_history (
-		media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, downloaded_at
+		id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, size,
+		fingerprinted, downloaded_at
+	)
+	VALUES (
+		COALESCE(NULLIF($10, 0), nextval(pg_get_serial_sequence('download_history', 'id'))),
+		$1, $2, $3, $4, $5, $6, $7, COALESCE(NULLIF($8, ''), 'original'), $11, $9
 	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	RETURNING id`
-	return r.db.QueryRow(ctx, query,
+
+func insertDownloadArgs(h *models.DownloadHistory) []any {
+	return []any{
 		h.MediaUUID, h.UserID,
-		h.FileType, h.FileExt, h.FileName, h.FileSize, h.Resolution,
-		h.DownloadedAt,
-	).Scan(&h.ID)
+		h.FileType, h.FileExt, h.FileName, h.FileSize, h.Resolution, h.Size,
+		h.DownloadedAt, h.ID, h.Fingerprinted,
+	}
+}
+
+// Create inserts a new download history record, with the id reserved by NextID when h.ID is set
+func (r *DownloadHistoryRepo) Create(ctx context.Context, h *models.DownloadHistory) error {
+	return r.db.QueryRow(ctx, insertDownloadQuery, insertDownloadArgs(h)...).Scan(&h.ID)
+}
+
+// Record stores a download in one transaction: its history record, the download counters
+// of the media and its category and, for premium media, the downloader's subscription quota.
+// Either all of them are saved or none, the file must only be sent once Record succeeds.
+func (r *DownloadHistoryRepo) Record(ctx context.Context, h *models.DownloadHistory, mediaID, categoryID int, premium bool) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	if premium && h.UserID != nil {
+		if _, err := tx.Exec(ctx, `UPDATE subscriptions SET total_downloads = total_downloads + 1 WHERE user_id = $1`, *h.UserID); err != nil {
+			return err
+		}
+	}
+	if err := tx.QueryRow(ctx, insertDownloadQuery, insertDownloadArgs(h)...).Scan(&h.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+	UPDATE media_categories SET total_downloads = total_downloads + 1, updated_at = $2
+	WHERE id = $1`, categoryID, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+	UPDATE medias SET total_downloads = total_downloads + 1, updated_at = $2
+	WHERE id = $1`, mediaID, now); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 // GetByID retrieves a download history by its ID
 func (r *DownloadHistoryRepo) GetByID(ctx context.Context, id int) (*models.DownloadHistory, error) {
 	query := `
-	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution,
-	       downloaded_at, created_at, updated_at
+	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, size,
+	       fingerprinted, downloaded_at, created_at, updated_at
 	FROM download_history
 	WHERE id = $1`
 	h := &models.DownloadHistory{}
 	err := r.db.QueryRow(ctx, query, id).Scan(
-		&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution,
-		&h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
+		&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution, &h.Size,
+		&h.Fingerprinted, &h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
 	)
 	return h, err
 }
@@ -72,8 +122,8 @@ func (r *DownloadHistoryRepo) Delete(ctx context.Context, id int) error {
 // GetAll returns all download history records
 func (r *DownloadHistoryRepo) GetAll(ctx context.Context) ([]*models.DownloadHistory, error) {
 	query := `
-	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution,
-	       downloaded_at, created_at, updated_at
+	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, size,
+	       fingerprinted, downloaded_at, created_at, updated_at
 	FROM download_history`
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
@@ -85,8 +135,8 @@ func (r *DownloadHistoryRepo) GetAll(ctx context.Context) ([]*models.DownloadHis
 	for rows.Next() {
 		var h models.DownloadHistory
 		if err := rows.Scan(
-			&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution,
-			&h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
+			&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution, &h.Size,
+			&h.Fingerprinted, &h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -98,8 +148,8 @@ func (r *DownloadHistoryRepo) GetAll(ctx context.Context) ([]*models.DownloadHis
 // GetAllByUserID returns all download history records for a specific user
 func (r *DownloadHistoryRepo) GetAllByUserID(ctx context.Context, userID int) ([]*models.DownloadHistory, error) {
 	query := `
-	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution,
-	       downloaded_at, created_at, updated_at
+	SELECT id, media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, size,
+	       fingerprinted, downloaded_at, created_at, updated_at
 	FROM download_history
 	WHERE user_id = $1`
 	rows, err := r.db.Query(ctx, query, userID)
@@ -112,8 +162,8 @@ func (r *DownloadHistoryRepo) GetAllByUserID(ctx context.Context, userID int) ([
 	for rows.Next() {
 		var h models.DownloadHistory
 		if err := rows.Scan(
-			&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution,
-			&h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
+			&h.ID, &h.MediaUUID, &h.UserID, &h.FileType, &h.FileExt, &h.FileName, &h.FileSize, &h.Resolution, &h.Size,
+			&h.Fingerprinted, &h.DownloadedAt, &h.CreatedAt, &h.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
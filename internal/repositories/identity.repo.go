@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrIdentityNotFound is returned when no linked identity matches
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityLinked is returned when the provider account is already linked to a user,
+	// or the user already has an account of that provider linked
+	ErrIdentityLinked = errors.New("identity already linked")
+	// ErrOIDCFlowInvalid is returned for unknown, expired or already used flows and login codes
+	ErrOIDCFlowInvalid = errors.New("oidc flow invalid or expired")
+)
+
+// ============================== Identity Repository ==============================
+type IdentityRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepo(db *pgxpool.Pool) *IdentityRepo {
+	return &IdentityRepo{db: db}
+}
+
+// GetByProviderSubject returns the identity of a provider account
+func (r *IdentityRepo) GetByProviderSubject(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	query := `
+	SELECT id, user_id, provider, subject, email, created_at, last_login_at
+	FROM user_identities
+	WHERE provider = $1 AND subject = $2`
+	i := &models.UserIdentity{}
+	err := r.db.QueryRow(ctx, query, provider, subject).Scan(
+		&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	return i, err
+}
+
+// GetAllByUserID returns the identities linked to a user
+func (r *IdentityRepo) GetAllByUserID(ctx context.Context, userID int) ([]*models.UserIdentity, error) {
+	query := `
+	SELECT id, user_id, provider, subject, email, created_at, last_login_at
+	FROM user_identities
+	WHERE user_id = $1
+	ORDER BY provider`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*models.UserIdentity
+	for rows.Next() {
+		var i models.UserIdentity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, &i)
+	}
+	return identities, rows.Err()
+}
+
+// Create links a provider account to a user
+func (r *IdentityRepo) Create(ctx context.Context, i *models.UserIdentity) error {
+	query := `
+	INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id`
+	i.CreatedAt = time.Now()
+	err := r.db.QueryRow(ctx, query, i.UserID, i.Provider, i.Subject, i.Email, i.CreatedAt).Scan(&i.ID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// TouchLogin records a sign-in with the identity
+func (r *IdentityRepo) TouchLogin(ctx context.Context, id int) error {
+	_, err := r.db.Exec(ctx, `UPDATE user_identities SET last_login_at = $2 WHERE id = $1`, id, time.Now())
+	return err
+}
+
+// Delete unlinks an identity of the user
+func (r *IdentityRepo) Delete(ctx context.Context, id, userID int) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err == nil && tag.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return err
+}
+
+// CreateFlow stores a pending sign-in flow
+func (r *IdentityRepo) CreateFlow(ctx context.Context, f *models.OIDCFlow) error {
+	query := `
+	INSERT INTO oidc_flows (state_hash, provider, nonce, code_verifier, link_user_id, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := r.db.Exec(ctx, query, f.StateHash, f.Provider, f.Nonce, f.CodeVerifier, f.LinkUserID, f.ExpiresAt, time.Now())
+	return err
+}
+
+// ConsumeFlow removes and returns a pending flow so its state can only be used once
+func (r *IdentityRepo) ConsumeFlow(ctx context.Context, stateHash, provider string) (*models.OIDCFlow, error) {
+	query := `
+	DELETE FROM oidc_flows
+	WHERE state_hash = $1
+	RETURNING state_hash, provider, nonce, code_verifier, link_user_id, expires_at`
+	f := &models.OIDCFlow{}
+	err := r.db.QueryRow(ctx, query, stateHash).Scan(
+		&f.StateHash, &f.Provider, &f.Nonce, &f.CodeVerifier, &f.LinkUserID, &f.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOIDCFlowInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.Provider != provider || time.Now().After(f.ExpiresAt) {
+		return nil, ErrOIDCFlowInvalid
+	}
+	return f, nil
+}
+
+// CreateLoginCode stores a single use code that signs userID in
+func (r *IdentityRepo) CreateLoginCode(ctx context.Context, codeHash string, userID int, expiresAt time.Time) error {
+	query := `
+	INSERT INTO oidc_login_codes (code_hash, user_id, expires_at, created_at)
+	VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(ctx, query, codeHash, userID, expiresAt, time.Now())
+	return err
+}
+
+// ConsumeLoginCode redeems a login code and returns the user it signs in
+func (r *IdentityRepo) ConsumeLoginCode(ctx context.Context, codeHash string) (int, error) {
+	query := `
+	DELETE FROM oidc_login_codes
+	WHERE code_hash = $1
+	RETURNING user_id, expires_at`
+	var userID int
+	var expiresAt time.Time
+	err := r.db.QueryRow(ctx, query, codeHash).Scan(&userID, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrOIDCFlowInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().After(expiresAt) {
+		return 0, ErrOIDCFlowInvalid
+	}
+	return userID, nil
+}
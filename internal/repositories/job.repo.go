@@ -0,0 +1,254 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrJobNotFound is returned when no job matches
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotDead is returned when retrying a job that has not failed its last attempt
+	ErrJobNotDead = errors.New("only dead jobs can be retried")
+)
+
+// ============================== Job Repository ==============================
+type JobRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewJobRepo(db *pgxpool.Pool) *JobRepo {
+	return &JobRepo{db: db}
+}
+
+const jobColumns = `id, kind, media_id, status, attempts, max_attempts, run_at, locked_at, last_error, created_at, updated_at, finished_at`
+
+func scanJob(row pgx.Row) (*models.Job, error) {
+	j := &models.Job{}
+	err := row.Scan(&j.ID, &j.Kind, &j.MediaID, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&j.LockedAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return j, err
+}
+
+// setMediaProcessing mirrors the state of a job on the media it works on
+func setMediaProcessing(ctx context.Context, tx pgx.Tx, job *models.Job, status, cause string) error {
+	if job.MediaID == nil {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `UPDATE medias SET processing_status = $2, processing_error = $3 WHERE id = $1`,
+		*job.MediaID, status, cause)
+	return err
+}
+
+// enqueueJob adds a job due now within tx. Its media, if any, is marked queued.
+func enqueueJob(ctx context.Context, tx pgx.Tx, kind string, mediaID *int, maxAttempts int) (*models.Job, error) {
+	now := time.Now()
+	job, err := scanJob(tx.QueryRow(ctx, `
+	INSERT INTO jobs (kind, media_id, max_attempts, run_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $4, $4)
+	RETURNING `+jobColumns, kind, mediaID, maxAttempts, now))
+	if err != nil {
+		return nil, err
+	}
+	if err := setMediaProcessing(ctx, tx, job, models.MediaProcessingQueued, ""); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Enqueue adds a job due now. Its media, if any, is marked queued.
+func (r *JobRepo) Enqueue(ctx context.Context, kind string, mediaID *int, maxAttempts int) (*models.Job, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	job, err := enqueueJob(ctx, tx, kind, mediaID, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	return job, tx.Commit(ctx)
+}
+
+// Claim locks the next due job for a worker and counts an attempt. Running jobs whose
+// attempt started before staleBefore belong to a worker that died and are due again.
+// Concurrent workers skip each other's rows. It returns nil when no job is due.
+func (r *JobRepo) Claim(ctx context.Context, staleBefore time.Time) (*models.Job, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	job, err := scanJob(tx.QueryRow(ctx, `
+	UPDATE jobs j
+	SET status = 'running', attempts = j.attempts + 1, locked_at = $1, updated_at = $1
+	FROM (
+		SELECT id AS next_id FROM jobs
+		WHERE (status = 'queued' AND run_at <= $1) OR (status = 'running' AND locked_at < $2)
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	) next
+	WHERE j.id = next.next_id
+	RETURNING `+jobColumns, now, staleBefore))
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := setMediaProcessing(ctx, tx, job, models.MediaProcessingProcessing, ""); err != nil {
+		return nil, err
+	}
+	return job, tx.Commit(ctx)
+}
+
+// finish moves a running job out of the attempt it was claimed for. Jobs taken over by
+// another worker since, after their lease expired, are left alone.
+func (r *JobRepo) finish(ctx context.Context, job *models.Job, status, cause string, runAt time.Time, mediaStatus string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	tag, err := tx.Exec(ctx, `
+	UPDATE jobs
+	SET status = $3, last_error = $4, run_at = $5, locked_at = NULL, updated_at = $6,
+		finished_at = CASE WHEN $3 = 'queued' THEN NULL ELSE $6 END
+	WHERE id = $1 AND attempts = $2 AND status = 'running'`,
+		job.ID, job.Attempts, status, cause, runAt, now)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	if err := setMediaProcessing(ctx, tx, job, mediaStatus, cause); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Complete marks a job done and its media ready
+func (r *JobRepo) Complete(ctx context.Context, job *models.Job) error {
+	return r.finish(ctx, job, models.JobStatusDone, "", job.RunAt, models.MediaProcessingReady)
+}
+
+// Retry queues a job again at runAt with the error of the failed attempt
+func (r *JobRepo) Retry(ctx context.Context, job *models.Job, cause string, runAt time.Time) error {
+	return r.finish(ctx, job, models.JobStatusQueued, cause, runAt, models.MediaProcessingQueued)
+}
+
+// Bury moves a job to the dead-letter state with the error of its last attempt and marks its media failed
+func (r *JobRepo) Bury(ctx context.Context, job *models.Job, cause string) error {
+	return r.finish(ctx, job, models.JobStatusDead, cause, job.RunAt, models.MediaProcessingFailed)
+}
+
+// Requeue gives a dead job a new round of attempts, due now
+func (r *JobRepo) Requeue(ctx context.Context, id int64) (*models.Job, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx, `SELECT status FROM jobs WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != models.JobStatusDead {
+		return nil, ErrJobNotDead
+	}
+	now := time.Now()
+	job, err := scanJob(tx.QueryRow(ctx, `
+	UPDATE jobs
+	SET status = 'queued', attempts = 0, run_at = $2, updated_at = $2, finished_at = NULL
+	WHERE id = $1
+	RETURNING `+jobColumns, id, now))
+	if err != nil {
+		return nil, err
+	}
+	if err := setMediaProcessing(ctx, tx, job, models.MediaProcessingQueued, ""); err != nil {
+		return nil, err
+	}
+	return job, tx.Commit(ctx)
+}
+
+// GetByID returns a job by id
+func (r *JobRepo) GetByID(ctx context.Context, id int64) (*models.Job, error) {
+	return scanJob(r.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+}
+
+// GetLatestForMedia returns the most recent job of a media item, nil when it has none
+func (r *JobRepo) GetLatestForMedia(ctx context.Context, mediaID int) (*models.Job, error) {
+	job, err := scanJob(r.db.QueryRow(ctx, `
+	SELECT `+jobColumns+` FROM jobs WHERE media_id = $1 ORDER BY id DESC LIMIT 1`, mediaID))
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// List returns at most limit jobs, most recently updated first, only those in status
+// unless it is empty
+func (r *JobRepo) List(ctx context.Context, status string, limit, offset int) ([]*models.Job, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT `+jobColumns+`
+	FROM jobs
+	WHERE $1 = '' OR status = $1
+	ORDER BY updated_at DESC, id DESC
+	LIMIT $2 OFFSET $3`, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// CountByStatus returns the number of jobs in each state
+func (r *JobRepo) CountByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{
+		models.JobStatusQueued: 0, models.JobStatusRunning: 0, models.JobStatusDone: 0, models.JobStatusDead: 0,
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ============================== LoginAttempt Repository ==============================
+// LoginAttemptRepo is the Postgres backed throttle.Store, shared by every API instance
+type LoginAttemptRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginAttemptRepo(db *pgxpool.Pool) *LoginAttemptRepo {
+	return &LoginAttemptRepo{db: db}
+}
+
+// Get returns the counters of key, or nil when there are none
+func (r *LoginAttemptRepo) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	query := `
+	SELECT key, failures, last_failure_at, blocked_until
+	FROM login_attempts
+	WHERE key = $1`
+	a := &models.LoginAttempt{}
+	err := r.db.QueryRow(ctx, query, key).Scan(&a.Key, &a.Failures, &a.LastFailureAt, &a.BlockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// RecordFailure atomically adds a failure to key. Counters older than window start over.
+func (r *LoginAttemptRepo) RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error) {
+	query := `
+	INSERT INTO login_attempts (key, failures, last_failure_at)
+	VALUES ($1, 1, $2)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+		blocked_until = CASE WHEN login_attempts.last_failure_at < $3 THEN NULL ELSE login_attempts.blocked_until END,
+		last_failure_at = $2
+	RETURNING key, failures, last_failure_at, blocked_until`
+	now := time.Now()
+	a := &models.LoginAttempt{}
+	err := r.db.QueryRow(ctx, query, key, now, now.Add(-window)).Scan(
+		&a.Key, &a.Failures, &a.LastFailureAt, &a.BlockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Block rejects attempts for key until the given time
+func (r *LoginAttemptRepo) Block(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE login_attempts SET blocked_until = $2 WHERE key = $1`, key, until)
+	return err
+}
+
+// Reset clears the counters of key
+func (r *LoginAttemptRepo) Reset(ctx context.Context, key string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
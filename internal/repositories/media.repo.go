@@ -2,8 +2,15 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/samiulice/photostock/internal/models"
 )
@@ -16,36 +23,115 @@ func NewMediaRepo(db *pgxpool.Pool) *MediaRepo {
 	return &MediaRepo{db: db}
 }
 
+// mediaTagsColumn selects the tag names of the media row m
+const mediaTagsColumn = `ARRAY(SELECT t.name FROM media_tags mt JOIN tags t ON t.id = mt.tag_id WHERE mt.media_id = m.id ORDER BY t.name)`
+
+// mediaReviewColumns selects the review and processing state of the media row m
+const mediaReviewColumns = `m.status, COALESCE((SELECT rr.title FROM rejection_reasons rr WHERE rr.id = m.rejection_reason_id), ''), m.rejection_note, m.reviewed_at, m.near_duplicate_of,
+	m.processing_status, m.processing_error`
+
+// hammingSQL returns the Hamming distance between the phash of the media row m and a hash expression
+func hammingSQL(other string) string {
+	return "bit_count((m.phash # " + other + ")::bit(64))"
+}
+
 // ------------------------------ Media CRUD ------------------------------
 
-// Create inserts a new media record.
+// Create inserts a new media record and starts its review history.
+// Media are created pending review unless m.Status says otherwise, and ready unless
+// m.ProcessingStatus says otherwise. A zero m.PHash is stored as unknown.
+// The tags in m.Tags are applied in the same transaction, m.Tags then holds the names applied.
 func (r *MediaRepo) Create(ctx context.Context, m *models.Media) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := createMedia(ctx, tx, m); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// CreateUpload inserts an uploaded media record like Create and, in the same transaction,
+// its upload history record h, the upload count of its category and a job of the given kind
+// on it. Either the upload is fully saved or nothing is, so no media is left unprocessed.
+func (r *MediaRepo) CreateUpload(ctx context.Context, m *models.Media, h *models.UploadHistory, kind string, maxAttempts int) (*models.Job, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := createMedia(ctx, tx, m); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(ctx, insertUploadQuery, insertUploadArgs(h)...).Scan(&h.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE media_categories
+		SET total_uploads = total_uploads + 1,
+		    updated_at = $2
+		WHERE id = $1`, m.CategoryID, time.Now()); err != nil {
+		return nil, err
+	}
+	job, err := enqueueJob(ctx, tx, kind, &m.ID, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	m.ProcessingStatus = models.MediaProcessingQueued
+	return job, tx.Commit(ctx)
+}
+
+// createMedia inserts m, its first review history entry and its tags within tx
+func createMedia(ctx context.Context, tx pgx.Tx, m *models.Media) error {
 	query := `
-		INSERT INTO medias (
-			media_uuid, media_title, description, category_id,
-			license_type, uploader_id, uploader_name,
-			total_downloads, total_earnings,
-			file_type, file_ext, file_name, file_size, resolution,
-			created_at, updated_at
-		) VALUES (
-			$1, $2, $3, $4,
-			$5, $6, $7,
-			$8, $9,
-			$10, $11, $12, $13, $14,
-			$15, $16
+		WITH created AS (
+			INSERT INTO medias (
+				media_uuid, media_title, description, category_id,
+				license_type, uploader_id, uploader_name,
+				total_downloads, total_earnings,
+				file_type, file_ext, file_name, file_size, resolution,
+				width, height, status, created_at, updated_at,
+				sha256, phash, near_duplicate_of, processing_status
+			) VALUES (
+				$1, $2, $3, $4,
+				$5, $6, $7,
+				$8, $9,
+				$10, $11, $12, $13, $14,
+				$15, $16, $17, $18, $19,
+				NULLIF($20, ''), NULLIF($21, 0), $22, COALESCE(NULLIF($23, ''), 'ready')
+			)
+			RETURNING id, status, uploader_id, created_at
 		)
-		RETURNING id`
+		INSERT INTO media_status_history (media_id, to_status, actor_id, created_at)
+		SELECT id, status, uploader_id, created_at FROM created
+		RETURNING media_id`
 	now := time.Now()
-	err := r.db.QueryRow(ctx, query,
+	if m.Status == "" {
+		m.Status = models.MediaStatusPendingReview
+	}
+	err := tx.QueryRow(ctx, query,
 		m.MediaUUID, m.MediaTitle, m.Description, m.CategoryID,
 		m.LicenseType, m.UploaderID, m.UploaderName,
 		m.TotalDownloads, m.TotalEarnings,
 		m.FileType, m.FileExt, m.FileName, m.FileSize, m.Resolution,
-		now, now,
+		m.Width, m.Height, m.Status, now, now,
+		m.SHA256, int64(m.PHash), m.NearDuplicateOf, m.ProcessingStatus,
 	).Scan(&m.ID)
+	if err != nil {
+		return err
+	}
+	if len(m.Tags) > 0 {
+		if m.Tags, err = setMediaTags(ctx, tx, m.ID, m.Tags); err != nil {
+			return err
+		}
+	}
 	m.CreatedAt = now
 	m.UpdatedAt = now
-	return err
+	return nil
 }
 
 // GetByID retrieves media by ID.
@@ -55,8 +141,9 @@ func (r *MediaRepo) GetByID(ctx context.Context, id int) (*models.Media, error)
 			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
 			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
 			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
-			m.resolution, m.created_at, m.updated_at,
-			c.id, c.name, c.created_at, c.updated_at
+			m.resolution, m.width, m.height, ` + mediaReviewColumns + `,
+			m.created_at, m.updated_at,
+			c.id, c.name, c.created_at, c.updated_at, ` + mediaTagsColumn + `
 		FROM medias m
 		LEFT JOIN media_categories c ON m.category_id = c.id
 		WHERE m.id = $1`
@@ -66,8 +153,10 @@ func (r *MediaRepo) GetByID(ctx context.Context, id int) (*models.Media, error)
 		&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
 		&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
 		&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
-		&m.Resolution, &m.CreatedAt, &m.UpdatedAt,
-		&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt,
+		&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+		&m.ProcessingStatus, &m.ProcessingError,
+		&m.CreatedAt, &m.UpdatedAt,
+		&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt, &m.Tags,
 	)
 	if err != nil {
 		return nil, err
@@ -83,7 +172,8 @@ func (r *MediaRepo) GetByMediaUUID(ctx context.Context, mediaUUID string) (*mode
 			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
 			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
 			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
-			m.resolution, m.created_at, m.updated_at,
+			m.resolution, m.width, m.height, ` + mediaReviewColumns + `,
+			m.created_at, m.updated_at,
 			c.id, c.name, c.created_at, c.updated_at
 		FROM medias m
 		LEFT JOIN media_categories c ON m.category_id = c.id
@@ -94,7 +184,9 @@ func (r *MediaRepo) GetByMediaUUID(ctx context.Context, mediaUUID string) (*mode
 		&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
 		&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
 		&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
-		&m.Resolution, &m.CreatedAt, &m.UpdatedAt,
+		&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+		&m.ProcessingStatus, &m.ProcessingError,
+		&m.CreatedAt, &m.UpdatedAt,
 		&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt,
 	)
 	if err != nil {
@@ -133,11 +225,88 @@ func (r *MediaRepo) Update(ctx context.Context, m *models.Media) error {
 	return err
 }
 
-// Delete removes a media record.
+// UpdateDetails changes the title, description, category and license type of a media record,
+// and its tags to m.Tags when setTags is set. m.Tags then holds the tag names applied.
+// When the category changes, the upload counters of both categories are adjusted.
+func (r *MediaRepo) UpdateDetails(ctx context.Context, m *models.Media, setTags bool) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var previousCategoryID *int
+	err = tx.QueryRow(ctx, `SELECT category_id FROM medias WHERE id = $1 FOR UPDATE`, m.ID).Scan(&previousCategoryID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE medias
+		SET media_title = $2,
+			description = $3,
+			category_id = $4,
+			license_type = $5,
+			updated_at = $6
+		WHERE id = $1`
+	m.UpdatedAt = time.Now()
+	if _, err := tx.Exec(ctx, query, m.ID, m.MediaTitle, m.Description, m.CategoryID, m.LicenseType, m.UpdatedAt); err != nil {
+		return err
+	}
+
+	if previousCategoryID == nil || *previousCategoryID != m.CategoryID {
+		if previousCategoryID != nil {
+			if _, err := tx.Exec(ctx, `
+				UPDATE media_categories
+				SET total_uploads = GREATEST(total_uploads - 1, 0), updated_at = $2
+				WHERE id = $1`, *previousCategoryID, m.UpdatedAt); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE media_categories
+			SET total_uploads = total_uploads + 1, updated_at = $2
+			WHERE id = $1`, m.CategoryID, m.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	if setTags {
+		if m.Tags, err = setMediaTags(ctx, tx, m.ID, m.Tags); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Delete removes a media record and decreases the upload counter of its category and the counters of its tags.
+// Download history is kept. It returns pgx.ErrNoRows when the media does not exist.
 func (r *MediaRepo) Delete(ctx context.Context, id int) error {
-	query := `DELETE FROM medias WHERE id = $1`
-	_, err := r.db.Exec(ctx, query, id)
-	return err
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE tags SET media_count = GREATEST(media_count - 1, 0), updated_at = $2
+		WHERE id IN (SELECT tag_id FROM media_tags WHERE media_id = $1)`, id, time.Now()); err != nil {
+		return err
+	}
+
+	var categoryID *int
+	err = tx.QueryRow(ctx, `DELETE FROM medias WHERE id = $1 RETURNING category_id`, id).Scan(&categoryID)
+	if err != nil {
+		return err
+	}
+	if categoryID != nil {
+		if _, err := tx.Exec(ctx, `
+			UPDATE media_categories
+			SET total_uploads = GREATEST(total_uploads - 1, 0), updated_at = $2
+			WHERE id = $1`, *categoryID, time.Now()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
 // GetAll returns all media with category info.
@@ -147,7 +316,8 @@ func (r *MediaRepo) GetAll(ctx context.Context) ([]*models.Media, error) {
 			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
 			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
 			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
-			m.resolution, m.created_at, m.updated_at,
+			m.resolution, m.width, m.height, ` + mediaReviewColumns + `,
+			m.created_at, m.updated_at,
 			c.id, c.name, c.created_at, c.updated_at
 		FROM medias m
 		LEFT JOIN media_categories c ON m.category_id = c.id`
@@ -165,7 +335,9 @@ func (r *MediaRepo) GetAll(ctx context.Context) ([]*models.Media, error) {
 			&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
 			&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
 			&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
-			&m.Resolution, &m.CreatedAt, &m.UpdatedAt,
+			&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+			&m.ProcessingStatus, &m.ProcessingError,
+			&m.CreatedAt, &m.UpdatedAt,
 			&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt,
 		)
 		if err != nil {
@@ -185,7 +357,8 @@ func (r *MediaRepo) GetAllByCategoryID(ctx context.Context, id int) ([]*models.M
 			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
 			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
 			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
-			m.resolution, m.created_at, m.updated_at,
+			m.resolution, m.width, m.height, ` + mediaReviewColumns + `,
+			m.created_at, m.updated_at,
 			c.id, c.name, c.created_at, c.updated_at
 		FROM medias m
 		LEFT JOIN media_categories c ON m.category_id = c.id
@@ -204,7 +377,9 @@ func (r *MediaRepo) GetAllByCategoryID(ctx context.Context, id int) ([]*models.M
 			&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
 			&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
 			&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
-			&m.Resolution, &m.CreatedAt, &m.UpdatedAt,
+			&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+			&m.ProcessingStatus, &m.ProcessingError,
+			&m.CreatedAt, &m.UpdatedAt,
 			&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt,
 		)
 		if err != nil {
@@ -226,3 +401,625 @@ func (r *MediaRepo) IncrementDownloadCountByID(ctx context.Context, id int) erro
 	_, err := r.db.Exec(ctx, query, id, time.Now())
 	return err
 }
+
+// ------------------------------ Media listing ------------------------------
+
+// mediaSortColumns maps a sort order to its column, the SQL type of its cursor value
+// and whether it sorts descending. Ties are broken by id in the same direction.
+var mediaSortColumns = map[string]struct {
+	column string
+	cast   string
+	desc   bool
+}{
+	models.MediaSortNewest:    {"m.created_at", "timestamp", true},
+	models.MediaSortOldest:    {"m.created_at", "timestamp", false},
+	models.MediaSortDownloads: {"m.total_downloads", "integer", true},
+	models.MediaSortEarnings:  {"m.total_earnings", "numeric", true},
+}
+
+// mediaFilterSQL returns the WHERE conditions of a media filter, appending their arguments to args
+func mediaFilterSQL(f models.MediaFilter, args []any) ([]string, []any) {
+	var conds []string
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if f.CategoryID != 0 {
+		add("m.category_id = ?", f.CategoryID)
+	}
+	if f.LicenseType != nil {
+		add("m.license_type = ?", *f.LicenseType)
+	}
+	if f.FileType != "" {
+		add("LOWER(m.file_type) = LOWER(?)", f.FileType)
+	}
+	if f.UploaderID != 0 {
+		add("m.uploader_id = ?", f.UploaderID)
+	}
+	if f.Status != "" {
+		add("m.status = ?", f.Status)
+	}
+	if f.Flagged {
+		conds = append(conds, "m.near_duplicate_of IS NOT NULL")
+	}
+	if f.MinWidth > 0 {
+		add("m.width >= ?", f.MinWidth)
+	}
+	if f.MaxWidth > 0 {
+		add("m.width <= ?", f.MaxWidth)
+	}
+	if f.MinHeight > 0 {
+		add("m.height >= ?", f.MinHeight)
+	}
+	if f.MaxHeight > 0 {
+		add("m.height <= ?", f.MaxHeight)
+	}
+	if f.Tag != "" {
+		add("EXISTS (SELECT 1 FROM media_tags mt JOIN tags t ON t.id = mt.tag_id WHERE mt.media_id = m.id AND t.slug = ?)", f.Tag)
+	}
+	switch f.Orientation {
+	case "landscape":
+		conds = append(conds, "m.width > m.height")
+	case "portrait":
+		conds = append(conds, "m.width < m.height")
+	case "square":
+		conds = append(conds, "m.width = m.height AND m.width > 0")
+	}
+	for i, class := range models.ResolutionClasses {
+		if class.Name != f.Resolution {
+			continue
+		}
+		add("GREATEST(m.width, m.height) >= ?", class.MinEdge)
+		if i+1 < len(models.ResolutionClasses) {
+			add("GREATEST(m.width, m.height) < ?", models.ResolutionClasses[i+1].MinEdge)
+		}
+	}
+	if f.From != nil {
+		add("m.created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		add("m.created_at < ?", *f.To)
+	}
+	return conds, args
+}
+
+// Count returns the number of media matching the filter
+func (r *MediaRepo) Count(ctx context.Context, f models.MediaFilter) (int, error) {
+	conds, args := mediaFilterSQL(f, nil)
+	query := `SELECT COUNT(*) FROM medias m`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	var total int
+	err := r.db.QueryRow(ctx, query, args...).Scan(&total)
+	return total, err
+}
+
+// mediaFacetColumns are the value expressions of the facets, in the order they are returned
+var mediaFacetColumns = []struct {
+	name  string
+	value string
+	label string
+	clear func(*models.MediaFilter) // removes the facet's own filter
+}{
+	{"category", "m.category_id::text", "(SELECT c.name FROM media_categories c WHERE c.id = MIN(m.category_id))",
+		func(f *models.MediaFilter) { f.CategoryID = 0 }},
+	{"license", "CASE m.license_type WHEN 1 THEN 'premium' ELSE 'free' END", "''",
+		func(f *models.MediaFilter) { f.LicenseType = nil }},
+	{"file_type", "LOWER(NULLIF(m.file_type, ''))", "MIN(m.file_type)",
+		func(f *models.MediaFilter) { f.FileType = "" }},
+	{"orientation", "CASE WHEN m.width > m.height THEN 'landscape' WHEN m.width < m.height THEN 'portrait' WHEN m.width > 0 THEN 'square' END", "''",
+		func(f *models.MediaFilter) { f.Orientation = "" }},
+	{"resolution", resolutionClassSQL(), "''",
+		func(f *models.MediaFilter) { f.Resolution = "" }},
+}
+
+// resolutionClassSQL returns a CASE expression naming the resolution class of the media row m
+func resolutionClassSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for i := len(models.ResolutionClasses) - 1; i >= 0; i-- {
+		c := models.ResolutionClasses[i]
+		fmt.Fprintf(&b, " WHEN GREATEST(m.width, m.height) >= %d THEN '%s'", c.MinEdge, c.Name)
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// Facets counts the media matching the filter, and the search query q when it is not
+// empty, per category, license, file type, orientation and resolution class.
+// All facets are computed in a single query.
+func (r *MediaRepo) Facets(ctx context.Context, q string, f models.MediaFilter) (*models.MediaFacets, error) {
+	var args []any
+	with, from := "", "medias m"
+	if q != "" {
+		tsquery, searchArgs, err := searchQuerySQL(q, nil)
+		if err != nil {
+			return nil, err
+		}
+		args = searchArgs
+		with = `WITH tsq AS (SELECT ` + tsquery + ` AS query) `
+		from = "medias m CROSS JOIN tsq"
+	}
+
+	parts := make([]string, 0, len(mediaFacetColumns))
+	for _, col := range mediaFacetColumns {
+		fc := f
+		col.clear(&fc)
+		var conds []string
+		conds, args = mediaFilterSQL(fc, args)
+		conds = append(conds, col.value+" IS NOT NULL")
+		if q != "" {
+			conds = append(conds, "m.search_vector @@ tsq.query")
+		}
+		parts = append(parts, fmt.Sprintf(
+			`(SELECT '%s' AS facet, %s AS value, COALESCE(%s, '') AS label, COUNT(*) AS count FROM %s WHERE %s GROUP BY 2)`,
+			col.name, col.value, col.label, from, strings.Join(conds, " AND ")))
+	}
+	query := with + `SELECT facet, value, label, count FROM (` + strings.Join(parts, " UNION ALL ") + `) f ORDER BY facet, count DESC, value`
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := &models.MediaFacets{
+		Categories:   []models.FacetCount{},
+		Licenses:     []models.FacetCount{},
+		FileTypes:    []models.FacetCount{},
+		Orientations: []models.FacetCount{},
+		Resolutions:  []models.FacetCount{},
+	}
+	for rows.Next() {
+		var facet string
+		var c models.FacetCount
+		if err := rows.Scan(&facet, &c.Value, &c.Label, &c.Count); err != nil {
+			return nil, err
+		}
+		switch facet {
+		case "category":
+			facets.Categories = append(facets.Categories, c)
+		case "license":
+			facets.Licenses = append(facets.Licenses, c)
+		case "file_type":
+			facets.FileTypes = append(facets.FileTypes, c)
+		case "orientation":
+			facets.Orientations = append(facets.Orientations, c)
+		case "resolution":
+			facets.Resolutions = append(facets.Resolutions, c)
+		}
+	}
+	return facets, rows.Err()
+}
+
+// List returns a page of at most limit media matching the filter in the given sort order,
+// using keyset pagination. The page starts after the cursor item, or ends before it when
+// cursor.Before is set. more reports whether further items exist in the paging direction.
+func (r *MediaRepo) List(ctx context.Context, f models.MediaFilter, sort string, cursor *models.MediaCursor, limit int) (medias []*models.Media, more bool, err error) {
+	order, ok := mediaSortColumns[sort]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown media sort %q", sort)
+	}
+
+	conds, args := mediaFilterSQL(f, nil)
+	// Paging backwards reads the rows in reverse order and flips them afterwards
+	desc := order.desc
+	if cursor != nil && cursor.Before {
+		desc = !desc
+	}
+	if cursor != nil {
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		args = append(args, cursor.Value, cursor.ID)
+		conds = append(conds, fmt.Sprintf("(%s, m.id) %s ($%d::%s, $%d)",
+			order.column, cmp, len(args)-1, order.cast, len(args)))
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	query := `
+		SELECT 
+			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
+			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
+			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
+			m.resolution, m.width, m.height, ` + mediaReviewColumns + `,
+			m.created_at, m.updated_at,
+			c.id, c.name, c.created_at, c.updated_at, ` + mediaTagsColumn + `
+		FROM medias m
+		LEFT JOIN media_categories c ON m.category_id = c.id`
+	if len(conds) > 0 {
+		query += `
+		WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(`
+		ORDER BY %s %s, m.id %s
+		LIMIT $%d`, order.column, direction, direction, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.Media
+		var c models.MediaCategory
+		err := rows.Scan(
+			&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
+			&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
+			&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
+			&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+			&m.ProcessingStatus, &m.ProcessingError,
+			&m.CreatedAt, &m.UpdatedAt,
+			&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt, &m.Tags,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		m.MediaCategory = c
+		medias = append(medias, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(medias) > limit {
+		medias = medias[:limit]
+		more = true
+	}
+	if cursor != nil && cursor.Before {
+		slices.Reverse(medias)
+	}
+	return medias, more, nil
+}
+
+// ------------------------------ Media search ------------------------------
+
+// Highlight delimiters passed to ts_headline. Control characters can't occur in
+// titles or descriptions, so the highlights can be HTML escaped safely afterwards.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+// ErrEmptySearch is returned for a search query without any searchable term
+var ErrEmptySearch = errors.New("empty search query")
+
+// searchQuerySQL turns a user query into a tsquery expression, appending its arguments to args.
+// "quoted words" match as a phrase, word* as a prefix and -word excludes; other words must all match.
+func searchQuerySQL(q string, args []any) (string, []any, error) {
+	var parts []string
+	add := func(expr, arg string) {
+		args = append(args, arg)
+		parts = append(parts, strings.ReplaceAll(expr, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	// Odd segments of a split on quotes are phrases
+	for i, segment := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			if strings.TrimSpace(segment) != "" {
+				add("phraseto_tsquery('english', ?)", segment)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(segment) {
+			negate := strings.HasPrefix(word, "-")
+			prefix := strings.HasSuffix(word, "*")
+			// Only letters and digits reach to_tsquery, its syntax characters are dropped
+			clean := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, word)
+			switch {
+			case clean == "":
+			case negate:
+				add("!!plainto_tsquery('english', ?)", clean)
+			case prefix:
+				add("to_tsquery('english', ?::text || ':*')", clean)
+			default:
+				add("plainto_tsquery('english', ?)", clean)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return "", args, ErrEmptySearch
+	}
+	return "(" + strings.Join(parts, " && ") + ")", args, nil
+}
+
+// Search returns the media matching a full-text query and the filter, best matches first,
+// together with the total number of matches.
+func (r *MediaRepo) Search(ctx context.Context, q string, f models.MediaFilter, limit, offset int) ([]*models.MediaSearchHit, int, error) {
+	tsquery, args, err := searchQuerySQL(q, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	conds, args := mediaFilterSQL(f, args)
+	where := `m.search_vector @@ tsq.query`
+	if len(conds) > 0 {
+		where += ` AND ` + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM medias m, (SELECT ` + tsquery + ` AS query) tsq WHERE ` + where
+	if err := r.db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT 
+			m.id, m.media_uuid, m.media_title, m.description, m.category_id,
+			m.license_type, m.uploader_id, m.uploader_name, m.total_downloads,
+			m.total_earnings, m.file_type, m.file_ext, m.file_name, m.file_size,
+			m.resolution, m.width, m.height, `+mediaReviewColumns+`,
+			m.created_at, m.updated_at,
+			c.id, c.name, c.created_at, c.updated_at, `+mediaTagsColumn+`,
+			ts_rank(m.search_vector, tsq.query) AS rank,
+			ts_headline('english', m.media_title, tsq.query, 'HighlightAll=true, StartSel=%[1]s, StopSel=%[2]s'),
+			ts_headline('english', COALESCE(m.description, ''), tsq.query, 'MaxWords=35, MinWords=15, MaxFragments=2, StartSel=%[1]s, StopSel=%[2]s')
+		FROM medias m
+		LEFT JOIN media_categories c ON m.category_id = c.id
+		CROSS JOIN (SELECT %[3]s AS query) tsq
+		WHERE %[4]s
+		ORDER BY rank DESC, m.id DESC
+		LIMIT $%[5]d OFFSET $%[6]d`,
+		HighlightStart, HighlightStop, tsquery, where, len(args)-1, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var hits []*models.MediaSearchHit
+	for rows.Next() {
+		var h models.MediaSearchHit
+		var c models.MediaCategory
+		m := &h.Media
+		err := rows.Scan(
+			&m.ID, &m.MediaUUID, &m.MediaTitle, &m.Description, &m.CategoryID,
+			&m.LicenseType, &m.UploaderID, &m.UploaderName, &m.TotalDownloads,
+			&m.TotalEarnings, &m.FileType, &m.FileExt, &m.FileName, &m.FileSize,
+			&m.Resolution, &m.Width, &m.Height, &m.Status, &m.RejectionReason, &m.RejectionNote, &m.ReviewedAt, &m.NearDuplicateOf,
+			&m.ProcessingStatus, &m.ProcessingError,
+			&m.CreatedAt, &m.UpdatedAt,
+			&c.ID, &c.Name, &c.CreatedAt, &c.UpdatedAt, &m.Tags,
+			&h.Rank, &h.TitleHighlight, &h.Snippet,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		m.MediaCategory = c
+		hits = append(hits, &h)
+	}
+	return hits, total, rows.Err()
+}
+
+// ------------------------------ Duplicates ------------------------------
+
+// GetIDBySHA256 returns the id of the media whose original has the given SHA-256 digest,
+// or 0 when there is none
+func (r *MediaRepo) GetIDBySHA256(ctx context.Context, sum string) (int, error) {
+	var id int
+	err := r.db.QueryRow(ctx, `SELECT id FROM medias WHERE sha256 = $1`, sum).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// SetProcessed stores the perceptual hash computed by the background processing of a media
+// item and the closest existing media when it looks like a near-duplicate
+func (r *MediaRepo) SetProcessed(ctx context.Context, id int, phash uint64, nearDuplicateOf *int) error {
+	_, err := r.db.Exec(ctx, `UPDATE medias SET phash = $2, near_duplicate_of = $3 WHERE id = $1`,
+		id, int64(phash), nearDuplicateOf)
+	return err
+}
+
+// FindSimilar returns at most limit media whose perceptual hash is within maxDistance
+// bits of phash, closest first
+func (r *MediaRepo) FindSimilar(ctx context.Context, phash uint64, maxDistance, limit int) ([]*models.SimilarMedia, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT m.id, m.media_title, `+hammingSQL("$1")+` AS distance
+	FROM medias m
+	WHERE m.phash IS NOT NULL AND `+hammingSQL("$1")+` <= $2
+	ORDER BY distance, m.id
+	LIMIT $3`, int64(phash), maxDistance, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []*models.SimilarMedia
+	for rows.Next() {
+		s := &models.SimilarMedia{}
+		if err := rows.Scan(&s.ID, &s.Title, &s.Distance); err != nil {
+			return nil, err
+		}
+		similar = append(similar, s)
+	}
+	return similar, rows.Err()
+}
+
+// FindSimilarTo returns at most limit other media whose perceptual hash is within maxDistance
+// bits of the hash of the media with the given id, closest first. Media not hashed yet have none.
+func (r *MediaRepo) FindSimilarTo(ctx context.Context, id, maxDistance, limit int) ([]*models.SimilarMedia, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT m.id, m.media_title, `+hammingSQL("s.phash")+` AS distance
+	FROM medias s
+	JOIN medias m ON m.id <> s.id AND m.phash IS NOT NULL
+	WHERE s.id = $1 AND `+hammingSQL("s.phash")+` <= $2
+	ORDER BY distance, m.id
+	LIMIT $3`, id, maxDistance, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []*models.SimilarMedia
+	for rows.Next() {
+		s := &models.SimilarMedia{}
+		if err := rows.Scan(&s.ID, &s.Title, &s.Distance); err != nil {
+			return nil, err
+		}
+		similar = append(similar, s)
+	}
+	return similar, rows.Err()
+}
+
+// DuplicateClusters groups the media of the catalog whose perceptual hashes are within
+// maxDistance bits of each other. Media are linked pairwise and linked media form a
+// cluster, so two members of a cluster can be further apart than maxDistance.
+// At most maxPairs links, the closest ones, are considered.
+//
+// Only media sharing a bucket are compared. The hash is cut into maxDistance+1 bands and
+// media are bucketed by the value of each band: hashes within maxDistance bits agree on at
+// least one band, so no pair is missed. Small distances give wide, selective bands.
+func (r *MediaRepo) DuplicateClusters(ctx context.Context, maxDistance, maxPairs int) ([]*models.DuplicateCluster, error) {
+	bands := maxDistance + 1
+	width := min(64/bands, 32)
+	rows, err := r.db.Query(ctx, `
+	WITH buckets AS (
+		SELECT id, phash, band, (phash >> (band * $3::int)) & $4::bigint AS bucket
+		FROM medias, generate_series(0, $2::int - 1) AS band
+		WHERE phash IS NOT NULL
+	)
+	SELECT DISTINCT a.id, b.id, bit_count((a.phash # b.phash)::bit(64)) AS distance
+	FROM buckets a
+	JOIN buckets b ON b.band = a.band AND b.bucket = a.bucket AND b.id > a.id
+	WHERE bit_count((a.phash # b.phash)::bit(64)) <= $1
+	ORDER BY distance, a.id, b.id
+	LIMIT $5`, maxDistance, bands, width, int64(1)<<width-1, maxPairs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Union-find over the linked pairs
+	parent := map[int]int{}
+	var find func(int) int
+	find = func(id int) int {
+		p, ok := parent[id]
+		if !ok || p == id {
+			parent[id] = id
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	maxLink := map[int]int{}
+	type link struct{ a, b, distance int }
+	var links []link
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.a, &l.b, &l.distance); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		links = append(links, l)
+		parent[find(l.a)] = find(l.b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return []*models.DuplicateCluster{}, nil
+	}
+	for _, l := range links {
+		root := find(l.a)
+		maxLink[root] = max(maxLink[root], l.distance)
+	}
+
+	ids := make([]int, 0, len(parent))
+	for id := range parent {
+		ids = append(ids, id)
+	}
+	rows, err = r.db.Query(ctx, `
+	SELECT id, media_title, COALESCE(uploader_id, 0), uploader_name, status, media_uuid, created_at
+	FROM medias
+	WHERE id = ANY($1)
+	ORDER BY created_at, id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byRoot := map[int]*models.DuplicateCluster{}
+	clusters := []*models.DuplicateCluster{}
+	for rows.Next() {
+		m := &models.DuplicateMember{}
+		if err := rows.Scan(&m.ID, &m.Title, &m.UploaderID, &m.UploaderName, &m.Status, &m.MediaUUID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		root := find(m.ID)
+		c, ok := byRoot[root]
+		if !ok {
+			c = &models.DuplicateCluster{MaxDistance: maxLink[root]}
+			byRoot[root] = c
+			clusters = append(clusters, c)
+		}
+		c.Media = append(c.Media, m)
+	}
+	return clusters, rows.Err()
+}
+
+// ------------------------------ Metadata ------------------------------
+
+// SaveMetadata stores the embedded metadata of a media item, replacing any earlier copy
+func (r *MediaRepo) SaveMetadata(ctx context.Context, meta *models.MediaMetadata) error {
+	keywords := meta.Keywords
+	if keywords == nil {
+		keywords = []string{}
+	}
+	_, err := r.db.Exec(ctx, `
+	INSERT INTO media_metadata (media_id, camera_make, camera_model, lens_model, exposure_time, f_number,
+		iso, focal_length, captured_at, orientation, gps_latitude, gps_longitude, gps_altitude,
+		title, description, keywords, copyright, creator, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+	ON CONFLICT (media_id) DO UPDATE SET
+		camera_make = EXCLUDED.camera_make, camera_model = EXCLUDED.camera_model,
+		lens_model = EXCLUDED.lens_model, exposure_time = EXCLUDED.exposure_time,
+		f_number = EXCLUDED.f_number, iso = EXCLUDED.iso, focal_length = EXCLUDED.focal_length,
+		captured_at = EXCLUDED.captured_at, orientation = EXCLUDED.orientation,
+		gps_latitude = EXCLUDED.gps_latitude, gps_longitude = EXCLUDED.gps_longitude,
+		gps_altitude = EXCLUDED.gps_altitude, title = EXCLUDED.title,
+		description = EXCLUDED.description, keywords = EXCLUDED.keywords,
+		copyright = EXCLUDED.copyright, creator = EXCLUDED.creator`,
+		meta.MediaID, meta.CameraMake, meta.CameraModel, meta.LensModel, meta.ExposureTime, meta.FNumber,
+		meta.ISO, meta.FocalLength, meta.CapturedAt, meta.Orientation, meta.GPSLatitude, meta.GPSLongitude,
+		meta.GPSAltitude, meta.Title, meta.Description, keywords, meta.Copyright, meta.Creator, time.Now())
+	return err
+}
+
+// GetMetadata returns the embedded metadata of a media item, or nil when none was found on upload
+func (r *MediaRepo) GetMetadata(ctx context.Context, mediaID int) (*models.MediaMetadata, error) {
+	meta := &models.MediaMetadata{}
+	err := r.db.QueryRow(ctx, `
+	SELECT media_id, camera_make, camera_model, lens_model, exposure_time, f_number, iso, focal_length,
+		captured_at, orientation, gps_latitude, gps_longitude, gps_altitude, title, description,
+		keywords, copyright, creator
+	FROM media_metadata
+	WHERE media_id = $1`, mediaID).Scan(
+		&meta.MediaID, &meta.CameraMake, &meta.CameraModel, &meta.LensModel, &meta.ExposureTime,
+		&meta.FNumber, &meta.ISO, &meta.FocalLength, &meta.CapturedAt, &meta.Orientation,
+		&meta.GPSLatitude, &meta.GPSLongitude, &meta.GPSAltitude, &meta.Title, &meta.Description,
+		&meta.Keywords, &meta.Copyright, &meta.Creator)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ErrMFANotEnrolled is returned when the user has no TOTP enrollment
+var ErrMFANotEnrolled = errors.New("two-factor authentication not enrolled")
+
+// ============================== MFA Repository ==============================
+type MFARepo struct {
+	db *pgxpool.Pool
+}
+
+func NewMFARepo(db *pgxpool.Pool) *MFARepo {
+	return &MFARepo{db: db}
+}
+
+// SavePending stores a new, not yet confirmed, TOTP secret for the user.
+// An enabled enrollment is never overwritten.
+func (r *MFARepo) SavePending(ctx context.Context, userID int, encryptedSecret string) error {
+	query := `
+	INSERT INTO user_mfa (user_id, secret, enabled_at, last_used_step, created_at, updated_at)
+	VALUES ($1, $2, NULL, 0, $3, $3)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_used_step = 0, updated_at = EXCLUDED.updated_at
+	WHERE user_mfa.enabled_at IS NULL`
+	tag, err := r.db.Exec(ctx, query, userID, encryptedSecret, time.Now())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("two-factor authentication already enabled")
+	}
+	return nil
+}
+
+// GetByUserID retrieves the TOTP enrollment of a user
+func (r *MFARepo) GetByUserID(ctx context.Context, userID int) (*models.UserMFA, error) {
+	query := `
+	SELECT user_id, secret, enabled_at, last_used_step, created_at, updated_at
+	FROM user_mfa
+	WHERE user_id = $1`
+	m := &models.UserMFA{}
+	err := r.db.QueryRow(ctx, query, userID).Scan(
+		&m.UserID, &m.Secret, &m.EnabledAt, &m.LastUsedStep, &m.CreatedAt, &m.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMFANotEnrolled
+	}
+	return m, err
+}
+
+// IsEnabled reports whether the user has confirmed a TOTP enrollment
+func (r *MFARepo) IsEnabled(ctx context.Context, userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled_at IS NOT NULL)`
+	var enabled bool
+	err := r.db.QueryRow(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// ConsumeStep records step as the last used TOTP step.
+// It reports false when a code for this or a later step was already accepted (replay).
+func (r *MFARepo) ConsumeStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+	UPDATE user_mfa
+	SET last_used_step = $2, updated_at = $3
+	WHERE user_id = $1 AND last_used_step < $2`
+	tag, err := r.db.Exec(ctx, query, userID, step, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Enable confirms the pending enrollment and stores the initial recovery codes
+func (r *MFARepo) Enable(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	if _, err := tx.Exec(ctx, `
+	UPDATE user_mfa
+	SET enabled_at = $2, updated_at = $2
+	WHERE user_id = $1`, userID, now); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReplaceRecoveryCodes invalidates all recovery codes of the user and stores new ones
+func (r *MFARepo) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(ctx, `
+		INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at)
+		VALUES ($1, $2, $3)`, userID, h, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks a matching unused recovery code as used and reports whether one was found
+func (r *MFARepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `
+	UPDATE mfa_recovery_codes
+	SET used_at = $3
+	WHERE id = (
+		SELECT id FROM mfa_recovery_codes
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		LIMIT 1
+	)`
+	tag, err := r.db.Exec(ctx, query, userID, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (r *MFARepo) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	var n int
+	err := r.db.QueryRow(ctx, query, userID).Scan(&n)
+	return n, err
+}
+
+// Disable removes the TOTP enrollment and all recovery codes of the user
+func (r *MFARepo) Disable(ctx context.Context, userID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrRejectionReasonNotFound is returned when no rejection reason matches
+	ErrRejectionReasonNotFound = errors.New("rejection reason not found")
+	// ErrRejectionReasonExists is returned when a rejection reason code is already used
+	ErrRejectionReasonExists = errors.New("rejection reason already exists")
+)
+
+// ============================== Moderation Repository ==============================
+type ModerationRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewModerationRepo(db *pgxpool.Pool) *ModerationRepo {
+	return &ModerationRepo{db: db}
+}
+
+// Transition moves the media in ids that are in one of the from states to the to state and
+// records the change in their history. Media in other states are left untouched.
+// Media are only approved once their background processing is ready.
+// Approving or rejecting also records the reviewer. reasonID and note are kept as the
+// rejection details when rejecting and cleared otherwise.
+// It returns the media that changed state.
+func (r *ModerationRepo) Transition(ctx context.Context, ids []int, from []string, to string, actorID int, reasonID *int, note string) ([]*models.ReviewedMedia, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if to != models.MediaStatusRejected {
+		reasonID, note = nil, ""
+	}
+	review := to == models.MediaStatusApproved || to == models.MediaStatusRejected
+	now := time.Now()
+
+	rows, err := tx.Query(ctx, `
+	UPDATE medias m
+	SET status = $2,
+		rejection_reason_id = $3,
+		rejection_note = $4,
+		reviewed_by = CASE WHEN $6 THEN $5 ELSE m.reviewed_by END,
+		reviewed_at = CASE WHEN $6 THEN $7 ELSE m.reviewed_at END,
+		updated_at = $7
+	FROM (
+		SELECT id, status FROM medias
+		WHERE id = ANY($1) AND status = ANY($8)
+			AND ($2 <> 'approved' OR processing_status = 'ready')
+		ORDER BY id
+		FOR UPDATE
+	) old
+	WHERE m.id = old.id
+	RETURNING m.id, old.status, m.media_title, COALESCE(m.uploader_id, 0)`,
+		ids, to, reasonID, note, actorID, review, now, from)
+	if err != nil {
+		return nil, err
+	}
+	var changed []*models.ReviewedMedia
+	var changedIDs []int
+	var previous []string
+	for rows.Next() {
+		m := &models.ReviewedMedia{}
+		var status string
+		if err := rows.Scan(&m.ID, &status, &m.Title, &m.UploaderID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changed = append(changed, m)
+		changedIDs = append(changedIDs, m.ID)
+		previous = append(previous, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(changed) > 0 {
+		_, err := tx.Exec(ctx, `
+		INSERT INTO media_status_history (media_id, from_status, to_status, actor_id, rejection_reason_id, note, created_at)
+		SELECT id, status, $3, $4, $5, $6, $7
+		FROM unnest($1::int[], $2::text[]) AS t(id, status)`,
+			changedIDs, previous, to, actorID, reasonID, note, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changed, tx.Commit(ctx)
+}
+
+// History returns the review state changes of a media item, oldest first
+func (r *ModerationRepo) History(ctx context.Context, mediaID int) ([]*models.MediaStatusChange, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT h.id, h.media_id, h.from_status, h.to_status, h.actor_id,
+		COALESCE(NULLIF(u.name, ''), u.username, ''), h.rejection_reason_id,
+		COALESCE(rr.title, ''), h.note, h.created_at
+	FROM media_status_history h
+	LEFT JOIN users u ON u.id = h.actor_id
+	LEFT JOIN rejection_reasons rr ON rr.id = h.rejection_reason_id
+	WHERE h.media_id = $1
+	ORDER BY h.created_at, h.id`, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*models.MediaStatusChange{}
+	for rows.Next() {
+		c := &models.MediaStatusChange{}
+		err := rows.Scan(&c.ID, &c.MediaID, &c.FromStatus, &c.ToStatus, &c.ActorID,
+			&c.ActorName, &c.ReasonID, &c.Reason, &c.Note, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+// ------------------------------ Rejection reasons ------------------------------
+
+const rejectionReasonColumns = `id, code, title, description, active, created_at, updated_at`
+
+func scanRejectionReason(row pgx.Row) (*models.RejectionReason, error) {
+	rr := &models.RejectionReason{}
+	err := row.Scan(&rr.ID, &rr.Code, &rr.Title, &rr.Description, &rr.Active, &rr.CreatedAt, &rr.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRejectionReasonNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrRejectionReasonExists
+	}
+	return rr, err
+}
+
+// GetReasons returns the rejection reasons, only the active ones when activeOnly is set
+func (r *ModerationRepo) GetReasons(ctx context.Context, activeOnly bool) ([]*models.RejectionReason, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT `+rejectionReasonColumns+`
+	FROM rejection_reasons
+	WHERE active OR NOT $1
+	ORDER BY title`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reasons := []*models.RejectionReason{}
+	for rows.Next() {
+		rr, err := scanRejectionReason(rows)
+		if err != nil {
+			return nil, err
+		}
+		reasons = append(reasons, rr)
+	}
+	return reasons, rows.Err()
+}
+
+// GetReason returns a rejection reason by id
+func (r *ModerationRepo) GetReason(ctx context.Context, id int) (*models.RejectionReason, error) {
+	return scanRejectionReason(r.db.QueryRow(ctx, `SELECT `+rejectionReasonColumns+` FROM rejection_reasons WHERE id = $1`, id))
+}
+
+// CreateReason adds a rejection reason
+func (r *ModerationRepo) CreateReason(ctx context.Context, rr *models.RejectionReason) (*models.RejectionReason, error) {
+	now := time.Now()
+	return scanRejectionReason(r.db.QueryRow(ctx, `
+	INSERT INTO rejection_reasons (code, title, description, active, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $5)
+	RETURNING `+rejectionReasonColumns, rr.Code, rr.Title, rr.Description, rr.Active, now))
+}
+
+// UpdateReason changes the title, description and active flag of a rejection reason.
+// Reasons are deactivated rather than deleted so the history keeps its references.
+func (r *ModerationRepo) UpdateReason(ctx context.Context, rr *models.RejectionReason) (*models.RejectionReason, error) {
+	return scanRejectionReason(r.db.QueryRow(ctx, `
+	UPDATE rejection_reasons
+	SET title = $2, description = $3, active = $4, updated_at = $5
+	WHERE id = $1
+	RETURNING `+rejectionReasonColumns, rr.ID, rr.Title, rr.Description, rr.Active, time.Now()))
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ErrPasswordResetInvalid is returned when a reset token is unknown, expired or already used
+var ErrPasswordResetInvalid = errors.New("invalid or expired password reset token")
+
+// ============================== PasswordReset Repository ==============================
+type PasswordResetRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPasswordResetRepo(db *pgxpool.Pool) *PasswordResetRepo {
+	return &PasswordResetRepo{db: db}
+}
+
+// Create stores a new reset token and invalidates any previous unused token of the user
+func (r *PasswordResetRepo) Create(ctx context.Context, pr *models.PasswordReset) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	if _, err := tx.Exec(ctx, `
+	UPDATE password_resets
+	SET used_at = $2
+	WHERE user_id = $1 AND used_at IS NULL`, pr.UserID, now); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `
+	INSERT INTO password_resets (user_id, token_hash, expires_at, created_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id`,
+		pr.UserID, pr.TokenHash, pr.ExpiresAt, now,
+	).Scan(&pr.ID)
+	if err != nil {
+		return err
+	}
+	pr.CreatedAt = now
+
+	return tx.Commit(ctx)
+}
+
+// Consume marks the token as used and returns the user it was issued for.
+// A token can only be consumed once and only before it expires.
+func (r *PasswordResetRepo) Consume(ctx context.Context, tokenHash string) (int, error) {
+	query := `
+	UPDATE password_resets
+	SET used_at = $2
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	RETURNING user_id`
+	var userID int
+	err := r.db.QueryRow(ctx, query, tokenHash, time.Now()).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrPasswordResetInvalid
+	}
+	return userID, err
+}
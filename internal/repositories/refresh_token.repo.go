@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrRefreshTokenNotFound is returned when no refresh token matches the presented value
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenExpired is returned when the presented refresh token is past its expiry
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	// ErrRefreshTokenReused is returned when an already rotated or revoked token is presented again.
+	// The whole token family is revoked before this error is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// ============================== RefreshToken Repository ==============================
+type RefreshTokenRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewRefreshTokenRepo(db *pgxpool.Pool) *RefreshTokenRepo {
+	return &RefreshTokenRepo{db: db}
+}
+
+// Create inserts a new refresh token
+func (r *RefreshTokenRepo) Create(ctx context.Context, t *models.RefreshToken) error {
+	query := `
+	INSERT INTO refresh_tokens (user_id, family_id, token_hash, mfa, expires_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+	now := time.Now()
+	err := r.db.QueryRow(ctx, query,
+		t.UserID, t.FamilyID, t.TokenHash, t.MFA, t.ExpiresAt, now, now,
+	).Scan(&t.ID)
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	return err
+}
+
+// GetByHash retrieves a refresh token by its hash
+func (r *RefreshTokenRepo) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+	SELECT id, user_id, family_id, token_hash, mfa, expires_at, used_at, revoked_at, created_at, updated_at
+	FROM refresh_tokens
+	WHERE token_hash = $1`
+	t := &models.RefreshToken{}
+	err := r.db.QueryRow(ctx, query, tokenHash).Scan(
+		&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.MFA, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return t, err
+}
+
+// Rotate exchanges the token identified by oldHash for next, which is stored in the same family.
+// Presenting a token that was already used or revoked revokes the whole family and its session.
+// On success next is filled with the user, family and MFA state of the rotated token.
+func (r *RefreshTokenRepo) Rotate(ctx context.Context, oldHash string, next *models.RefreshToken) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		id        int
+		userID    int
+		familyID  string
+		mfa       bool
+		expiresAt time.Time
+		usedAt    *time.Time
+		revokedAt *time.Time
+	)
+	err = tx.QueryRow(ctx, `
+	SELECT id, user_id, family_id, mfa, expires_at, used_at, revoked_at
+	FROM refresh_tokens
+	WHERE token_hash = $1
+	FOR UPDATE`, oldHash).Scan(&id, &userID, &familyID, &mfa, &expiresAt, &usedAt, &revokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if usedAt != nil || revokedAt != nil {
+		// the token was replayed: kill every token issued for this login
+		if _, err := tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $2, updated_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL`, familyID, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+		UPDATE sessions
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL`, familyID, now); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+	if now.After(expiresAt) {
+		return ErrRefreshTokenExpired
+	}
+
+	if _, err := tx.Exec(ctx, `
+	UPDATE refresh_tokens
+	SET used_at = $2, updated_at = $2
+	WHERE id = $1`, id, now); err != nil {
+		return err
+	}
+	// the session stays signed in as long as its newest refresh token is valid
+	if _, err := tx.Exec(ctx, `
+	UPDATE sessions
+	SET last_seen_at = $2, expires_at = $3
+	WHERE id = $1`, familyID, now, next.ExpiresAt); err != nil {
+		return err
+	}
+
+	next.UserID = userID
+	next.FamilyID = familyID
+	next.MFA = mfa
+	err = tx.QueryRow(ctx, `
+	INSERT INTO refresh_tokens (user_id, family_id, token_hash, mfa, expires_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`,
+		next.UserID, next.FamilyID, next.TokenHash, next.MFA, next.ExpiresAt, now, now,
+	).Scan(&next.ID)
+	if err != nil {
+		return err
+	}
+	next.CreatedAt = now
+	next.UpdatedAt = now
+
+	return tx.Commit(ctx)
+}
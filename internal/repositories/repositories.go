@@ -12,7 +12,22 @@ type DBRepository struct {
 	SubscriptionRepo     *SubscriptionRepo
 	MediaRepo            *MediaRepo
 	DownloadHistoryRepo  *DownloadHistoryRepo
-	UploadHistoryRepo  *UploadHistoryRepo
+	UploadHistoryRepo    *UploadHistoryRepo
+	RefreshTokenRepo     *RefreshTokenRepo
+	PasswordResetRepo    *PasswordResetRepo
+	MFARepo              *MFARepo
+	APIKeyRepo           *APIKeyRepo
+	RoleRepo             *RoleRepo
+	LoginAttemptRepo     *LoginAttemptRepo
+	AuditLogRepo         *AuditLogRepo
+	SigningKeyRepo       *SigningKeyRepo
+	SessionRepo          *SessionRepo
+	IdentityRepo         *IdentityRepo
+	TagRepo              *TagRepo
+	SuggestRepo          *SuggestRepo
+	ModerationRepo       *ModerationRepo
+	WatermarkRepo        *WatermarkRepo
+	JobRepo              *JobRepo
 }
 
 // NewDBRepository initializes all repositories with a shared connection pool
@@ -25,6 +40,21 @@ func NewDBRepository(db *pgxpool.Pool) *DBRepository {
 		SubscriptionRepo:     NewSubscriptionRepo(db),
 		MediaRepo:            NewMediaRepo(db),
 		DownloadHistoryRepo:  NewDownloadHistoryRepo(db),
-		UploadHistoryRepo:  NewUploadHistoryRepo(db),
+		UploadHistoryRepo:    NewUploadHistoryRepo(db),
+		RefreshTokenRepo:     NewRefreshTokenRepo(db),
+		PasswordResetRepo:    NewPasswordResetRepo(db),
+		MFARepo:              NewMFARepo(db),
+		APIKeyRepo:           NewAPIKeyRepo(db),
+		RoleRepo:             NewRoleRepo(db),
+		LoginAttemptRepo:     NewLoginAttemptRepo(db),
+		AuditLogRepo:         NewAuditLogRepo(db),
+		SigningKeyRepo:       NewSigningKeyRepo(db),
+		SessionRepo:          NewSessionRepo(db),
+		IdentityRepo:         NewIdentityRepo(db),
+		TagRepo:              NewTagRepo(db),
+		SuggestRepo:          NewSuggestRepo(db),
+		ModerationRepo:       NewModerationRepo(db),
+		WatermarkRepo:        NewWatermarkRepo(db),
+		JobRepo:              NewJobRepo(db),
 	}
 }
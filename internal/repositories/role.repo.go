@@ -0,0 +1,177 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrRoleNotFound is returned when no role matches the given name
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrUnknownPermission is returned when a role is granted a permission that does not exist
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// ============================== Role Repository ==============================
+type RoleRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewRoleRepo(db *pgxpool.Pool) *RoleRepo {
+	return &RoleRepo{db: db}
+}
+
+// GetAll returns every role together with its permissions
+func (r *RoleRepo) GetAll(ctx context.Context) ([]*models.Role, error) {
+	query := `
+	SELECT r.id, r.name, r.description, r.created_at, r.updated_at,
+		COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	GROUP BY r.id
+	ORDER BY r.id`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.UpdatedAt, &role.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	return roles, rows.Err()
+}
+
+// GetAllPermissions returns every permission that can be granted to a role
+func (r *RoleRepo) GetAllPermissions(ctx context.Context) ([]*models.Permission, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var perms []*models.Permission
+	for rows.Next() {
+		var p models.Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		perms = append(perms, &p)
+	}
+	return perms, rows.Err()
+}
+
+// GetPermissions returns the permission names granted to a role.
+// An unknown role has no permissions.
+func (r *RoleRepo) GetPermissions(ctx context.Context, role string) ([]string, error) {
+	query := `
+	SELECT p.name
+	FROM permissions p
+	JOIN role_permissions rp ON rp.permission_id = p.id
+	JOIN roles r ON r.id = rp.role_id
+	WHERE r.name = $1
+	ORDER BY p.name`
+	rows, err := r.db.Query(ctx, query, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	perms := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		perms = append(perms, name)
+	}
+	return perms, rows.Err()
+}
+
+// Exists reports whether a role with the given name exists
+func (r *RoleRepo) Exists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, name).Scan(&exists)
+	return exists, err
+}
+
+// Create inserts a new role and grants it the given permissions
+func (r *RoleRepo) Create(ctx context.Context, role *models.Role) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	err = tx.QueryRow(ctx, `
+	INSERT INTO roles (name, description, created_at, updated_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id`, role.Name, role.Description, now, now).Scan(&role.ID)
+	if err != nil {
+		return err
+	}
+	if err := setRolePermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	role.CreatedAt = now
+	role.UpdatedAt = now
+	return tx.Commit(ctx)
+}
+
+// Update changes the description of a role and replaces its permissions
+func (r *RoleRepo) Update(ctx context.Context, role *models.Role) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+	UPDATE roles
+	SET description = $2, updated_at = $3
+	WHERE name = $1
+	RETURNING id, created_at`, role.Name, role.Description, time.Now()).Scan(&role.ID, &role.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, role.ID); err != nil {
+		return err
+	}
+	if err := setRolePermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// setRolePermissions grants the named permissions to a role inside tx
+func setRolePermissions(ctx context.Context, tx pgx.Tx, roleID int, perms []string) error {
+	if len(perms) == 0 {
+		return nil
+	}
+	tag, err := tx.Exec(ctx, `
+	INSERT INTO role_permissions (role_id, permission_id)
+	SELECT $1, id FROM permissions WHERE name = ANY($2)
+	ON CONFLICT DO NOTHING`, roleID, perms)
+	if err != nil {
+		return err
+	}
+	if int(tag.RowsAffected()) != len(perms) {
+		return ErrUnknownPermission
+	}
+	return nil
+}
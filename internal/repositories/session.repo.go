@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written for a busy session
+const sessionTouchInterval = time.Minute
+
+// ============================== Session Repository ==============================
+type SessionRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewSessionRepo(db *pgxpool.Pool) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+// Create inserts a new session
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+	query := `
+	INSERT INTO sessions (id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	now := time.Now()
+	_, err := r.db.Exec(ctx, query,
+		s.ID, s.UserID, s.UserAgent, s.IPAddress, now, now, s.ExpiresAt,
+	)
+	s.CreatedAt = now
+	s.LastSeenAt = now
+	return err
+}
+
+// GetActiveByUserID returns the signed-in sessions of a user, most recently used first
+func (r *SessionRepo) GetActiveByUserID(ctx context.Context, userID int) ([]*models.Session, error) {
+	query := `
+	SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at, revoked_at
+	FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+	ORDER BY last_seen_at DESC`
+	rows, err := r.db.Query(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(
+			&s.ID, &s.UserID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, rows.Err()
+}
+
+// Touch reports whether the session is still signed in and records the activity
+func (r *SessionRepo) Touch(ctx context.Context, id string) (bool, error) {
+	query := `
+	SELECT last_seen_at
+	FROM sessions
+	WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2`
+	now := time.Now()
+	var lastSeen time.Time
+	err := r.db.QueryRow(ctx, query, id, now).Scan(&lastSeen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if now.Sub(lastSeen) > sessionTouchInterval {
+		if _, err := r.db.Exec(ctx, `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, id, now); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Revoke signs a session of the user out. It returns false when no such signed-in session exists.
+func (r *SessionRepo) Revoke(ctx context.Context, id string, userID int) (bool, error) {
+	n, err := r.revoke(ctx, `id::text = $1 AND user_id = $2`, id, userID)
+	return n > 0, err
+}
+
+// RevokeAllByUserID signs every session of a user out
+func (r *SessionRepo) RevokeAllByUserID(ctx context.Context, userID int) error {
+	_, err := r.revoke(ctx, `user_id = $1`, userID)
+	return err
+}
+
+// RevokeOthers signs every session of a user out except keepID
+func (r *SessionRepo) RevokeOthers(ctx context.Context, userID int, keepID string) error {
+	_, err := r.revoke(ctx, `user_id = $1 AND id::text <> $2`, userID, keepID)
+	return err
+}
+
+// revoke marks the matching sessions and their refresh tokens as revoked and returns the number of sessions.
+// where may reference the arguments as $1, $2, ...; the revocation time is appended as the last argument.
+func (r *SessionRepo) revoke(ctx context.Context, where string, args ...any) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	args = append(args, now)
+	nowArg := fmt.Sprintf("$%d", len(args))
+
+	rows, err := tx.Query(ctx, `
+	UPDATE sessions
+	SET revoked_at = `+nowArg+`
+	WHERE revoked_at IS NULL AND `+where+`
+	RETURNING id::text`, args...)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, tx.Commit(ctx)
+	}
+
+	if _, err := tx.Exec(ctx, `
+	UPDATE refresh_tokens
+	SET revoked_at = $2, updated_at = $2
+	WHERE family_id::text = ANY($1) AND revoked_at IS NULL`, ids, now); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), tx.Commit(ctx)
+}
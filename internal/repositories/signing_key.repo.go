@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ErrKeyRotated is returned when another instance rotated the signing key first
+var ErrKeyRotated = errors.New("signing key already rotated")
+
+// ============================== SigningKey Repository ==============================
+type SigningKeyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewSigningKeyRepo(db *pgxpool.Pool) *SigningKeyRepo {
+	return &SigningKeyRepo{db: db}
+}
+
+// GetAll returns every key that is still accepted for verification, newest first
+func (r *SigningKeyRepo) GetAll(ctx context.Context) ([]*models.SigningKey, error) {
+	query := `
+	SELECT kid, algorithm, private_key, public_key, created_at, retired_at, expires_at
+	FROM signing_keys
+	WHERE expires_at IS NULL OR expires_at > $1
+	ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.SigningKey
+	for rows.Next() {
+		var k models.SigningKey
+		if err := rows.Scan(&k.KID, &k.Algorithm, &k.PrivateKey, &k.PublicKey, &k.CreatedAt, &k.RetiredAt, &k.ExpiresAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	return keys, rows.Err()
+}
+
+// Rotate stores next as the signing key and retires every other key that still signs.
+// Retired keys keep verifying until retiredExpiresAt. previousKID is the key the caller
+// believes is current ("" for none); when another instance rotated in the meantime
+// nothing is changed and ErrKeyRotated is returned.
+func (r *SigningKeyRepo) Rotate(ctx context.Context, previousKID string, next *models.SigningKey, retiredExpiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// serialize rotations of all instances
+	if _, err := tx.Exec(ctx, `LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var currentKID string
+	err = tx.QueryRow(ctx, `
+	SELECT kid FROM signing_keys
+	WHERE retired_at IS NULL
+	ORDER BY created_at DESC
+	LIMIT 1`).Scan(&currentKID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if currentKID != previousKID {
+		return ErrKeyRotated
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(ctx, `
+	UPDATE signing_keys
+	SET retired_at = $1, expires_at = $2
+	WHERE retired_at IS NULL`, now, retiredExpiresAt); err != nil {
+		return err
+	}
+	// keys that can no longer verify anything are of no use
+	if _, err := tx.Exec(ctx, `DELETE FROM signing_keys WHERE expires_at < $1`, now); err != nil {
+		return err
+	}
+
+	next.CreatedAt = now
+	if _, err := tx.Exec(ctx, `
+	INSERT INTO signing_keys (kid, algorithm, private_key, public_key, created_at)
+	VALUES ($1, $2, $3, $4, $5)`,
+		next.KID, next.Algorithm, next.PrivateKey, next.PublicKey, next.CreatedAt,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -18,14 +18,22 @@ func NewSubscriptionPlanRepo(db *pgxpool.Pool) *SubscriptionTypeRepo {
 	return &SubscriptionTypeRepo{db: db}
 }
 
+// planSizes returns the download size tiers of a plan as stored, an empty list includes every tier
+func planSizes(sp *models.SubscriptionPlan) []string {
+	if sp.DownloadSizes == nil {
+		return []string{}
+	}
+	return sp.DownloadSizes
+}
+
 func (r *SubscriptionTypeRepo) Create(ctx context.Context, sp *models.SubscriptionPlan) error {
 	sp.Terms = strings.Join(sp.TermsList, "[[]]") // Concatenate terms
 	query := `
-		INSERT INTO subscription_plans (title, terms, status, price, download_limit, expires_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO subscription_plans (title, terms, status, price, download_limit, download_sizes, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id`
 	return r.db.QueryRow(ctx, query,
-		sp.Title, sp.Terms, sp.Status, sp.Price, sp.DownloadLimit, sp.ExpiresAt, time.Now(), time.Now(),
+		sp.Title, sp.Terms, sp.Status, sp.Price, sp.DownloadLimit, planSizes(sp), sp.ExpiresAt, time.Now(), time.Now(),
 	).Scan(&sp.ID)
 }
 
@@ -33,10 +41,10 @@ func (r *SubscriptionTypeRepo) Update(ctx context.Context, sp *models.Subscripti
 	sp.Terms = strings.Join(sp.TermsList, ",") // Concatenate terms
 	query := `
 		UPDATE subscription_plans
-		SET title = $2, terms = $3, status = $4, price = $5, download_limit = $6, expires_at = $7, updated_at = $8
+		SET title = $2, terms = $3, status = $4, price = $5, download_limit = $6, download_sizes = $7, expires_at = $8, updated_at = $9
 		WHERE id = $1`
 	_, err := r.db.Exec(ctx, query,
-		sp.ID, sp.Title, sp.Terms, sp.Status, sp.Price, sp.DownloadLimit, sp.ExpiresAt, time.Now(),
+		sp.ID, sp.Title, sp.Terms, sp.Status, sp.Price, sp.DownloadLimit, planSizes(sp), sp.ExpiresAt, time.Now(),
 	)
 	return err
 }
@@ -49,7 +57,7 @@ func (r *SubscriptionTypeRepo) Delete(ctx context.Context, id int) error {
 
 func (r *SubscriptionTypeRepo) GetAll(ctx context.Context) ([]*models.SubscriptionPlan, error) {
 	query := `
-		SELECT id, title, terms, status, price, download_limit, expires_at, created_at, updated_at
+		SELECT id, title, terms, status, price, download_limit, download_sizes, expires_at, created_at, updated_at
 		FROM subscription_plans`
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
@@ -62,7 +70,7 @@ func (r *SubscriptionTypeRepo) GetAll(ctx context.Context) ([]*models.Subscripti
 		var sp models.SubscriptionPlan
 
 		err := rows.Scan(
-			&sp.ID, &sp.Title, &sp.Terms, &sp.Status, &sp.Price, &sp.DownloadLimit, &sp.ExpiresAt, &sp.CreatedAt, &sp.UpdatedAt,
+			&sp.ID, &sp.Title, &sp.Terms, &sp.Status, &sp.Price, &sp.DownloadLimit, &sp.DownloadSizes, &sp.ExpiresAt, &sp.CreatedAt, &sp.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -78,12 +86,12 @@ func (r *SubscriptionTypeRepo) GetAll(ctx context.Context) ([]*models.Subscripti
 
 func (r *SubscriptionTypeRepo) GetByID(ctx context.Context, id int) (*models.SubscriptionPlan, error) {
 	query := `
-		SELECT id, title, terms, status, price, download_limit, expires_at, created_at, updated_at
+		SELECT id, title, terms, status, price, download_limit, download_sizes, expires_at, created_at, updated_at
 		FROM subscription_plans
 		WHERE id = $1`
 	var sp models.SubscriptionPlan
 	err := r.db.QueryRow(ctx, query, id).Scan(
-		&sp.ID, &sp.Title, &sp.Terms, &sp.Status, &sp.Price, &sp.DownloadLimit, &sp.ExpiresAt, &sp.CreatedAt, &sp.UpdatedAt,
+		&sp.ID, &sp.Title, &sp.Terms, &sp.Status, &sp.Price, &sp.DownloadLimit, &sp.DownloadSizes, &sp.ExpiresAt, &sp.CreatedAt, &sp.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
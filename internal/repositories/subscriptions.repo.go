@@ -37,7 +37,7 @@ func (r *SubscriptionRepo) GetByID(ctx context.Context, id int) (*models.Subscri
 		SELECT s.id, s.user_id, s.subscription_plans_id,
 		        s.payment_amount, s.payment_time,
 		       s.total_downloads, s.status, s.created_at, s.updated_at,
-		       p.id, p.title, p.terms, p.status, p.download_limit, p.time_limit::text, p.created_at, p.updated_at
+		       p.id, p.title, p.terms, p.status, p.download_limit, p.download_sizes, p.time_limit::text, p.created_at, p.updated_at
 		FROM subscriptions s
 		LEFT JOIN subscription_plans p ON s.subscription_plans_id = p.id
 		WHERE s.id = $1`
@@ -47,7 +47,7 @@ func (r *SubscriptionRepo) GetByID(ctx context.Context, id int) (*models.Subscri
 		&sub.ID, &sub.UserID, &sub.SubscriptionPlanID, &sub.PaymentAmount, &sub.PaymentTime,
 		&sub.TotalDownloads, &sub.Status, &sub.CreatedAt, &sub.UpdatedAt,
 		&sub.PlanDetails.ID, &sub.PlanDetails.Title, &sub.PlanDetails.Terms,
-		&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit,
+		&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit, &sub.PlanDetails.DownloadSizes,
 		&sub.PlanDetails.ExpiresAt, &sub.PlanDetails.CreatedAt, &sub.PlanDetails.UpdatedAt,
 	)
 	return sub, err
@@ -85,7 +85,7 @@ func (r *SubscriptionRepo) GetAll(ctx context.Context) ([]*models.Subscription,
 		SELECT s.id, s.user_id, s.subscription_plans_id,
 		        s.payment_amount, s.payment_time,
 		       s.total_downloads, s.status, s.created_at, s.updated_at,
-		       p.id, p.title, p.terms, p.status, p.download_limit, p.time_limit::text, p.created_at, p.updated_at
+		       p.id, p.title, p.terms, p.status, p.download_limit, p.download_sizes, p.time_limit::text, p.created_at, p.updated_at
 		FROM subscriptions s
 		LEFT JOIN subscription_plans p ON s.subscription_plans_id = p.id
 		ORDER BY s.created_at DESC`
@@ -104,7 +104,7 @@ func (r *SubscriptionRepo) GetAll(ctx context.Context) ([]*models.Subscription,
 			&sub.ID, &sub.UserID, &sub.SubscriptionPlanID, &sub.PaymentAmount, &sub.PaymentTime,
 			&sub.TotalDownloads, &sub.Status, &sub.CreatedAt, &sub.UpdatedAt,
 			&sub.PlanDetails.ID, &sub.PlanDetails.Title, &sub.PlanDetails.Terms,
-			&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit,
+			&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit, &sub.PlanDetails.DownloadSizes,
 			&sub.PlanDetails.ExpiresAt, &sub.PlanDetails.CreatedAt, &sub.PlanDetails.UpdatedAt,
 		)
 		if err != nil {
@@ -120,7 +120,7 @@ func (r *SubscriptionRepo) GetByUserID(ctx context.Context, userID int) ([]*mode
 		SELECT s.id, s.user_id, s.subscription_plans_id,
 		        s.payment_amount, s.payment_time,
 		       s.total_downloads, s.status, s.created_at, s.updated_at,
-		       p.id, p.title, p.terms, p.status, p.download_limit, p.time_limit::text, p.created_at, p.updated_at
+		       p.id, p.title, p.terms, p.status, p.download_limit, p.download_sizes, p.time_limit::text, p.created_at, p.updated_at
 		FROM subscriptions s
 		LEFT JOIN subscription_plans p ON s.subscription_plans_id = p.id
 		WHERE s.user_id = $1
@@ -140,7 +140,7 @@ func (r *SubscriptionRepo) GetByUserID(ctx context.Context, userID int) ([]*mode
 			&sub.ID, &sub.UserID, &sub.SubscriptionPlanID, &sub.PaymentAmount, &sub.PaymentTime,
 			&sub.TotalDownloads, &sub.Status, &sub.CreatedAt, &sub.UpdatedAt,
 			&sub.PlanDetails.ID, &sub.PlanDetails.Title, &sub.PlanDetails.Terms,
-			&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit,
+			&sub.PlanDetails.Status, &sub.PlanDetails.DownloadLimit, &sub.PlanDetails.DownloadSizes,
 			&sub.PlanDetails.ExpiresAt, &sub.PlanDetails.CreatedAt, &sub.PlanDetails.UpdatedAt,
 		)
 		if err != nil {
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// ============================== Suggest Repository ==============================
+type SuggestRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewSuggestRepo(db *pgxpool.Pool) *SuggestRepo {
+	return &SuggestRepo{db: db}
+}
+
+// likeEscaper escapes the LIKE wildcards of user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest returns type-ahead matches for q across media titles, tags, category names and
+// contributor names. A text matches when it starts with q, has a word starting with q, or
+// is close to q by trigram word similarity (pg_trgm), which tolerates typos.
+// Prefix matches rank first, then the most popular, then the closest.
+// At most perKind results of each kind are returned.
+func (r *SuggestRepo) Suggest(ctx context.Context, q string, perKind int) ([]*models.Suggestion, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	prefix := likeEscaper.Replace(q) + "%"
+	wordPrefix := "% " + prefix
+
+	query := `
+	SELECT kind, id, text, slug, popularity
+	FROM (
+		(SELECT 'media' AS kind, m.id, m.media_title AS text, '' AS slug, COALESCE(m.total_downloads, 0) AS popularity,
+			(m.media_title ILIKE $2 OR m.media_title ILIKE $3) AS prefix, word_similarity($1, m.media_title) AS sim
+		FROM medias m
+		WHERE m.status = 'approved' AND (m.media_title ILIKE $2 OR m.media_title ILIKE $3 OR $1 <% m.media_title)
+		ORDER BY prefix DESC, popularity DESC, sim DESC
+		LIMIT $4)
+		UNION ALL
+		(SELECT 'tag', t.id, t.name, t.slug, p.approved,
+			(t.name ILIKE $2 OR t.name ILIKE $3), word_similarity($1, t.name)
+		FROM tags t
+		JOIN (` + approvedTagCounts + `) p ON p.tag_id = t.id
+		WHERE NOT t.banned
+			AND (t.name ILIKE $2 OR t.name ILIKE $3 OR $1 <% t.name)
+		ORDER BY 6 DESC, 5 DESC, 7 DESC
+		LIMIT $4)
+		UNION ALL
+		(SELECT 'category', c.id, c.name, '', COALESCE(c.total_downloads, 0),
+			(c.name ILIKE $2 OR c.name ILIKE $3), word_similarity($1, c.name)
+		FROM media_categories c
+		WHERE c.name ILIKE $2 OR c.name ILIKE $3 OR $1 <% c.name
+		ORDER BY 6 DESC, 5 DESC, 7 DESC
+		LIMIT $4)
+		UNION ALL
+		(SELECT 'contributor', u.id, COALESCE(NULLIF(u.name, ''), u.username), '',
+			(SELECT COALESCE(SUM(m.total_downloads), 0)::int FROM medias m WHERE m.uploader_id = u.id AND m.status = 'approved'),
+			(u.name ILIKE $2 OR u.name ILIKE $3 OR u.username ILIKE $2),
+			GREATEST(word_similarity($1, u.name), word_similarity($1, u.username))
+		FROM users u
+		WHERE (u.name ILIKE $2 OR u.name ILIKE $3 OR u.username ILIKE $2 OR $1 <% u.name OR $1 <% u.username)
+			AND EXISTS (SELECT 1 FROM medias m WHERE m.uploader_id = u.id AND m.status = 'approved')
+		ORDER BY 6 DESC, 5 DESC, 7 DESC
+		LIMIT $4)
+	) s
+	ORDER BY prefix DESC, popularity DESC, sim DESC, text`
+
+	rows, err := r.db.Query(ctx, query, q, prefix, wordPrefix, perKind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*models.Suggestion{}
+	for rows.Next() {
+		s := &models.Suggestion{}
+		if err := rows.Scan(&s.Type, &s.ID, &s.Text, &s.Slug, &s.Popularity); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
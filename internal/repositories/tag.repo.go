@@ -0,0 +1,264 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+	"github.com/samiulice/photostock/internal/utils"
+)
+
+var (
+	// ErrTagNotFound is returned when no tag matches
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrTagExists is returned when renaming a tag to the name of another tag
+	ErrTagExists = errors.New("tag already exists")
+)
+
+// ============================== Tag Repository ==============================
+type TagRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewTagRepo(db *pgxpool.Pool) *TagRepo {
+	return &TagRepo{db: db}
+}
+
+const tagColumns = `id, name, slug, media_count, banned, created_at, updated_at`
+
+// approvedTagCounts counts the approved media of each tag. media_count includes drafts,
+// pending and rejected media, public listings count only what visitors can see.
+const approvedTagCounts = `
+	SELECT mt.tag_id, COUNT(*)::int AS approved
+	FROM media_tags mt
+	JOIN medias m ON m.id = mt.media_id AND m.status = 'approved'
+	GROUP BY mt.tag_id`
+
+func scanTag(row pgx.Row) (*models.Tag, error) {
+	t := &models.Tag{}
+	err := row.Scan(&t.ID, &t.Name, &t.Slug, &t.MediaCount, &t.Banned, &t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTagNotFound
+	}
+	return t, err
+}
+
+func (r *TagRepo) query(ctx context.Context, query string, args ...any) ([]*models.Tag, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*models.Tag{}
+	for rows.Next() {
+		t, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// GetBySlug returns the tag with the given slug
+func (r *TagRepo) GetBySlug(ctx context.Context, slug string) (*models.Tag, error) {
+	return scanTag(r.db.QueryRow(ctx, `SELECT `+tagColumns+` FROM tags WHERE slug = $1`, slug))
+}
+
+// GetPopular returns the tags that are not banned with the most approved media.
+// Their media count is the number of approved media.
+func (r *TagRepo) GetPopular(ctx context.Context, limit int) ([]*models.Tag, error) {
+	return r.query(ctx, `
+	SELECT t.id, t.name, t.slug, p.approved, t.banned, t.created_at, t.updated_at
+	FROM tags t
+	JOIN (`+approvedTagCounts+`) p ON p.tag_id = t.id
+	WHERE NOT t.banned
+	ORDER BY p.approved DESC, t.name
+	LIMIT $1`, limit)
+}
+
+// GetAll returns every tag including banned ones, most used first
+func (r *TagRepo) GetAll(ctx context.Context) ([]*models.Tag, error) {
+	return r.query(ctx, `SELECT `+tagColumns+` FROM tags ORDER BY media_count DESC, name`)
+}
+
+// setMediaTags replaces the tags of a media item within tx and returns the tag names applied.
+// Unknown tags are created; banned tags are skipped. Tag counts are updated in the same transaction.
+// It returns pgx.ErrNoRows when the media does not exist.
+func setMediaTags(ctx context.Context, tx pgx.Tx, mediaID int, names []string) ([]string, error) {
+	// Serializes concurrent updates of the same media item
+	if err := tx.QueryRow(ctx, `SELECT id FROM medias WHERE id = $1 FOR UPDATE`, mediaID).Scan(&mediaID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var slugs []string
+	for _, n := range names {
+		name, slug := utils.NormalizeTag(n)
+		if slug == "" || slices.Contains(slugs, slug) {
+			continue
+		}
+		slugs = append(slugs, slug)
+		_, err := tx.Exec(ctx, `
+		INSERT INTO tags (name, slug, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (slug) DO NOTHING`, name, slug, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, name, slug FROM tags WHERE slug = ANY($1) AND NOT banned`, slugs)
+	if err != nil {
+		return nil, err
+	}
+	bySlug := map[string]struct {
+		id   int
+		name string
+	}{}
+	for rows.Next() {
+		var id int
+		var name, slug string
+		if err := rows.Scan(&id, &name, &slug); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bySlug[slug] = struct {
+			id   int
+			name string
+		}{id, name}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var wanted []int
+	applied := []string{}
+	for _, slug := range slugs {
+		if t, ok := bySlug[slug]; ok {
+			wanted = append(wanted, t.id)
+			applied = append(applied, t.name)
+		}
+	}
+
+	var current []int
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(array_agg(tag_id), '{}') FROM media_tags WHERE media_id = $1`, mediaID).Scan(&current); err != nil {
+		return nil, err
+	}
+	var added, removed []int
+	for _, id := range wanted {
+		if !slices.Contains(current, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range current {
+		if !slices.Contains(wanted, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	if len(removed) > 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM media_tags WHERE media_id = $1 AND tag_id = ANY($2)`, mediaID, removed); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+		UPDATE tags SET media_count = GREATEST(media_count - 1, 0), updated_at = $2
+		WHERE id = ANY($1)`, removed, now); err != nil {
+			return nil, err
+		}
+	}
+	if len(added) > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO media_tags (media_id, tag_id) SELECT $1, unnest($2::int[])`, mediaID, added); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+		UPDATE tags SET media_count = media_count + 1, updated_at = $2
+		WHERE id = ANY($1)`, added, now); err != nil {
+			return nil, err
+		}
+	}
+	return applied, nil
+}
+
+// Rename changes the name and slug of a tag
+func (r *TagRepo) Rename(ctx context.Context, id int, name string) (*models.Tag, error) {
+	name, slug := utils.NormalizeTag(name)
+	t, err := scanTag(r.db.QueryRow(ctx, `
+	UPDATE tags SET name = $2, slug = $3, updated_at = $4
+	WHERE id = $1
+	RETURNING `+tagColumns, id, name, slug, time.Now()))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrTagExists
+	}
+	return t, err
+}
+
+// Merge moves every media item of the source tag to the target tag and deletes the source
+func (r *TagRepo) Merge(ctx context.Context, sourceID, targetID int) (*models.Tag, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked int
+	err = tx.QueryRow(ctx, `
+	SELECT COUNT(*) FROM (SELECT id FROM tags WHERE id IN ($1, $2) FOR UPDATE) t`, sourceID, targetID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if locked != 2 {
+		return nil, ErrTagNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `
+	INSERT INTO media_tags (media_id, tag_id)
+	SELECT media_id, $2 FROM media_tags WHERE tag_id = $1
+	ON CONFLICT DO NOTHING`, sourceID, targetID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM tags WHERE id = $1`, sourceID); err != nil {
+		return nil, err
+	}
+	t, err := scanTag(tx.QueryRow(ctx, `
+	UPDATE tags
+	SET media_count = (SELECT COUNT(*) FROM media_tags WHERE tag_id = $1), updated_at = $2
+	WHERE id = $1
+	RETURNING `+tagColumns, targetID, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit(ctx)
+}
+
+// SetBanned bans or unbans a tag. Banning removes the tag from every media item.
+func (r *TagRepo) SetBanned(ctx context.Context, id int, banned bool) (*models.Tag, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if banned {
+		if _, err := tx.Exec(ctx, `DELETE FROM media_tags WHERE tag_id = $1`, id); err != nil {
+			return nil, err
+		}
+	}
+	t, err := scanTag(tx.QueryRow(ctx, `
+	UPDATE tags
+	SET banned = $2, media_count = (SELECT COUNT(*) FROM media_tags WHERE tag_id = $1), updated_at = $3
+	WHERE id = $1
+	RETURNING `+tagColumns, id, banned, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit(ctx)
+}
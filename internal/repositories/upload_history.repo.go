@@ -18,11 +18,17 @@ func NewUploadHistoryRepo(db *pgxpool.Pool) *UploadHistoryRepo {
 }
 
 func (r *UploadHistoryRepo) Create(ctx context.Context, h *models.UploadHistory) error {
-	query := `
+	return r.db.QueryRow(ctx, insertUploadQuery, insertUploadArgs(h)...).Scan(&h.ID)
+}
+
+// insertUploadQuery inserts an upload history record, also used by MediaRepo.CreateUpload
+const insertUploadQuery = `
 	INSERT INTO upload_history (media_uuid, user_id, file_type, file_ext, file_name, file_size, resolution, uploaded_at)
 	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	RETURNING id`
-	return r.db.QueryRow(ctx, query,
+
+func insertUploadArgs(h *models.UploadHistory) []any {
+	return []any{
 		h.MediaUUID,
 		h.UserID,
 		h.FileType,
@@ -31,7 +37,7 @@ func (r *UploadHistoryRepo) Create(ctx context.Context, h *models.UploadHistory)
 		h.FileSize,
 		h.Resolution,
 		h.UploadedAt,
-	).Scan(&h.ID)
+	}
 }
 
 func (r *UploadHistoryRepo) GetByID(ctx context.Context, id int) (*models.UploadHistory, error) {
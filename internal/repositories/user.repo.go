@@ -7,6 +7,7 @@ import (
 	"path"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/samiulice/photostock/internal/models"
 )
@@ -56,6 +57,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 			u.subscription_id,
 			u.created_at,
 			u.updated_at,
+			u.email_verified_at,
 			
 			s.id,
 			s.user_id,
@@ -72,6 +74,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 			sp.terms,
 			sp.status,
 			sp.download_limit,
+			sp.download_sizes,
 			sp.expires_at,
 			sp.created_at,
 			sp.updated_at
@@ -103,6 +106,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 		planTerms     sql.NullString
 		planStatus    sql.NullBool
 		planDL        sql.NullInt64
+		planSizes     []string
 		planExpiresAt sql.NullInt64
 		planCreatedAt sql.NullTime
 		planUpdatedAt sql.NullTime
@@ -125,6 +129,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 		&user.SubscriptionID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.EmailVerifiedAt,
 
 		&subID,
 		&subUserID,
@@ -141,6 +146,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 		&planTerms,
 		&planStatus,
 		&planDL,
+		&planSizes,
 		&planExpiresAt,
 		&planCreatedAt,
 		&planUpdatedAt,
@@ -172,6 +178,7 @@ func (r *UserRepo) GetByID(ctx context.Context, id int) (*models.User, error) {
 				Terms:         planTerms.String,
 				Status:        planStatus.Bool,
 				DownloadLimit: int(planDL.Int64),
+				DownloadSizes: planSizes,
 				ExpiresAt:     int(planExpiresAt.Int64),
 				CreatedAt:     planCreatedAt.Time,
 				UpdatedAt:     planUpdatedAt.Time,
@@ -206,6 +213,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 			u.subscription_id,
 			u.created_at,
 			u.updated_at,
+			u.email_verified_at,
 			
 			s.id,
 			s.user_id,
@@ -222,6 +230,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 			sp.terms,
 			sp.status,
 			sp.download_limit,
+			sp.download_sizes,
 			sp.expires_at,
 			sp.created_at,
 			sp.updated_at
@@ -252,6 +261,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 		planTerms     sql.NullString
 		planStatus    sql.NullBool
 		planDL        sql.NullInt64
+		planSizes     []string
 		planExpiresAt sql.NullInt64
 		planCreatedAt sql.NullTime
 		planUpdatedAt sql.NullTime
@@ -274,6 +284,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 		&user.SubscriptionID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.EmailVerifiedAt,
 
 		&subID,
 		&subUserID,
@@ -290,6 +301,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 		&planTerms,
 		&planStatus,
 		&planDL,
+		&planSizes,
 		&planExpiresAt,
 		&planCreatedAt,
 		&planUpdatedAt,
@@ -321,6 +333,7 @@ func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.
 				Terms:         planTerms.String,
 				Status:        planStatus.Bool,
 				DownloadLimit: int(planDL.Int64),
+				DownloadSizes: planSizes,
 				ExpiresAt:     int(planExpiresAt.Int64),
 				CreatedAt:     planCreatedAt.Time,
 				UpdatedAt:     planUpdatedAt.Time,
@@ -357,6 +370,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 			u.subscription_id,
 			u.created_at,
 			u.updated_at,
+			u.email_verified_at,
 			
 			s.id,
 			s.user_id,
@@ -373,6 +387,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 			sp.terms,
 			sp.status,
 			sp.download_limit,
+			sp.download_sizes,
 			sp.expires_at,
 			sp.created_at,
 			sp.updated_at
@@ -404,6 +419,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 		planTerms     sql.NullString
 		planStatus    sql.NullBool
 		planDL        sql.NullInt64
+		planSizes     []string
 		planExpiresAt sql.NullInt64
 		planCreatedAt sql.NullTime
 		planUpdatedAt sql.NullTime
@@ -426,6 +442,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 		&user.SubscriptionID,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.EmailVerifiedAt,
 
 		&subID,
 		&subUserID,
@@ -442,6 +459,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 		&planTerms,
 		&planStatus,
 		&planDL,
+		&planSizes,
 		&planExpiresAt,
 		&planCreatedAt,
 		&planUpdatedAt,
@@ -473,6 +491,7 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*models.User,
 				Terms:         planTerms.String,
 				Status:        planStatus.Bool,
 				DownloadLimit: int(planDL.Int64),
+				DownloadSizes: planSizes,
 				ExpiresAt:     int(planExpiresAt.Int64),
 				CreatedAt:     planCreatedAt.Time,
 				UpdatedAt:     planUpdatedAt.Time,
@@ -505,10 +524,12 @@ func (r *UserRepo) Update(ctx context.Context, user *models.User) error {
 	return err
 }
 func (r *UserRepo) UpdateBasicInfo(ctx context.Context, user *models.User) error {
+	// changing the email address requires verifying the new one
 	query := `
 	UPDATE users
 	SET 
-		name = $1, email = $2, mobile = $3, address = $4, updated_at = $5
+		name = $1, email = $2, mobile = $3, address = $4, updated_at = $5,
+		email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
 	WHERE id = $6`
 	_, err := r.db.Exec(ctx, query,
 		user.Name, user.Email, user.Mobile, user.Address, time.Now(), user.ID,
@@ -545,6 +566,52 @@ func (r *UserRepo) UpdateSubscriptionPlanByUserID(ctx context.Context, subscript
 	return err
 }
 
+// MarkEmailVerified records that the user confirmed the given address.
+// It reports false when the address no longer matches the account (e.g. it changed since the link was sent).
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, id int, email string) (bool, error) {
+	query := `
+	UPDATE users
+	SET 
+		email_verified_at = COALESCE(email_verified_at, $3), updated_at = $3
+	WHERE id = $1 AND email = $2`
+	tag, err := r.db.Exec(ctx, query,
+		id, email, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdatePassword replaces the stored password hash of a user
+func (r *UserRepo) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	query := `
+	UPDATE users
+	SET 
+		password = $1, updated_at = $2
+	WHERE id = $3`
+	_, err := r.db.Exec(ctx, query,
+		passwordHash, time.Now(), id,
+	)
+	return err
+}
+
+// UpdateRole assigns a role to the user
+func (r *UserRepo) UpdateRole(ctx context.Context, id int, role string) error {
+	query := `
+	UPDATE users
+	SET 
+		role = $1, updated_at = $2
+	WHERE id = $3`
+	tag, err := r.db.Exec(ctx, query,
+		role, time.Now(), id,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return err
+}
+
 func (r *UserRepo) DeleteByID(ctx context.Context, id int) error {
 	query := `DELETE FROM users WHERE id = $1`
 	_, err := r.db.Exec(ctx, query, id)
@@ -566,7 +633,7 @@ func (r *UserRepo) Deactivate(ctx context.Context, id int, status bool) error {
 func (r *UserRepo) GetAll(ctx context.Context) ([]*models.User, error) {
 	query := `
 	SELECT id, username, password, name, avatar_url, status, role, email, mobile,
-		total_earnings, total_withdraw, total_expenses, address, subscription_id, created_at, updated_at
+		total_earnings, total_withdraw, total_expenses, address, subscription_id, created_at, updated_at, email_verified_at
 	FROM users`
 	rows, err := r.db.Query(ctx, query)
 	if err != nil {
@@ -580,7 +647,7 @@ func (r *UserRepo) GetAll(ctx context.Context) ([]*models.User, error) {
 		if err := rows.Scan(
 			&user.ID, &user.Username, &user.Password, &user.Name, &user.AvatarID, &user.Status,
 			&user.Role, &user.Email, &user.Mobile, &user.TotalEarnings, &user.TotalWithdraw,
-			&user.TotalExpenses, &user.Address, &user.SubscriptionID, &user.CreatedAt, &user.UpdatedAt,
+			&user.TotalExpenses, &user.Address, &user.SubscriptionID, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,216 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samiulice/photostock/internal/models"
+)
+
+var (
+	// ErrWatermarkProfileNotFound is returned when no watermark profile matches
+	ErrWatermarkProfileNotFound = errors.New("watermark profile not found")
+	// ErrWatermarkProfileExists is returned when a watermark profile name is already used
+	ErrWatermarkProfileExists = errors.New("watermark profile already exists")
+	// ErrWatermarkProfileDefault is returned when deleting the default watermark profile
+	ErrWatermarkProfileDefault = errors.New("the default watermark profile can't be deleted")
+)
+
+// ============================== Watermark Repository ==============================
+type WatermarkRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewWatermarkRepo(db *pgxpool.Pool) *WatermarkRepo {
+	return &WatermarkRepo{db: db}
+}
+
+const watermarkProfileColumns = `id, name, kind, text, logo_path, placement, scale, opacity, color, is_default, created_at, updated_at`
+
+// effectiveWatermarkSQL selects the id of the watermark profile that applies to the media row m
+const effectiveWatermarkSQL = `COALESCE(
+	(SELECT u.watermark_profile_id FROM users u WHERE u.id = m.uploader_id),
+	(SELECT c.watermark_profile_id FROM media_categories c WHERE c.id = m.category_id),
+	(SELECT wp.id FROM watermark_profiles wp WHERE wp.is_default))`
+
+func scanWatermarkProfile(row pgx.Row) (*models.WatermarkProfile, error) {
+	p := &models.WatermarkProfile{}
+	err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.Text, &p.LogoPath, &p.Placement, &p.Scale,
+		&p.Opacity, &p.Color, &p.IsDefault, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWatermarkProfileNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrWatermarkProfileExists
+	}
+	p.HasLogo = p.LogoPath != ""
+	return p, err
+}
+
+// GetAll returns every watermark profile, the default first
+func (r *WatermarkRepo) GetAll(ctx context.Context) ([]*models.WatermarkProfile, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+watermarkProfileColumns+` FROM watermark_profiles ORDER BY is_default DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []*models.WatermarkProfile{}
+	for rows.Next() {
+		p, err := scanWatermarkProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// GetByID returns a watermark profile by id
+func (r *WatermarkRepo) GetByID(ctx context.Context, id int) (*models.WatermarkProfile, error) {
+	return scanWatermarkProfile(r.db.QueryRow(ctx, `SELECT `+watermarkProfileColumns+` FROM watermark_profiles WHERE id = $1`, id))
+}
+
+// Resolve returns the watermark profile for new media of a contributor in a category:
+// the contributor's, else the category's, else the default one
+func (r *WatermarkRepo) Resolve(ctx context.Context, uploaderID, categoryID int) (*models.WatermarkProfile, error) {
+	return scanWatermarkProfile(r.db.QueryRow(ctx, `
+	SELECT `+watermarkProfileColumns+`
+	FROM watermark_profiles
+	WHERE id = COALESCE(
+		(SELECT watermark_profile_id FROM users WHERE id = $1),
+		(SELECT watermark_profile_id FROM media_categories WHERE id = $2),
+		(SELECT id FROM watermark_profiles WHERE is_default))`, uploaderID, categoryID))
+}
+
+// Create adds a watermark profile
+func (r *WatermarkRepo) Create(ctx context.Context, p *models.WatermarkProfile) (*models.WatermarkProfile, error) {
+	now := time.Now()
+	return scanWatermarkProfile(r.db.QueryRow(ctx, `
+	INSERT INTO watermark_profiles (name, kind, text, placement, scale, opacity, color, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+	RETURNING `+watermarkProfileColumns, p.Name, p.Kind, p.Text, p.Placement, p.Scale, p.Opacity, p.Color, now))
+}
+
+// Update changes the rendering settings of a watermark profile
+func (r *WatermarkRepo) Update(ctx context.Context, p *models.WatermarkProfile) (*models.WatermarkProfile, error) {
+	return scanWatermarkProfile(r.db.QueryRow(ctx, `
+	UPDATE watermark_profiles
+	SET name = $2, kind = $3, text = $4, placement = $5, scale = $6, opacity = $7, color = $8, updated_at = $9
+	WHERE id = $1
+	RETURNING `+watermarkProfileColumns, p.ID, p.Name, p.Kind, p.Text, p.Placement, p.Scale, p.Opacity, p.Color, time.Now()))
+}
+
+// SetLogo stores the path of the logo image of a watermark profile and returns the profile
+// with the path of the logo it replaced
+func (r *WatermarkRepo) SetLogo(ctx context.Context, id int, path string) (*models.WatermarkProfile, string, error) {
+	var previous string
+	err := r.db.QueryRow(ctx, `SELECT logo_path FROM watermark_profiles WHERE id = $1`, id).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrWatermarkProfileNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := scanWatermarkProfile(r.db.QueryRow(ctx, `
+	UPDATE watermark_profiles SET logo_path = $2, updated_at = $3
+	WHERE id = $1
+	RETURNING `+watermarkProfileColumns, id, path, time.Now()))
+	return p, previous, err
+}
+
+// SetDefault makes a watermark profile the default one
+func (r *WatermarkRepo) SetDefault(ctx context.Context, id int) (*models.WatermarkProfile, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `UPDATE watermark_profiles SET is_default = FALSE WHERE is_default AND id <> $1`, id); err != nil {
+		return nil, err
+	}
+	p, err := scanWatermarkProfile(tx.QueryRow(ctx, `
+	UPDATE watermark_profiles SET is_default = TRUE, updated_at = $2
+	WHERE id = $1
+	RETURNING `+watermarkProfileColumns, id, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit(ctx)
+}
+
+// Delete removes a watermark profile. Its contributors and categories fall back to the default.
+// It returns the path of the profile's logo.
+func (r *WatermarkRepo) Delete(ctx context.Context, id int) (string, error) {
+	var logoPath string
+	var isDefault bool
+	err := r.db.QueryRow(ctx, `SELECT logo_path, is_default FROM watermark_profiles WHERE id = $1`, id).Scan(&logoPath, &isDefault)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrWatermarkProfileNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if isDefault {
+		return "", ErrWatermarkProfileDefault
+	}
+	_, err = r.db.Exec(ctx, `DELETE FROM watermark_profiles WHERE id = $1 AND NOT is_default`, id)
+	return logoPath, err
+}
+
+// assign sets the watermark profile column of a row, profileID nil clears it
+func (r *WatermarkRepo) assign(ctx context.Context, query string, id int, profileID *int) error {
+	tag, err := r.db.Exec(ctx, query, id, profileID, time.Now())
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrWatermarkProfileNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// AssignCategory sets the watermark profile of a media category, nil clears it.
+// It returns pgx.ErrNoRows when the category doesn't exist.
+func (r *WatermarkRepo) AssignCategory(ctx context.Context, categoryID int, profileID *int) error {
+	return r.assign(ctx, `UPDATE media_categories SET watermark_profile_id = $2, updated_at = $3 WHERE id = $1`, categoryID, profileID)
+}
+
+// AssignContributor sets the watermark profile of a contributor, nil clears it.
+// It returns pgx.ErrNoRows when the user doesn't exist.
+func (r *WatermarkRepo) AssignContributor(ctx context.Context, userID int, profileID *int) error {
+	return r.assign(ctx, `UPDATE users SET watermark_profile_id = $2, updated_at = $3 WHERE id = $1`, userID, profileID)
+}
+
+// MediaUsing returns the media whose watermarked previews are drawn with a profile
+func (r *WatermarkRepo) MediaUsing(ctx context.Context, profileID int) ([]*models.Media, error) {
+	rows, err := r.db.Query(ctx, `
+	SELECT m.id, m.media_uuid, m.license_type
+	FROM medias m
+	WHERE `+effectiveWatermarkSQL+` = $1
+	ORDER BY m.id`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var medias []*models.Media
+	for rows.Next() {
+		m := &models.Media{}
+		if err := rows.Scan(&m.ID, &m.MediaUUID, &m.LicenseType); err != nil {
+			return nil, err
+		}
+		medias = append(medias, m)
+	}
+	return medias, rows.Err()
+}
@@ -0,0 +1,85 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// MemoryStore keeps counters in process memory. State is lost on restart
+// and not shared between API instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*models.LoginAttempt
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]*models.LoginAttempt), lastSweep: time.Now()}
+}
+
+// Get returns a copy of the counters of key
+func (s *MemoryStore) Get(_ context.Context, key string) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	c := *a
+	return &c, nil
+}
+
+// RecordFailure adds a failure to key
+func (s *MemoryStore) RecordFailure(_ context.Context, key string, window time.Duration) (*models.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now, window)
+
+	a, ok := s.attempts[key]
+	if !ok || now.Sub(a.LastFailureAt) > window {
+		a = &models.LoginAttempt{Key: key}
+		s.attempts[key] = a
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	c := *a
+	return &c, nil
+}
+
+// Block rejects attempts for key until the given time
+func (s *MemoryStore) Block(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.attempts[key]; ok {
+		a.BlockedUntil = &until
+	}
+	return nil
+}
+
+// Reset clears the counters of key
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweep drops counters that are past the window and no longer blocked.
+// It runs at most once per window. The caller must hold s.mu.
+func (s *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+	for key, a := range s.attempts {
+		if now.Sub(a.LastFailureAt) > window && (a.BlockedUntil == nil || now.After(*a.BlockedUntil)) {
+			delete(s.attempts, key)
+		}
+	}
+}
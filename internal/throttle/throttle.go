@@ -0,0 +1,104 @@
+// Package throttle slows down and locks out repeated failed sign-in attempts.
+package throttle
+
+import (
+	"context"
+	"time"
+
+	"github.com/samiulice/photostock/internal/models"
+)
+
+// Store keeps failed attempt counters. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the counters of key, or nil when there are none
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordFailure atomically adds a failure to key. Counters older than window start over.
+	RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginAttempt, error)
+	// Block rejects attempts for key until the given time
+	Block(ctx context.Context, key string, until time.Time) error
+	// Reset clears the counters of key
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy configures when attempts are delayed and when a key is locked out
+type Policy struct {
+	FreeFailures int           // failures allowed before delays start
+	BaseDelay    time.Duration // delay after the first counted failure, doubled for every further one
+	MaxDelay     time.Duration // upper bound of the backoff delay
+	MaxFailures  int           // failures that trigger a lockout, 0 disables lockouts
+	Lockout      time.Duration // duration of a lockout
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// Result describes the outcome of a failed attempt
+type Result struct {
+	Failures   int
+	Locked     bool          // the failure triggered a lockout
+	RetryAfter time.Duration // time until the next attempt is accepted
+}
+
+// Limiter applies a Policy to the counters kept in a Store
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+// New returns a Limiter that applies policy to the counters in store
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Check returns how long the caller must wait before key may attempt again.
+// Zero means the attempt is allowed.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	a, err := l.store.Get(ctx, key)
+	if err != nil || a == nil || a.BlockedUntil == nil {
+		return 0, err
+	}
+	if wait := time.Until(*a.BlockedUntil); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt for key and blocks it according to the policy
+func (l *Limiter) Fail(ctx context.Context, key string) (Result, error) {
+	a, err := l.store.RecordFailure(ctx, key, l.policy.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Failures: a.Failures}
+	switch {
+	case l.policy.MaxFailures > 0 && a.Failures >= l.policy.MaxFailures:
+		// lock on reaching the limit and again on every failure after a lockout ran out
+		res.Locked = true
+		res.RetryAfter = l.policy.Lockout
+	case a.Failures > l.policy.FreeFailures:
+		res.RetryAfter = l.backoff(a.Failures - l.policy.FreeFailures)
+	default:
+		return res, nil
+	}
+
+	if err := l.store.Block(ctx, key, a.LastFailureAt.Add(res.RetryAfter)); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// Reset forgets the failures of key, e.g. after a successful sign-in or an admin unlock
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+// backoff returns BaseDelay doubled n-1 times, capped at MaxDelay
+func (l *Limiter) backoff(n int) time.Duration {
+	d := l.policy.BaseDelay
+	for i := 1; i < n && d < l.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if l.policy.MaxDelay > 0 && d > l.policy.MaxDelay {
+		d = l.policy.MaxDelay
+	}
+	return d
+}
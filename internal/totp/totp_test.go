@@ -0,0 +1,116 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the base32 form of the ASCII key "12345678901234567890" used by the SHA-1
+// test vectors of RFC 4226 and RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 4226 Appendix D: HOTP values of the first counters
+func TestCodeHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := Code(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != code {
+			t.Errorf("Code(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 Appendix B, SHA-1 rows. The RFC lists 8 digit codes, 6 digit codes are their last digits.
+func TestCodeTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-Digits:]
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	want, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1); err != nil || got != want {
+		t.Errorf("Code of a lower case secret = %s, %v, want %s", got, err, want)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	for offset := int64(-Skew - 2); offset <= Skew+2; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		accepted := offset >= -Skew && offset <= Skew
+		if ok != accepted {
+			t.Errorf("code of step %+d: accepted = %v, want %v", offset, ok, accepted)
+			continue
+		}
+		if ok && step != current+offset {
+			t.Errorf("code of step %+d: step = %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := Validate(rfcSecret, " 287 082 ", now); !ok {
+		t.Error("Validate rejected a code with spaces")
+	}
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("recovery code %q is not formatted XXXXX-XXXXX", c)
+		}
+		n := NormalizeRecoveryCode(" " + strings.ToLower(c) + " ")
+		if n != strings.ReplaceAll(c, "-", "") {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", c, n)
+		}
+		if seen[c] {
+			t.Errorf("recovery code %q repeated", c)
+		}
+		seen[c] = true
+	}
+}
@@ -11,8 +11,11 @@ import (
 )
 
 func GetFormattedFileSize(fileHeader *multipart.FileHeader) string {
-	size := fileHeader.Size
+	return FormatFileSize(fileHeader.Size)
+}
 
+// FormatFileSize formats a byte count for display, e.g. "1.25 MB"
+func FormatFileSize(size int64) string {
 	const (
 		_          = iota
 		KB float64 = 1 << (10 * iota)
@@ -81,21 +84,26 @@ func GetFileType(fileHeader *multipart.FileHeader) string {
 	return "Others"
 }
 
-
 // GetImageResolutionFromFileHeader returns resolution as "WIDTHxHEIGHTpx"
 func GetImageResolutionString(fileHeader *multipart.FileHeader) string {
+	width, height := GetImageDimensions(fileHeader)
+	if width == 0 {
+		return "Unknown"
+	}
+	return fmt.Sprintf("%dx%dpx", width, height)
+}
+
+// GetImageDimensions returns the width and height of an uploaded image, 0x0 if it can't be decoded
+func GetImageDimensions(fileHeader *multipart.FileHeader) (int, int) {
 	file, err := fileHeader.Open()
 	if err != nil {
-		return "Unknown"
+		return 0, 0
 	}
 	defer file.Close()
 
 	imgCfg, _, err := image.DecodeConfig(file)
 	if err != nil {
-		return "Unknown"
+		return 0, 0
 	}
-
-	return fmt.Sprintf("%dx%dpx", imgCfg.Width, imgCfg.Height)
+	return imgCfg.Width, imgCfg.Height
 }
-
- 
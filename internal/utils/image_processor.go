@@ -15,6 +15,7 @@ const (
 	WatermarkFontSize = 48
 	WatermarkOpacity  = 0.5 // overall alpha; color will be black or white
 	WatermarkMaxWidth = 1200
+	WatermarkMaxSize  = 720 // bounding box of the saved watermarked copy
 	FontFile          = "./assets/fonts/arial.ttf"
 )
 
@@ -39,90 +40,217 @@ func getAverageBrightness(img image.Image, step int) float64 {
 	return sum / count
 }
 
-// generateWatermarked resizes the input image, determines the appropriate watermark
-// color based on image brightness, tiles the watermark text diagonally at 45°,
-// and saves the result as a PNG (to preserve alpha transparency).
-func generateWatermarked(original image.Image, outputPath string) error {
+// Watermark placements
+const (
+	PlacementTile        = "tile"
+	PlacementCenter      = "center"
+	PlacementTopLeft     = "top_left"
+	PlacementTopRight    = "top_right"
+	PlacementBottomLeft  = "bottom_left"
+	PlacementBottomRight = "bottom_right"
+)
+
+// Watermark color strategies, they only apply to text
+const (
+	ColorAuto  = "auto"  // white on dark images, black on bright ones
+	ColorLight = "light" // always white
+	ColorDark  = "dark"  // always black
+)
+
+// Watermark describes how previews are branded: a text or a logo, tiled or placed once
+type Watermark struct {
+	Text      string
+	Logo      image.Image // used instead of Text when set
+	Placement string
+	Scale     float64 // text height or logo width relative to the image width
+	Opacity   float64 // 0-1
+	Color     string
+}
+
+// DefaultWatermark is the tiled "Photostock" text used when no profile applies
+var DefaultWatermark = Watermark{
+	Text:      WatermarkText,
+	Placement: PlacementTile,
+	Scale:     float64(WatermarkFontSize) / WatermarkMaxWidth,
+	Opacity:   WatermarkOpacity,
+	Color:     ColorAuto,
+}
+
+// generateWatermarked resizes the input image to a working width, draws the watermark
+// and saves the result scaled down to fit WatermarkMaxSize.
+func generateWatermarked(original image.Image, outputPath string, wm *Watermark) error {
+	if wm == nil {
+		wm = &DefaultWatermark
+	}
 	// Step 1: Resize original image to max width (temporary working size)
 	resized := imaging.Resize(original, WatermarkMaxWidth, 0, imaging.Lanczos)
 
-	// Step 2: Compute brightness and choose watermark color
-	brightness := getAverageBrightness(resized, 20)
-	var r, g, b float64
-	if brightness < 0.5 {
-		r, g, b = 1.0, 1.0, 1.0 // white
+	// Step 2: Draw the logo or the text
+	var watermarked image.Image
+	if wm.Logo != nil {
+		watermarked = drawLogo(resized, wm)
 	} else {
-		r, g, b = 0.0, 0.0, 0.0 // black
+		var err error
+		if watermarked, err = drawText(resized, wm); err != nil {
+			return err
+		}
+	}
+
+	// Step 3: Downscale to the preview size
+	final := imaging.Fit(watermarked, WatermarkMaxSize, WatermarkMaxSize, imaging.Lanczos)
+
+	if err := imaging.Save(final, outputPath); err != nil {
+		return fmt.Errorf("saving watermarked image: %w", err)
+	}
+	return nil
+}
+
+// watermarkMargin is the distance of a placed watermark from the image edges, relative to the width
+const watermarkMargin = 0.03
+
+// drawText draws the watermark text, tiled diagonally at 45° or once at its placement
+func drawText(img *image.NRGBA, wm *Watermark) (image.Image, error) {
+	w := img.Bounds().Dx()
+	h := img.Bounds().Dy()
+
+	// Choose the text color
+	var r, g, b float64
+	switch wm.Color {
+	case ColorLight:
+		r, g, b = 1.0, 1.0, 1.0
+	case ColorDark:
+		r, g, b = 0.0, 0.0, 0.0
+	default:
+		if getAverageBrightness(img, 20) < 0.5 {
+			r, g, b = 1.0, 1.0, 1.0 // white
+		}
 	}
 
-	// Step 3: Create drawing context
-	w := resized.Bounds().Dx()
-	h := resized.Bounds().Dy()
 	dc := gg.NewContext(w, h)
-	dc.DrawImage(resized, 0, 0)
+	dc.DrawImage(img, 0, 0)
+	fontSize := max(8, wm.Scale*float64(w))
+	if err := dc.LoadFontFace(FontFile, fontSize); err != nil {
+		return nil, fmt.Errorf("load font: %w", err)
+	}
+	dc.SetRGBA(r, g, b, wm.Opacity)
 
-	// Step 4: Load font
-	if err := dc.LoadFontFace(FontFile, WatermarkFontSize); err != nil {
-		return fmt.Errorf("load font: %w", err)
+	if wm.Placement == PlacementTile {
+		stepX := int(fontSize * 6)
+		stepY := int(fontSize * 8)
+		dc.RotateAbout(gg.Radians(-45), float64(w)/2, float64(h)/2)
+		for y := -h; y < h*2; y += stepX {
+			for x := -w; x < w*2; x += stepY {
+				dc.DrawStringAnchored(wm.Text, float64(x), float64(y), 0.5, 0.5)
+			}
+		}
+		return dc.Image(), nil
 	}
 
-	// Step 5: Set dynamic watermark style
-	dc.SetRGBA(r, g, b, WatermarkOpacity)
+	tw, th := dc.MeasureString(wm.Text)
+	x, y := placementPoint(wm.Placement, w, h, int(tw), int(th))
+	// DrawString positions the baseline
+	dc.DrawString(wm.Text, float64(x), float64(y)+th)
+	return dc.Image(), nil
+}
 
-	// Step 6: Tiled watermark text (rotated 45°)
-	stepX := int(float64(WatermarkFontSize) * 6)
-	stepY := int(float64(WatermarkFontSize) * 8)
-	dc.RotateAbout(gg.Radians(-45), float64(w)/2, float64(h)/2)
+// drawLogo overlays the watermark logo, tiled on a grid or once at its placement
+func drawLogo(img *image.NRGBA, wm *Watermark) image.Image {
+	w := img.Bounds().Dx()
+	h := img.Bounds().Dy()
+	logo := imaging.Resize(wm.Logo, max(1, int(wm.Scale*float64(w))), 0, imaging.Lanczos)
+	lw := logo.Bounds().Dx()
+	lh := logo.Bounds().Dy()
 
-	for y := -h; y < h*2; y += stepX {
-		for x := -w; x < w*2; x += stepY {
-			dc.DrawStringAnchored(WatermarkText, float64(x), float64(y), 0.5, 0.5)
+	if wm.Placement == PlacementTile {
+		out := img
+		// Offset every other row so the tiles don't line up in columns
+		for row, y := 0, 0; y < h; row, y = row+1, y+lh*2 {
+			for x := -(row % 2) * lw; x < w; x += lw * 2 {
+				out = imaging.Overlay(out, logo, image.Pt(x, y), wm.Opacity)
+			}
 		}
+		return out
 	}
 
-	// Step 7: Downscale to max 720×720
-	watermarked := dc.Image()
-	final := imaging.Fit(watermarked, 720, 720, imaging.Lanczos)
+	x, y := placementPoint(wm.Placement, w, h, lw, lh)
+	return imaging.Overlay(img, logo, image.Pt(x, y), wm.Opacity)
+}
 
-	// Step 8: Save final image
-	if err := imaging.Save(final, outputPath); err != nil {
-		return fmt.Errorf("saving watermarked image: %w", err)
+// placementPoint returns the top left corner of a w x h watermark placed on an imgW x imgH image
+func placementPoint(placement string, imgW, imgH, w, h int) (int, int) {
+	margin := int(watermarkMargin * float64(imgW))
+	left, right := margin, imgW-w-margin
+	top, bottom := margin, imgH-h-margin
+	switch placement {
+	case PlacementTopLeft:
+		return left, top
+	case PlacementTopRight:
+		return right, top
+	case PlacementBottomLeft:
+		return left, bottom
+	case PlacementBottomRight:
+		return right, bottom
+	default:
+		return (imgW - w) / 2, (imgH - h) / 2
 	}
-	return nil
 }
 
 // GenerateImageVariants processes a single image:
 // - generates a thumbnail (300x300)
-// - generates a tiled, dynamically-colored watermark
+// - generates a watermarked preview, wm nil uses DefaultWatermark
+// - computes its perceptual hash (see DHash)
 // Outputs go to "thumbnails" and "watermarked" directories.
-func GenerateImageVariants(originalPath, outputBaseDir, baseName string) error {
+// The variants are rotated upright and re-encoded from pixels, so they carry none of
+// the original's EXIF, IPTC or XMP metadata (GPS position, camera serials, names).
+func GenerateImageVariants(originalPath, outputBaseDir, baseName string, wm *Watermark) (phash uint64, err error) {
 	thumbDir := filepath.Join(outputBaseDir, "thumbnails")
 	wmDir := filepath.Join(outputBaseDir, "watermarked")
 	for _, d := range []string{thumbDir, wmDir} {
 		if err := os.MkdirAll(d, 0750); err != nil {
-			return err
+			return 0, err
 		}
 	}
 
 	img, err := imaging.Open(originalPath, imaging.AutoOrientation(true))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Generate thumbnail
 	thumb := imaging.Thumbnail(img, 300, 300, imaging.Lanczos)
 	thumbPath := filepath.Join(thumbDir, "thumb_"+baseName)
 	if err := imaging.Save(thumb, thumbPath); err != nil {
-		return err
+		return 0, err
 	}
 
-	// Generate dynamically-colored, text watermark
+	// Generate watermarked preview
 	wmPath := filepath.Join(wmDir, "wm_"+baseName)
-	if err := generateWatermarked(img, wmPath); err != nil {
+	if err := generateWatermarked(img, wmPath, wm); err != nil {
+		return 0, err
+	}
+
+	return DHash(img), nil
+}
+
+// RegenerateWatermarked redraws the watermarked preview of an original with another watermark.
+// The preview is replaced in one step, so it is never served half written.
+func RegenerateWatermarked(originalPath, outputPath string, wm *Watermark) error {
+	img, err := imaging.Open(originalPath, imaging.AutoOrientation(true))
+	if err != nil {
+		return err
+	}
+	// Keep the extension, imaging picks the encoder from it
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".tmp-*-"+filepath.Base(outputPath))
+	if err != nil {
 		return err
 	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
 
-	return nil
+	if err := generateWatermarked(img, tmp.Name(), wm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outputPath)
 }
 
 // ResizeImage resizes an image to the given width and height.
@@ -163,6 +291,89 @@ func ResizeImage(inputPath, outputPath string, width, height int, override bool)
 	return nil
 }
 
+// FitWithin scales width x height down, keeping the aspect ratio, so the longest edge is at most maxEdge.
+// Images that already fit are returned unchanged.
+func FitWithin(width, height, maxEdge int) (int, int) {
+	longest := max(width, height)
+	if longest <= maxEdge || longest == 0 {
+		return width, height
+	}
+	scale := float64(maxEdge) / float64(longest)
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// GenerateRendition writes a resized copy of an image to outputPath. The copy is written to a
+// temporary file first, so concurrent readers never see a partial rendition.
+func GenerateRendition(inputPath, outputPath string, width, height int) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	// Keep the extension, imaging picks the encoder from it
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".tmp-*-"+filepath.Base(outputPath))
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := ResizeImage(inputPath, tmp.Name(), width, height, true); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outputPath)
+}
+
+// Fit modes of TransformImage
+const (
+	FitContain = "contain" // scale down to fit inside the box, keeping the aspect ratio
+	FitCover   = "cover"   // scale down and crop from the center to fill the box
+)
+
+// TransformImage resizes img to a width x height box. When width or height is 0 only the
+// other one is constrained. Images are never enlarged: a cover box larger than the image
+// is shrunk to the largest box of the same aspect ratio that fits.
+func TransformImage(img image.Image, width, height int, fit string) image.Image {
+	b := img.Bounds()
+	if width == 0 || height == 0 || fit != FitCover {
+		if width == 0 {
+			width = b.Dx()
+		}
+		if height == 0 {
+			height = b.Dy()
+		}
+		return imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+	if scale := min(float64(b.Dx())/float64(width), float64(b.Dy())/float64(height)); scale < 1 {
+		width = max(1, int(float64(width)*scale))
+		height = max(1, int(float64(height)*scale))
+	}
+	return imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+}
+
+// WithJPEGMetadata returns the JPEG encoded with the EXIF and XMP (APP1), ICC color profile
+// (APP2) and IPTC (APP13) segments of the JPEG original, which the encoder drops.
+// Pixels must be in the original's stored orientation for its EXIF orientation to stay right.
+func WithJPEGMetadata(encoded, original []byte) []byte {
+	if len(encoded) < 2 || len(original) < 2 || original[0] != 0xFF || original[1] != 0xD8 {
+		return encoded
+	}
+	out := append([]byte{}, encoded[:2]...) // SOI
+	for i := 2; i+4 <= len(original); {
+		marker := original[i+1]
+		if original[i] != 0xFF || marker == 0xDA || marker == 0xD9 {
+			break // image data
+		}
+		n := int(original[i+2])<<8 | int(original[i+3])
+		if n < 2 || i+2+n > len(original) {
+			break
+		}
+		if marker == 0xE1 || marker == 0xE2 || marker == 0xED {
+			out = append(out, original[i:i+2+n]...)
+		}
+		i += 2 + n
+	}
+	return append(out, encoded[2:]...)
+}
+
 // ResizeImageInPlace resizes an image and overwrites the original file.
 // If height == 0, it preserves aspect ratio.
 func ResizeImageInPlace(filePath string, width, height int) error {
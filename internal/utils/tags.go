@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxTagLength is the longest tag name accepted
+const MaxTagLength = 50
+
+// NormalizeTag cleans up a tag name and returns it with its slug.
+// Names are lowercased with whitespace collapsed; the slug keeps letters and digits
+// joined by hyphens. Both are empty when nothing usable is left.
+func NormalizeTag(name string) (string, string) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	name = strings.Trim(name, "#,;")
+	if len([]rune(name)) > MaxTagLength {
+		name = strings.TrimSpace(string([]rune(name)[:MaxTagLength]))
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slug := strings.Join(words, "-")
+	if slug == "" {
+		return "", ""
+	}
+	return name, slug
+}
+
+// NormalizeTags normalizes a list of tag names, dropping empty ones and duplicates by slug
+func NormalizeTags(names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		name, slug := NormalizeTag(n)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		out = append(out, name)
+	}
+	return out
+}
@@ -4,6 +4,9 @@ CREATE SCHEMA public;
 GRANT ALL ON SCHEMA public TO photostock_db_user;
 GRANT ALL ON SCHEMA public TO public;
 
+-- Trigram matching for typo tolerant search suggestions
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 -- Create independent tables first (no foreign keys)
 CREATE TABLE subscription_plans (
     id SERIAL PRIMARY KEY,
@@ -12,21 +15,124 @@ CREATE TABLE subscription_plans (
     status BOOLEAN DEFAULT TRUE,
     price NUMERIC(20,2) DEFAULT 0,
     download_limit INTEGER DEFAULT 0,
+    download_sizes TEXT[] NOT NULL DEFAULT '{}',  -- size tiers included (small, medium, large, original), empty means all
     expires_at INTEGER DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- How watermarked previews are branded. The default profile applies to media whose
+-- contributor and category have none
+CREATE TABLE watermark_profiles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'text',        -- text or logo
+    text VARCHAR(100) NOT NULL DEFAULT '',
+    logo_path TEXT NOT NULL DEFAULT '',              -- PNG logo under assets/watermarks
+    placement VARCHAR(20) NOT NULL DEFAULT 'tile',   -- tile, center, top_left, top_right, bottom_left or bottom_right
+    scale DOUBLE PRECISION NOT NULL DEFAULT 0.04,    -- text height or logo width relative to the image width
+    opacity DOUBLE PRECISION NOT NULL DEFAULT 0.5,
+    color VARCHAR(10) NOT NULL DEFAULT 'auto',       -- auto (from the image brightness), light or dark
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT chk_watermark_kind CHECK (kind IN ('text', 'logo')),
+    CONSTRAINT chk_watermark_placement CHECK (placement IN ('tile', 'center', 'top_left', 'top_right', 'bottom_left', 'bottom_right')),
+    CONSTRAINT chk_watermark_scale CHECK (scale > 0 AND scale <= 1),
+    CONSTRAINT chk_watermark_opacity CHECK (opacity > 0 AND opacity <= 1),
+    CONSTRAINT chk_watermark_color CHECK (color IN ('auto', 'light', 'dark'))
+);
+
+INSERT INTO watermark_profiles (name, kind, text, placement, scale, opacity, color, is_default) VALUES
+    ('Photostock', 'text', 'Photostock', 'tile', 0.04, 0.5, 'auto', TRUE);
+
 CREATE TABLE media_categories (
     id SERIAL PRIMARY KEY,
     name VARCHAR(100) UNIQUE NOT NULL DEFAULT '',
     thumbnail_uuid TEXT DEFAULT '',
     total_uploads INTEGER DEFAULT 0,
     total_downloads INTEGER DEFAULT 0,
+    watermark_profile_id INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_category_watermark FOREIGN KEY (watermark_profile_id)
+        REFERENCES watermark_profiles (id) ON DELETE SET NULL
+);
+
+-- Managed list of reasons a reviewer can pick when rejecting media
+CREATE TABLE rejection_reasons (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    title VARCHAR(100) NOT NULL DEFAULT '',
+    description TEXT DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+INSERT INTO rejection_reasons (code, title, description) VALUES
+    ('low_quality', 'Low technical quality', 'The image is blurry, noisy, badly exposed or too small.'),
+    ('copyright', 'Copyright or trademark concern', 'The image shows protected work, logos or brands without a release.'),
+    ('missing_release', 'Missing model or property release', 'Identifiable people or private property need a signed release.'),
+    ('inappropriate', 'Inappropriate content', 'The content violates the content guidelines.'),
+    ('duplicate', 'Duplicate submission', 'The same or a very similar image is already in the catalog.'),
+    ('metadata', 'Inaccurate title, description or tags', 'The metadata does not describe the image.');
+
+-- Roles and permissions. users.role references roles.name
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER NOT NULL,
+    permission_id INTEGER NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT fk_role_permission_role FOREIGN KEY (role_id)
+        REFERENCES roles (id) ON DELETE CASCADE,
+    CONSTRAINT fk_role_permission_permission FOREIGN KEY (permission_id)
+        REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access'),
+    ('user', 'Regular customer and uploader'),
+    ('contributor', 'Uploads media'),
+    ('moderator', 'Reviews uploaded media'),
+    ('finance', 'Reads payments and history reports'),
+    ('support', 'Helps users with their accounts');
+
+INSERT INTO permissions (name, description) VALUES
+    ('media:upload', 'Upload new media'),
+    ('media:approve', 'Review and moderate uploaded media'),
+    ('category:manage', 'Create, update and delete media categories'),
+    ('plan:manage', 'Create and update subscription plans'),
+    ('reports:read', 'Read history and subscriptions of every user'),
+    ('users:manage', 'Manage user accounts'),
+    ('roles:manage', 'Manage roles and role assignments'),
+    ('users:impersonate', 'Act as another user to reproduce what they see'),
+    ('tag:manage', 'Rename, merge and ban tags'),
+    ('watermark:manage', 'Manage watermark profiles and regenerate previews'),
+    ('downloads:trace', 'Trace leaked premium images back to their download'),
+    ('jobs:manage', 'Inspect and retry background jobs');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin'
+   OR (r.name IN ('user', 'contributor') AND p.name = 'media:upload')
+   OR (r.name = 'moderator' AND p.name IN ('media:approve', 'tag:manage'))
+   OR (r.name = 'finance' AND p.name = 'reports:read')
+   OR (r.name = 'support' AND p.name IN ('reports:read', 'users:manage', 'users:impersonate'));
+
 -- Create users without subscription_id FK
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
@@ -35,7 +141,7 @@ CREATE TABLE users (
     name VARCHAR(100) DEFAULT '',
     avatar_url TEXT DEFAULT '',
     status BOOLEAN DEFAULT TRUE,
-    role VARCHAR(50) DEFAULT 'user',
+    role VARCHAR(50) NOT NULL DEFAULT 'user',
     email VARCHAR(100) UNIQUE NOT NULL DEFAULT '',
     mobile VARCHAR(100) DEFAULT '',
     total_earnings NUMERIC(20,2) DEFAULT 0,
@@ -43,8 +149,14 @@ CREATE TABLE users (
     total_expenses NUMERIC(20,2) DEFAULT 0,
     address TEXT DEFAULT '',
     subscription_id INTEGER DEFAULT NULL, -- Will add FK later
+    watermark_profile_id INTEGER,         -- contributor branding, wins over the category's
+    email_verified_at TIMESTAMP DEFAULT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_user_role FOREIGN KEY (role)
+        REFERENCES roles (name) ON UPDATE CASCADE,
+    CONSTRAINT fk_user_watermark FOREIGN KEY (watermark_profile_id)
+        REFERENCES watermark_profiles (id) ON DELETE SET NULL
 );
 
 -- Create subscriptions (depends on users and subscription_plans)
@@ -85,32 +197,138 @@ CREATE TABLE medias (
     file_name VARCHAR(255) NOT NULL DEFAULT '',
     file_size VARCHAR(50) NOT NULL DEFAULT '',
     resolution VARCHAR(50) DEFAULT '',  -- e.g. "1920x1080px"
+    width INTEGER NOT NULL DEFAULT 0,   -- pixels, used by resolution and orientation filters
+    height INTEGER NOT NULL DEFAULT 0,
+    search_vector TSVECTOR,             -- full-text search document, maintained by trg_medias_search_vector
+    status VARCHAR(20) NOT NULL DEFAULT 'pending_review', -- draft, pending_review, approved or rejected
+    rejection_reason_id INTEGER,
+    rejection_note TEXT NOT NULL DEFAULT '',
+    reviewed_by INTEGER,
+    reviewed_at TIMESTAMP DEFAULT NULL,
+    sha256 CHAR(64),                    -- digest of the original, rejects exact duplicates
+    phash BIGINT,                       -- 64-bit perceptual hash (dHash) stored as a signed integer, compared with bit_count (PostgreSQL 14+)
+    near_duplicate_of INTEGER,          -- closest existing media within the duplicate distance, flagged for review
+    processing_status VARCHAR(20) NOT NULL DEFAULT 'ready', -- queued, processing, ready or failed: previews and renditions
+    processing_error TEXT NOT NULL DEFAULT '',                -- error of the last failed processing attempt
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     CONSTRAINT fk_media_category FOREIGN KEY (category_id)
         REFERENCES media_categories (id) ON DELETE SET NULL,
     CONSTRAINT fk_uploader_user FOREIGN KEY (uploader_id)
-        REFERENCES users (id) ON DELETE SET NULL
+        REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT fk_media_rejection_reason FOREIGN KEY (rejection_reason_id)
+        REFERENCES rejection_reasons (id) ON DELETE SET NULL,
+    CONSTRAINT fk_media_reviewer FOREIGN KEY (reviewed_by)
+        REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT fk_media_near_duplicate FOREIGN KEY (near_duplicate_of)
+        REFERENCES medias (id) ON DELETE SET NULL,
+    CONSTRAINT chk_media_status CHECK (status IN ('draft', 'pending_review', 'approved', 'rejected')),
+    CONSTRAINT chk_media_processing_status CHECK (processing_status IN ('queued', 'processing', 'ready', 'failed'))
+);
+
+-- Every review state change of a media item
+CREATE TABLE media_status_history (
+    id SERIAL PRIMARY KEY,
+    media_id INTEGER NOT NULL,
+    from_status VARCHAR(20) NOT NULL DEFAULT '',
+    to_status VARCHAR(20) NOT NULL,
+    actor_id INTEGER,
+    rejection_reason_id INTEGER,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_status_history_media FOREIGN KEY (media_id)
+        REFERENCES medias (id) ON DELETE CASCADE,
+    CONSTRAINT fk_status_history_actor FOREIGN KEY (actor_id)
+        REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT fk_status_history_reason FOREIGN KEY (rejection_reason_id)
+        REFERENCES rejection_reasons (id) ON DELETE SET NULL
+);
+
+-- Background jobs, claimed by the workers of every API instance with FOR UPDATE SKIP LOCKED
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,                      -- e.g. process_media
+    media_id INTEGER,                               -- media the job works on
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',   -- queued, running, done or dead (failed its last attempt)
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- due time, pushed back after a failed attempt
+    locked_at TIMESTAMP DEFAULT NULL,                    -- start of the running attempt
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP DEFAULT NULL,
+    CONSTRAINT fk_job_media FOREIGN KEY (media_id)
+        REFERENCES medias (id) ON DELETE CASCADE,
+    CONSTRAINT chk_job_status CHECK (status IN ('queued', 'running', 'done', 'dead'))
+);
+
+-- Metadata embedded in the uploaded original (EXIF, IPTC, XMP). The public variants never carry it
+CREATE TABLE media_metadata (
+    media_id INTEGER PRIMARY KEY,
+    camera_make VARCHAR(100) NOT NULL DEFAULT '',
+    camera_model VARCHAR(100) NOT NULL DEFAULT '',
+    lens_model VARCHAR(100) NOT NULL DEFAULT '',
+    exposure_time VARCHAR(20) NOT NULL DEFAULT '',
+    f_number DOUBLE PRECISION NOT NULL DEFAULT 0,
+    iso INTEGER NOT NULL DEFAULT 0,
+    focal_length DOUBLE PRECISION NOT NULL DEFAULT 0,
+    captured_at TIMESTAMP,
+    orientation SMALLINT NOT NULL DEFAULT 0,
+    gps_latitude DOUBLE PRECISION,
+    gps_longitude DOUBLE PRECISION,
+    gps_altitude DOUBLE PRECISION,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    keywords TEXT[] NOT NULL DEFAULT '{}',
+    copyright TEXT NOT NULL DEFAULT '',
+    creator TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_media_metadata_media FOREIGN KEY (media_id)
+        REFERENCES medias (id) ON DELETE CASCADE
+);
+
+-- Keyword tags. media_count is maintained by the application in the same transaction as media_tags
+-- and counts media in every review state, public listings count approved media at query time
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    slug VARCHAR(60) UNIQUE NOT NULL,   -- normalized form used in URLs and for de-duplication
+    media_count INTEGER NOT NULL DEFAULT 0,
+    banned BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE media_tags (
+    media_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (media_id, tag_id),
+    CONSTRAINT fk_media_tag_media FOREIGN KEY (media_id)
+        REFERENCES medias (id) ON DELETE CASCADE,
+    CONSTRAINT fk_media_tag_tag FOREIGN KEY (tag_id)
+        REFERENCES tags (id) ON DELETE CASCADE
 );
 
 -- Create history tables (depend on users and medias)
 CREATE TABLE download_history (
     id SERIAL PRIMARY KEY,
     media_uuid VARCHAR(255) NOT NULL DEFAULT '',
-    user_id INTEGER NOT NULL,
+    user_id INTEGER,                    -- NULL once the account is deleted, the record still traces leaks
     price NUMERIC(10, 2) DEFAULT 0,
     file_type VARCHAR(50) NOT NULL DEFAULT '',
     file_ext VARCHAR(50) NOT NULL DEFAULT '',
     file_name VARCHAR(255) NOT NULL DEFAULT '',
     file_size VARCHAR(50) NOT NULL DEFAULT '',
     resolution VARCHAR(50) DEFAULT '',  -- e.g. "1920x1080px"
+    size VARCHAR(20) NOT NULL DEFAULT 'original',  -- download size tier
+    fingerprinted BOOLEAN NOT NULL DEFAULT FALSE,  -- the delivered file embeds this record's id
     downloaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT fk_download_media FOREIGN KEY (media_uuid)
-        REFERENCES medias (media_uuid) ON DELETE CASCADE,
+    -- no FK to medias: buyers keep their download records after the media is deleted
     CONSTRAINT fk_download_user FOREIGN KEY (user_id)
-        REFERENCES users (id) ON DELETE CASCADE
+        REFERENCES users (id) ON DELETE SET NULL
 );
 
 CREATE TABLE upload_history (
@@ -133,6 +351,214 @@ CREATE TABLE upload_history (
         REFERENCES users (id) ON DELETE CASCADE
 );
 
+-- Login sessions, one per sign-in. The id is the sid claim of access tokens
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    user_agent TEXT DEFAULT '',
+    ip_address VARCHAR(64) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,      -- expiry of the newest refresh token
+    revoked_at TIMESTAMP DEFAULT NULL,  -- set on sign-out
+    CONSTRAINT fk_session_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Refresh tokens (hashed). Tokens issued for one session share a family_id
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    family_id UUID NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    mfa BOOLEAN DEFAULT FALSE,          -- login completed a second factor
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,     -- set when the token is rotated
+    revoked_at TIMESTAMP DEFAULT NULL,  -- set on logout or reuse detection
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_refresh_token_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_refresh_token_session FOREIGN KEY (family_id)
+        REFERENCES sessions (id) ON DELETE CASCADE
+);
+
+-- Password reset tokens (hashed, single use)
+CREATE TABLE password_resets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_password_reset_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- TOTP two-factor authentication. secret is encrypted; enabled_at is NULL until confirmed
+CREATE TABLE user_mfa (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled_at TIMESTAMP DEFAULT NULL,
+    last_used_step BIGINT DEFAULT 0,    -- last accepted TOTP step, prevents code replay
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_user_mfa_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_mfa_recovery_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Personal API keys (hashed) for programmatic access
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    label VARCHAR(100) NOT NULL DEFAULT '',
+    prefix VARCHAR(20) NOT NULL DEFAULT '',
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',   -- e.g. {media:read,media:write,history:read}
+    last_used_at TIMESTAMP DEFAULT NULL,
+    expires_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_api_key_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Failed sign-in counters shared by all API instances. key is "account:<email>" or "ip:<address>"
+CREATE TABLE login_attempts (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    blocked_until TIMESTAMP DEFAULT NULL
+);
+
+-- Security audit trail
+CREATE TABLE audit_logs (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER DEFAULT NULL,      -- user performing the action, NULL for the system
+    action VARCHAR(100) NOT NULL,       -- e.g. "login.lockout"
+    target_type VARCHAR(50) DEFAULT '',
+    target_id VARCHAR(255) DEFAULT '',
+    ip_address VARCHAR(64) DEFAULT '',
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_audit_log_actor FOREIGN KEY (actor_id)
+        REFERENCES users (id) ON DELETE SET NULL
+);
+
+-- Access token signing keys. Retired keys keep verifying until expires_at
+CREATE TABLE signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,     -- RS256 or EdDSA
+    private_key TEXT NOT NULL,          -- encrypted PKCS#8 PEM
+    public_key TEXT NOT NULL,           -- PKIX PEM
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    retired_at TIMESTAMP DEFAULT NULL,  -- stops signing
+    expires_at TIMESTAMP DEFAULT NULL   -- stops verifying
+);
+
+-- Accounts at external OpenID Connect providers linked to users
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,      -- provider's user id
+    email VARCHAR(100) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP DEFAULT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider),
+    CONSTRAINT fk_user_identity_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Pending OpenID Connect flows (state, nonce and PKCE verifier)
+CREATE TABLE oidc_flows (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    link_user_id INTEGER DEFAULT NULL,  -- set when linking to an existing user
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_oidc_flow_user FOREIGN KEY (link_user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Single use codes handing a finished external sign-in to the web client
+CREATE TABLE oidc_login_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_oidc_login_code_user FOREIGN KEY (user_id)
+        REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Full-text search over media. The document includes the category name and tags, which live
+-- in other tables, so triggers keep it up to date instead of a generated column
+CREATE FUNCTION medias_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', COALESCE(NEW.media_title, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE((SELECT string_agg(t.name, ' ')
+            FROM media_tags mt JOIN tags t ON t.id = mt.tag_id WHERE mt.media_id = NEW.id), '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE((SELECT name FROM media_categories WHERE id = NEW.category_id), '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE(NEW.description, '')), 'C');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_medias_search_vector
+    BEFORE INSERT OR UPDATE OF media_title, description, category_id ON medias
+    FOR EACH ROW EXECUTE FUNCTION medias_search_vector_update();
+
+-- Renaming a category re-indexes its media: setting category_id fires trg_medias_search_vector
+CREATE FUNCTION media_categories_search_refresh() RETURNS trigger AS $$
+BEGIN
+    UPDATE medias SET category_id = category_id WHERE category_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_media_categories_search_refresh
+    AFTER UPDATE OF name ON media_categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION media_categories_search_refresh();
+
+-- Tagging, untagging and renaming a tag re-index the media involved
+CREATE FUNCTION media_tags_search_refresh() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'tags' THEN
+        UPDATE medias SET category_id = category_id
+        WHERE id IN (SELECT media_id FROM media_tags WHERE tag_id = NEW.id);
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE medias SET category_id = category_id WHERE id = OLD.media_id;
+    ELSE
+        UPDATE medias SET category_id = category_id WHERE id = NEW.media_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_media_tags_search_refresh
+    AFTER INSERT OR DELETE ON media_tags
+    FOR EACH ROW EXECUTE FUNCTION media_tags_search_refresh();
+
+CREATE TRIGGER trg_tags_search_refresh
+    AFTER UPDATE OF name ON tags
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION media_tags_search_refresh();
 
 -- Create indexes
 CREATE INDEX idx_users_email ON users (email);
@@ -140,4 +566,31 @@ CREATE INDEX idx_users_role ON users (role);
 CREATE INDEX idx_medias_uuid ON medias (media_uuid);
 CREATE INDEX idx_subscription_user_id ON subscriptions (user_id);
 CREATE INDEX idx_download_user_id ON download_history (user_id);
-CREATE INDEX idx_upload_user_id ON upload_history (user_id);
\ No newline at end of file
+CREATE INDEX idx_upload_user_id ON upload_history (user_id);
+CREATE INDEX idx_audit_logs_actor_id ON audit_logs (actor_id);
+CREATE INDEX idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX idx_audit_logs_action ON audit_logs (action);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_download_media_uuid ON download_history (media_uuid);
+CREATE INDEX idx_medias_created_at ON medias (created_at, id);
+CREATE INDEX idx_medias_total_downloads ON medias (total_downloads, id);
+CREATE INDEX idx_medias_total_earnings ON medias (total_earnings, id);
+CREATE INDEX idx_medias_category_id ON medias (category_id);
+CREATE INDEX idx_medias_search_vector ON medias USING GIN (search_vector);
+CREATE INDEX idx_media_tags_tag_id ON media_tags (tag_id);
+CREATE INDEX idx_tags_media_count ON tags (media_count DESC) WHERE NOT banned;
+CREATE INDEX idx_medias_title_trgm ON medias USING GIN (media_title gin_trgm_ops);
+CREATE INDEX idx_tags_name_trgm ON tags USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_media_categories_name_trgm ON media_categories USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_users_username_trgm ON users USING GIN (username gin_trgm_ops);
+CREATE INDEX idx_medias_uploader_id ON medias (uploader_id);
+CREATE INDEX idx_medias_status ON medias (status, created_at, id);
+CREATE INDEX idx_media_status_history_media_id ON media_status_history (media_id, created_at);
+CREATE UNIQUE INDEX idx_medias_sha256 ON medias (sha256);
+CREATE INDEX idx_medias_phash ON medias (phash) WHERE phash IS NOT NULL;
+CREATE UNIQUE INDEX idx_watermark_profiles_default ON watermark_profiles (is_default) WHERE is_default;
+CREATE INDEX idx_jobs_due ON jobs (run_at, id) WHERE status IN ('queued', 'running');
+CREATE INDEX idx_jobs_media_id ON jobs (media_id, id);
+CREATE INDEX idx_jobs_status ON jobs (status, updated_at);